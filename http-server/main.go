@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"os"
@@ -13,8 +14,11 @@ import (
 )
 
 func main() {
+	configPath := flag.String("config", "", "path to config.json (default: $"+shared.ConfigPathEnv+", or config.json next to the binary)")
+	flag.Parse()
+
 	// Load configuration
-	config, err := shared.LoadConfig("")
+	config, err := shared.LoadConfig(*configPath)
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
@@ -43,10 +47,14 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Start email notification checker
+	// Start email notification checkers
 	// Note: StreamableHTTPServer doesn't support session hooks for client tracking,
 	// so we run the checker continuously (same as email_mock)
-	shared.StartEmailNotificationChecker(ctx, mcpServer, config)
+	checkers := shared.StartEmailNotificationCheckers(ctx, mcpServer, config)
+
+	// Reload the configuration when config.json changes or on SIGHUP
+	reloader := shared.NewConfigReloader(ctx, *configPath, config, mcpServer, checkers)
+	go reloader.Watch()
 
 	// Handle shutdown signals
 	sigChan := make(chan os.Signal, 1)
@@ -71,6 +79,8 @@ func main() {
 	if err := httpServer.Shutdown(context.Background()); err != nil {
 		log.Printf("Server shutdown error: %v", err)
 	}
+	shared.CloseIMAPConnections()
+	shared.FlushCorrespondents()
 
 	log.Println("Server stopped")
 }
@@ -0,0 +1,165 @@
+package shared
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultAccountName names the account formed by the top-level imap, smtp and my_email settings
+const DefaultAccountName = "default"
+
+// AccountSettings is one mailbox account of the accounts list.
+// TLS and OAuth2 fall back to the top-level settings when not given.
+type AccountSettings struct {
+	// Name identifies the account in tool calls (default: my_email)
+	Name       string          `json:"name"`
+	IMAP       IMAPSettings    `json:"imap"`
+	SMTP       SMTPSettings    `json:"smtp"`
+	MyEmail    string          `json:"my_email"`
+	Identities []Identity      `json:"identities"`
+	TLS        *TLSSettings    `json:"tls,omitempty"`
+	OAuth2     *OAuth2Settings `json:"oauth2,omitempty"`
+}
+
+// AccountInfo describes an account for the list_accounts tool
+type AccountInfo struct {
+	Name       string `json:"name"`
+	Email      string `json:"email"`
+	Primary    bool   `json:"primary"`
+	IMAPServer string `json:"imap_server"`
+	SMTPServer string `json:"smtp_server"`
+}
+
+// buildAccounts creates the per-account configs. Each one is a copy of the
+// config with the account's IMAP, SMTP, address and credentials in the
+// top-level fields, so IMAP and SMTP clients work on it unchanged.
+func (c *Config) buildAccounts(problems *configProblems) {
+	type entry struct {
+		settings AccountSettings
+		prefix   string
+	}
+
+	var entries []entry
+	if c.IMAP.Server != "" || c.SMTP.Server != "" || c.MyEmail != "" || len(c.Accounts) == 0 {
+		entries = append(entries, entry{settings: AccountSettings{
+			Name:       DefaultAccountName,
+			IMAP:       c.IMAP,
+			SMTP:       c.SMTP,
+			MyEmail:    c.MyEmail,
+			Identities: c.Identities,
+		}})
+	}
+	for i, settings := range c.Accounts {
+		entries = append(entries, entry{settings: settings, prefix: fmt.Sprintf("accounts[%d].", i)})
+	}
+
+	seen := make(map[string]bool)
+	c.accounts = nil
+	for _, e := range entries {
+		name := e.settings.Name
+		if name == "" {
+			name = e.settings.MyEmail
+		}
+		if name == "" {
+			problems.add(e.prefix+"name", "is required")
+			continue
+		}
+		if seen[strings.ToLower(name)] {
+			problems.add(e.prefix+"name", "duplicate account name %q", name)
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+
+		account := *c
+		account.IMAP = e.settings.IMAP
+		account.SMTP = e.settings.SMTP
+		account.MyEmail = e.settings.MyEmail
+		account.Identities = e.settings.Identities
+		if e.settings.TLS != nil {
+			account.TLS = *e.settings.TLS
+		}
+		if e.settings.OAuth2 != nil {
+			account.OAuth2 = *e.settings.OAuth2
+		}
+		account.Accounts = nil
+		account.accounts = nil
+		account.accountName = name
+
+		account.applyAccountDefaults(e.prefix, problems)
+		c.accounts = append(c.accounts, &account)
+	}
+
+	if len(c.accounts) == 0 {
+		return
+	}
+	if c.PrimaryAccount == "" {
+		c.PrimaryAccount = c.accounts[0].accountName
+	}
+	primary, err := c.Account(c.PrimaryAccount)
+	if err != nil {
+		problems.addErr("primary_account: ", err)
+		return
+	}
+	c.PrimaryAccount = primary.accountName
+
+	// Keep the top-level settings describing the primary account for account-unaware callers
+	c.IMAP, c.SMTP, c.MyEmail = primary.IMAP, primary.SMTP, primary.MyEmail
+	c.Identities = primary.Identities
+	c.TLS, c.OAuth2 = primary.TLS, primary.OAuth2
+	c.accountName = primary.accountName
+}
+
+// AccountName returns the name of the account this config describes
+func (c *Config) AccountName() string {
+	if c.accountName == "" {
+		return DefaultAccountName
+	}
+	return c.accountName
+}
+
+// AccountConfigs returns the per-account configs in configuration order
+func (c *Config) AccountConfigs() []*Config {
+	if len(c.accounts) == 0 {
+		return []*Config{c}
+	}
+	return c.accounts
+}
+
+// Account returns the config of the named account, matched case-insensitively
+// by name or email address. An empty name selects the primary account.
+func (c *Config) Account(name string) (*Config, error) {
+	if name == "" {
+		name = c.PrimaryAccount
+	}
+
+	accounts := c.AccountConfigs()
+	if name == "" && len(accounts) == 1 {
+		return accounts[0], nil
+	}
+	for _, account := range accounts {
+		if strings.EqualFold(account.AccountName(), name) || strings.EqualFold(account.MyEmail, name) {
+			return account, nil
+		}
+	}
+
+	var names []string
+	for _, account := range accounts {
+		names = append(names, account.AccountName())
+	}
+	return nil, fmt.Errorf("unknown account %q (available: %s)", name, strings.Join(names, ", "))
+}
+
+// ListAccounts describes all configured accounts
+func (c *Config) ListAccounts() []AccountInfo {
+	var infos []AccountInfo
+	for _, account := range c.AccountConfigs() {
+		infos = append(infos, AccountInfo{
+			Name:       account.AccountName(),
+			Email:      account.MyEmail,
+			Primary:    strings.EqualFold(account.AccountName(), c.PrimaryAccount) || len(c.accounts) == 0,
+			IMAPServer: account.IMAP.Server,
+			SMTPServer: account.SMTP.Server,
+		})
+	}
+	return infos
+}
@@ -0,0 +1,118 @@
+package shared
+
+import (
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// AttachmentSpec describes an attachment requested by a tool call: either
+// base64 content with a filename, or a path inside the configured attachments directory
+type AttachmentSpec struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Content     string `json:"content_base64"`
+	Path        string `json:"path"`
+}
+
+// LoadAttachments turns attachment specs into outgoing attachments, decoding
+// inline content and reading files from the sandboxed attachments directory
+func LoadAttachments(config *Config, specs []AttachmentSpec) ([]OutgoingAttachment, error) {
+	var attachments []OutgoingAttachment
+	for i, spec := range specs {
+		attachment, err := loadAttachment(config, spec)
+		if err != nil {
+			return nil, fmt.Errorf("attachment %d: %w", i+1, err)
+		}
+		attachments = append(attachments, *attachment)
+	}
+	return attachments, nil
+}
+
+// loadAttachment resolves a single attachment spec
+func loadAttachment(config *Config, spec AttachmentSpec) (*OutgoingAttachment, error) {
+	var data []byte
+	filename := spec.Filename
+
+	switch {
+	case spec.Content != "" && spec.Path != "":
+		return nil, fmt.Errorf("give either content_base64 or path, not both")
+	case spec.Content != "":
+		if filename == "" {
+			return nil, fmt.Errorf("filename is required with content_base64")
+		}
+		decoded, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(spec.Content), ""))
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 content: %w", err)
+		}
+		data = decoded
+	case spec.Path != "":
+		path, err := sandboxedAttachmentPath(config, spec.Path)
+		if err != nil {
+			return nil, err
+		}
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", spec.Path, err)
+		}
+		if filename == "" {
+			filename = filepath.Base(path)
+		}
+	default:
+		return nil, fmt.Errorf("either content_base64 or path is required")
+	}
+
+	if limit := int64(config.Attachments.MaxSizeMB) << 20; limit > 0 && int64(len(data)) > limit {
+		return nil, fmt.Errorf("%s is larger than the %d MB limit", filename, config.Attachments.MaxSizeMB)
+	}
+
+	contentType := spec.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(filename))
+	}
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+
+	return &OutgoingAttachment{
+		Filename:    filename,
+		ContentType: contentType,
+		Data:        data,
+	}, nil
+}
+
+// sandboxedAttachmentPath resolves a path relative to the attachments directory
+// and rejects anything that escapes it, including through symlinks
+func sandboxedAttachmentPath(config *Config, path string) (string, error) {
+	if config.Attachments.Directory == "" {
+		return "", fmt.Errorf("file attachments are disabled: no attachments directory configured")
+	}
+
+	root, err := filepath.EvalSymlinks(config.Attachments.Directory)
+	if err != nil {
+		return "", fmt.Errorf("attachments directory is not accessible: %w", err)
+	}
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return "", fmt.Errorf("attachments directory is not accessible: %w", err)
+	}
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("attachment file not found: %s", path)
+	}
+
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("attachment path %s is outside the attachments directory", path)
+	}
+
+	return resolved, nil
+}
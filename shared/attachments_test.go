@@ -0,0 +1,185 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newAttachmentSandbox creates an attachments directory with a file, a
+// subdirectory, a file outside it and symlinks pointing in and out
+func newAttachmentSandbox(t *testing.T) (root, outside string) {
+	t.Helper()
+
+	base := t.TempDir()
+	root = filepath.Join(base, "attachments")
+	outside = filepath.Join(base, "secret.txt")
+	files := map[string]string{
+		filepath.Join(root, "report.pdf"):        "%PDF-1.4",
+		filepath.Join(root, "docs", "notes.txt"): "notes",
+		outside:                                  "secret",
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		filepath.Join(root, "escape.txt"): outside,
+		filepath.Join(root, "escape-dir"): base,
+		filepath.Join(root, "inside.txt"): filepath.Join(root, "docs", "notes.txt"),
+	} {
+		if err := os.Symlink(target, link); err != nil {
+			t.Skipf("symlinks not supported: %v", err)
+		}
+	}
+	return root, outside
+}
+
+func TestSandboxedAttachmentPath(t *testing.T) {
+	root, outside := newAttachmentSandbox(t)
+
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr string
+	}{
+		{name: "file", path: "report.pdf", want: "report.pdf"},
+		{name: "subdirectory", path: "docs/notes.txt", want: "docs/notes.txt"},
+		{name: "dot segments staying inside", path: "docs/../report.pdf", want: "report.pdf"},
+		{name: "absolute path inside", path: filepath.Join(root, "report.pdf"), want: "report.pdf"},
+		{name: "symlink inside", path: "inside.txt", want: "docs/notes.txt"},
+		{name: "parent escape", path: "../secret.txt", wantErr: "outside the attachments directory"},
+		{name: "deep parent escape", path: "docs/../../secret.txt", wantErr: "outside the attachments directory"},
+		{name: "absolute path outside", path: outside, wantErr: "outside the attachments directory"},
+		{name: "symlink to a file outside", path: "escape.txt", wantErr: "outside the attachments directory"},
+		{name: "symlinked directory outside", path: "escape-dir/secret.txt", wantErr: "outside the attachments directory"},
+		{name: "missing file", path: "missing.pdf", wantErr: "not found"},
+		{name: "root itself", path: ".", want: "."},
+	}
+
+	config := &Config{}
+	config.Attachments.Directory = root
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sandboxedAttachmentPath(config, tt.path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("sandboxedAttachmentPath(%q) = %q, %v, want an error containing %q", tt.path, got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("sandboxedAttachmentPath(%q) error: %v", tt.path, err)
+			}
+			resolvedRoot, _ := filepath.EvalSymlinks(root)
+			if want := filepath.Join(resolvedRoot, tt.want); got != want {
+				t.Errorf("sandboxedAttachmentPath(%q) = %q, want %q", tt.path, got, want)
+			}
+		})
+	}
+}
+
+func TestSandboxedAttachmentPathDirectory(t *testing.T) {
+	tests := []struct {
+		name      string
+		directory string
+		wantErr   string
+	}{
+		{name: "not configured", wantErr: "no attachments directory configured"},
+		{name: "missing directory", directory: filepath.Join(t.TempDir(), "missing"), wantErr: "not accessible"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{}
+			config.Attachments.Directory = tt.directory
+			if _, err := sandboxedAttachmentPath(config, "report.pdf"); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("sandboxedAttachmentPath() error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadAttachments(t *testing.T) {
+	root, _ := newAttachmentSandbox(t)
+	big := bytes.Repeat([]byte("x"), 1<<20+1)
+	if err := os.WriteFile(filepath.Join(root, "big.bin"), big, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name            string
+		spec            AttachmentSpec
+		wantFilename    string
+		wantContentType string
+		wantData        string
+		wantErr         string
+	}{
+		{
+			name:            "file from the sandbox",
+			spec:            AttachmentSpec{Path: "report.pdf"},
+			wantFilename:    "report.pdf",
+			wantContentType: "application/pdf",
+			wantData:        "%PDF-1.4",
+		},
+		{
+			name:            "file with a new name and type",
+			spec:            AttachmentSpec{Path: "docs/notes.txt", Filename: "readme.md", ContentType: "text/markdown"},
+			wantFilename:    "readme.md",
+			wantContentType: "text/markdown",
+			wantData:        "notes",
+		},
+		{
+			name:            "base64 content with wrapped lines",
+			spec:            AttachmentSpec{Filename: "hello.txt", Content: "aGVs\nbG8="},
+			wantFilename:    "hello.txt",
+			wantContentType: "text/plain; charset=utf-8",
+			wantData:        "hello",
+		},
+		{
+			name:            "content type sniffed without an extension",
+			spec:            AttachmentSpec{Filename: "blob", Content: base64.StdEncoding.EncodeToString([]byte("%PDF-1.4"))},
+			wantFilename:    "blob",
+			wantContentType: "application/pdf",
+			wantData:        "%PDF-1.4",
+		},
+		{name: "escaping path", spec: AttachmentSpec{Path: "../secret.txt"}, wantErr: "outside the attachments directory"},
+		{name: "symlink out", spec: AttachmentSpec{Path: "escape.txt"}, wantErr: "outside the attachments directory"},
+		{name: "over max_size_mb", spec: AttachmentSpec{Path: "big.bin"}, wantErr: "larger than the 1 MB limit"},
+		{name: "inline content over max_size_mb", spec: AttachmentSpec{Filename: "big.bin", Content: base64.StdEncoding.EncodeToString(big)}, wantErr: "larger than the 1 MB limit"},
+		{name: "both content and path", spec: AttachmentSpec{Path: "report.pdf", Content: "aGVsbG8="}, wantErr: "not both"},
+		{name: "neither content nor path", spec: AttachmentSpec{Filename: "x.txt"}, wantErr: "is required"},
+		{name: "content without filename", spec: AttachmentSpec{Content: "aGVsbG8="}, wantErr: "filename is required"},
+		{name: "invalid base64", spec: AttachmentSpec{Filename: "x.txt", Content: "not base64!"}, wantErr: "invalid base64"},
+	}
+
+	config := &Config{}
+	config.Attachments.Directory = root
+	config.Attachments.MaxSizeMB = 1
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attachments, err := LoadAttachments(config, []AttachmentSpec{tt.spec})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) || !strings.HasPrefix(err.Error(), "attachment 1: ") {
+					t.Fatalf("LoadAttachments() error = %v, want attachment 1 with %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadAttachments() error: %v", err)
+			}
+			got := attachments[0]
+			if got.Filename != tt.wantFilename || got.ContentType != tt.wantContentType || string(got.Data) != tt.wantData {
+				t.Errorf("attachment = %q, %q, %q, want %q, %q, %q", got.Filename, got.ContentType, got.Data, tt.wantFilename, tt.wantContentType, tt.wantData)
+			}
+		})
+	}
+}
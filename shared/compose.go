@@ -0,0 +1,236 @@
+package shared
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message/mail"
+)
+
+// composeMessage builds the RFC 5322 message for an outgoing email.
+// Headers are RFC 2047 encoded where needed and folded, text is sent as
+// quoted-printable and attachments as base64. Bcc recipients are not written.
+// Markdown and HTML bodies are sent as multipart/alternative with a plain text
+// version; inlineCSS styles HTML rendered from markdown. The identity provides
+// the Reply-To header, the default body format and the signature.
+func composeMessage(identity *Identity, from *mail.Address, to, cc []*mail.Address, email *OutgoingEmail, inlineCSS bool) ([]byte, error) {
+	if err := checkHeaderValues(email); err != nil {
+		return nil, err
+	}
+
+	var header mail.Header
+	header.SetDate(time.Now())
+	header.SetAddressList("From", []*mail.Address{from})
+	header.SetAddressList("To", to)
+	header.SetAddressList("Cc", cc)
+	if identity.ReplyTo != "" {
+		replyTo, err := mail.ParseAddressList(identity.ReplyTo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid reply-to address %q: %w", identity.ReplyTo, err)
+		}
+		header.SetAddressList("Reply-To", replyTo)
+	}
+	header.SetSubject(email.Subject)
+	if err := header.GenerateMessageIDWithHostname(addressDomain(from.Address)); err != nil {
+		return nil, fmt.Errorf("failed to generate Message-ID: %w", err)
+	}
+	if email.InReplyTo != "" {
+		header.SetMsgIDList("In-Reply-To", []string{email.InReplyTo})
+	}
+	header.SetMsgIDList("References", email.References)
+
+	bodyFormat := email.BodyFormat
+	if bodyFormat == "" {
+		bodyFormat = identity.BodyFormat
+	}
+	text, htmlBody := renderBody(email.Body, bodyFormat, inlineCSS)
+	var quotedText, quotedHTML string
+	if email.Quoted != "" {
+		quotedText, quotedHTML = renderBody(email.Quoted, bodyFormat, inlineCSS)
+	}
+	// An empty forward note renders no HTML, but the forwarded email does
+	hasHTML := htmlBody != "" || quotedHTML != ""
+	if email.AppendSignature {
+		text, htmlBody = identity.appendSignature(text, htmlBody, hasHTML)
+	}
+	if email.Quoted != "" {
+		text = appendBlock(text, quotedText, "\n\n")
+		htmlBody = appendBlock(htmlBody, quotedHTML, "\n<br>\n")
+	}
+
+	var buf bytes.Buffer
+	if len(email.Attachments) == 0 {
+		if err := writeBody(&buf, header, nil, text, htmlBody); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	mw, err := mail.CreateWriter(&buf, header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write message header: %w", err)
+	}
+	if err := writeBody(nil, header, mw, text, htmlBody); err != nil {
+		return nil, err
+	}
+
+	for _, attachment := range email.Attachments {
+		attachmentType := attachment.ContentType
+		if attachmentType == "" {
+			attachmentType = "application/octet-stream"
+		}
+
+		var attachmentHeader mail.AttachmentHeader
+		attachmentHeader.SetContentType(attachmentType, map[string]string{"name": attachment.Filename})
+		attachmentHeader.SetFilename(attachment.Filename)
+		w, err := mw.CreateAttachment(attachmentHeader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create attachment part: %w", err)
+		}
+		if err := writeAndClose(w, attachment.Data); err != nil {
+			return nil, fmt.Errorf("failed to write attachment %s: %w", attachment.Filename, err)
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish message: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// appendBlock adds block below body, after separator unless body is empty
+func appendBlock(body, block, separator string) string {
+	if body = strings.TrimRight(body, "\n"); body == "" {
+		return block
+	}
+	return body + separator + block
+}
+
+// renderBody returns the plain text and, for markdown and HTML, the HTML version of the body
+func renderBody(body, bodyFormat string, inlineCSS bool) (string, string) {
+	switch strings.ToLower(bodyFormat) {
+	case "html":
+		return htmlToText(body), body
+	case "markdown":
+		htmlBody := markdownToHTML(body)
+		if inlineCSS {
+			htmlBody = styleMarkdownHTML(htmlBody)
+		}
+		// The markdown source is already readable as plain text
+		return body, htmlBody
+	default:
+		return body, ""
+	}
+}
+
+// writeBody writes the text of a message. Without an HTML version it is a single
+// text/plain part, otherwise a multipart/alternative with text/plain and text/html.
+// With mw set the body becomes the first part of a multipart/mixed message,
+// otherwise the whole message is written to w with the given header.
+func writeBody(w io.Writer, header mail.Header, mw *mail.Writer, text, htmlBody string) error {
+	var textHeader mail.InlineHeader
+	textHeader.SetContentType("text/plain", map[string]string{"charset": "utf-8"})
+
+	if htmlBody == "" {
+		var part io.WriteCloser
+		var err error
+		if mw != nil {
+			part, err = mw.CreateSingleInline(textHeader)
+		} else {
+			header.SetContentType("text/plain", map[string]string{"charset": "utf-8"})
+			part, err = mail.CreateSingleInlineWriter(w, header)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create body part: %w", err)
+		}
+		if err := writeAndClose(part, []byte(text)); err != nil {
+			return fmt.Errorf("failed to write message body: %w", err)
+		}
+		return nil
+	}
+
+	var iw *mail.InlineWriter
+	var err error
+	if mw != nil {
+		iw, err = mw.CreateInline()
+	} else {
+		iw, err = mail.CreateInlineWriter(w, header)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create body part: %w", err)
+	}
+
+	var htmlHeader mail.InlineHeader
+	htmlHeader.SetContentType("text/html", map[string]string{"charset": "utf-8"})
+
+	// Clients show the last alternative they support, so HTML goes after plain text
+	for _, alternative := range []struct {
+		header mail.InlineHeader
+		body   string
+	}{{textHeader, text}, {htmlHeader, htmlBody}} {
+		part, err := iw.CreatePart(alternative.header)
+		if err != nil {
+			return fmt.Errorf("failed to create body part: %w", err)
+		}
+		if err := writeAndClose(part, []byte(alternative.body)); err != nil {
+			return fmt.Errorf("failed to write message body: %w", err)
+		}
+	}
+
+	if err := iw.Close(); err != nil {
+		return fmt.Errorf("failed to finish message body: %w", err)
+	}
+	return nil
+}
+
+// checkHeaderValues rejects CR and LF in values that end up in message headers,
+// so that callers cannot inject additional header fields
+func checkHeaderValues(email *OutgoingEmail) error {
+	check := func(name, value string) error {
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("%s must not contain line breaks", name)
+		}
+		return nil
+	}
+
+	if err := check("subject", email.Subject); err != nil {
+		return err
+	}
+	if err := check("in-reply-to", email.InReplyTo); err != nil {
+		return err
+	}
+	for _, id := range email.References {
+		if err := check("references", id); err != nil {
+			return err
+		}
+	}
+	for _, attachment := range email.Attachments {
+		if err := check("attachment filename", attachment.Filename); err != nil {
+			return err
+		}
+		if err := check("attachment content type", attachment.ContentType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addressDomain returns the domain part of an email address, used as the Message-ID host
+func addressDomain(address string) string {
+	if at := strings.LastIndex(address, "@"); at >= 0 && at < len(address)-1 {
+		return address[at+1:]
+	}
+	return "localhost"
+}
+
+// writeAndClose writes data to a part writer and closes it, flushing the transfer encoding
+func writeAndClose(w io.WriteCloser, data []byte) error {
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
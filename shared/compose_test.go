@@ -0,0 +1,254 @@
+package shared
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	gomessage "github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+)
+
+// composedPart is a leaf or multipart entity of a composed message
+type composedPart struct {
+	contentType string
+	disposition string
+	encoding    string
+	body        string
+}
+
+// parseComposed parses a composed message and lists its entities depth-first
+func parseComposed(t *testing.T, msg []byte) (*gomessage.Entity, []composedPart) {
+	t.Helper()
+
+	entity, err := gomessage.Read(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatalf("failed to parse composed message: %v\n%s", err, msg)
+	}
+	var parts []composedPart
+	err = entity.Walk(func(path []int, e *gomessage.Entity, err error) error {
+		if err != nil {
+			return err
+		}
+		contentType, _, _ := e.Header.ContentType()
+		disposition, _, _ := e.Header.ContentDisposition()
+		part := composedPart{
+			contentType: contentType,
+			disposition: disposition,
+			encoding:    e.Header.Get("Content-Transfer-Encoding"),
+		}
+		if !strings.HasPrefix(contentType, "multipart/") {
+			body, err := io.ReadAll(e.Body)
+			if err != nil {
+				return err
+			}
+			part.body = string(body)
+		}
+		parts = append(parts, part)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to walk composed message: %v", err)
+	}
+	return entity, parts
+}
+
+func composeTestMessage(t *testing.T, identity *Identity, email *OutgoingEmail) []byte {
+	t.Helper()
+
+	from := &mail.Address{Name: "Jane Doe", Address: "jane@example.com"}
+	to := []*mail.Address{{Name: "Doe, John", Address: "john@example.org"}}
+	cc := []*mail.Address{{Address: "team@example.org"}}
+	msg, err := composeMessage(identity, from, to, cc, email, false)
+	if err != nil {
+		t.Fatalf("composeMessage() error: %v", err)
+	}
+	return msg
+}
+
+func TestComposeMessageHeaders(t *testing.T) {
+	identity := &Identity{Address: "jane@example.com", ReplyTo: "Support <support@example.com>"}
+	msg := composeTestMessage(t, identity, &OutgoingEmail{
+		Subject:    "Grüße aus Zürich",
+		Body:       "Hello",
+		Bcc:        []string{"secret@example.org"},
+		InReplyTo:  "orig@example.org",
+		References: []string{"root@example.org", "orig@example.org"},
+	})
+
+	entity, parts := parseComposed(t, msg)
+	header := mail.Header{Header: entity.Header}
+
+	subject, err := header.Subject()
+	if err != nil || subject != "Grüße aus Zürich" {
+		t.Errorf("Subject = %q, %v, want the decoded subject", subject, err)
+	}
+	if raw := header.Get("Subject"); !strings.HasPrefix(raw, "=?utf-8?") {
+		t.Errorf("raw Subject %q is not RFC 2047 encoded", raw)
+	}
+
+	to, err := header.AddressList("To")
+	if err != nil || len(to) != 1 || to[0].Name != "Doe, John" || to[0].Address != "john@example.org" {
+		t.Errorf("To = %v, %v, want Doe, John <john@example.org>", to, err)
+	}
+	if cc, _ := header.AddressList("Cc"); len(cc) != 1 || cc[0].Address != "team@example.org" {
+		t.Errorf("Cc = %v, want team@example.org", cc)
+	}
+	if header.Has("Bcc") {
+		t.Error("Bcc header must not be written")
+	}
+	if replyTo, _ := header.AddressList("Reply-To"); len(replyTo) != 1 || replyTo[0].Address != "support@example.com" {
+		t.Errorf("Reply-To = %v, want support@example.com", replyTo)
+	}
+
+	if id, err := header.MessageID(); err != nil || !strings.HasSuffix(id, "@example.com") {
+		t.Errorf("Message-ID = %q, %v, want one at example.com", id, err)
+	}
+	if ids, _ := header.MsgIDList("In-Reply-To"); len(ids) != 1 || ids[0] != "orig@example.org" {
+		t.Errorf("In-Reply-To = %v, want orig@example.org", ids)
+	}
+	if ids, _ := header.MsgIDList("References"); len(ids) != 2 || ids[0] != "root@example.org" {
+		t.Errorf("References = %v, want root@example.org orig@example.org", ids)
+	}
+
+	if len(parts) != 1 || parts[0].contentType != "text/plain" || parts[0].body != "Hello" {
+		t.Errorf("parts = %+v, want a single text/plain body", parts)
+	}
+}
+
+func TestComposeMessageStructure(t *testing.T) {
+	tests := []struct {
+		name        string
+		identity    Identity
+		email       OutgoingEmail
+		wantTypes   []string
+		wantHTMLHas string
+		wantTextHas string
+	}{
+		{
+			name:        "plain text",
+			email:       OutgoingEmail{Body: "Hi there", BodyFormat: "text"},
+			wantTypes:   []string{"text/plain"},
+			wantTextHas: "Hi there",
+		},
+		{
+			name:        "markdown",
+			email:       OutgoingEmail{Body: "**Hi** there", BodyFormat: "markdown"},
+			wantTypes:   []string{"multipart/alternative", "text/plain", "text/html"},
+			wantTextHas: "**Hi** there",
+			wantHTMLHas: "<strong>Hi</strong>",
+		},
+		{
+			name:        "identity body format",
+			identity:    Identity{BodyFormat: "html"},
+			email:       OutgoingEmail{Body: "<p>Hi <b>there</b></p>"},
+			wantTypes:   []string{"multipart/alternative", "text/plain", "text/html"},
+			wantTextHas: "Hi there",
+			wantHTMLHas: "<b>there</b>",
+		},
+		{
+			name: "html with attachment and signature",
+			identity: Identity{
+				Signature: "Jane",
+			},
+			email: OutgoingEmail{
+				Body:            "<p>See attached</p>",
+				BodyFormat:      "html",
+				AppendSignature: true,
+				Attachments: []OutgoingAttachment{
+					{Filename: "report.csv", ContentType: "text/csv", Data: []byte("a,b\n1,2\n")},
+					{Filename: "blob.bin", Data: []byte{0, 1, 2, 0xff}},
+				},
+			},
+			wantTypes:   []string{"multipart/mixed", "multipart/alternative", "text/plain", "text/html", "text/csv", "application/octet-stream"},
+			wantTextHas: "-- \nJane",
+			wantHTMLHas: `<div class="signature">`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity := tt.identity
+			identity.Address = "jane@example.com"
+			email := tt.email
+			email.Subject = "Test"
+			_, parts := parseComposed(t, composeTestMessage(t, &identity, &email))
+
+			var types []string
+			for _, part := range parts {
+				types = append(types, part.contentType)
+			}
+			if strings.Join(types, " ") != strings.Join(tt.wantTypes, " ") {
+				t.Fatalf("structure = %v, want %v", types, tt.wantTypes)
+			}
+
+			for _, part := range parts {
+				switch {
+				case part.contentType == "text/plain" && part.disposition != "attachment":
+					if !strings.Contains(strings.ReplaceAll(part.body, "\r\n", "\n"), tt.wantTextHas) {
+						t.Errorf("text part %q does not contain %q", part.body, tt.wantTextHas)
+					}
+					if part.encoding != "quoted-printable" {
+						t.Errorf("text part encoding = %q, want quoted-printable", part.encoding)
+					}
+				case part.contentType == "text/html":
+					if !strings.Contains(part.body, tt.wantHTMLHas) {
+						t.Errorf("html part %q does not contain %q", part.body, tt.wantHTMLHas)
+					}
+				}
+			}
+
+			for i, attachment := range email.Attachments {
+				part := parts[len(parts)-len(email.Attachments)+i]
+				if part.disposition != "attachment" || part.encoding != "base64" {
+					t.Errorf("attachment %s: disposition %q, encoding %q, want attachment/base64", attachment.Filename, part.disposition, part.encoding)
+				}
+				if part.body != string(attachment.Data) {
+					t.Errorf("attachment %s content = %q, want %q", attachment.Filename, part.body, attachment.Data)
+				}
+			}
+		})
+	}
+}
+
+func TestComposeMessageRejectsLineBreaks(t *testing.T) {
+	tests := []struct {
+		name  string
+		email OutgoingEmail
+	}{
+		{"subject CRLF", OutgoingEmail{Subject: "Hi\r\nBcc: victim@example.org"}},
+		{"subject LF", OutgoingEmail{Subject: "Hi\nBcc: victim@example.org"}},
+		{"in-reply-to", OutgoingEmail{Subject: "Hi", InReplyTo: "a@b\r\nX-Evil: 1"}},
+		{"references", OutgoingEmail{Subject: "Hi", References: []string{"ok@b", "a@b\nX-Evil: 1"}}},
+		{"attachment filename", OutgoingEmail{Subject: "Hi", Attachments: []OutgoingAttachment{{Filename: "a\r\n.txt"}}}},
+		{"attachment content type", OutgoingEmail{Subject: "Hi", Attachments: []OutgoingAttachment{{Filename: "a.txt", ContentType: "text/plain\r\nX-Evil: 1"}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from := &mail.Address{Address: "jane@example.com"}
+			to := []*mail.Address{{Address: "john@example.org"}}
+			if _, err := composeMessage(&Identity{}, from, to, nil, &tt.email, false); err == nil {
+				t.Error("composeMessage() error = nil, want line breaks rejected")
+			}
+		})
+	}
+}
+
+func TestComposeMessageEncodesAddressNames(t *testing.T) {
+	// Display names never reach the header raw, so line breaks in them cannot add header fields
+	from := &mail.Address{Name: "Jane\r\nBcc: victim@example.org", Address: "jane@example.com"}
+	to := []*mail.Address{{Name: "John\nX-Evil: 1", Address: "john@example.org"}}
+	msg, err := composeMessage(&Identity{}, from, to, nil, &OutgoingEmail{Subject: "Hi", Body: "Hi"}, false)
+	if err != nil {
+		t.Fatalf("composeMessage() error: %v", err)
+	}
+
+	entity, _ := parseComposed(t, msg)
+	for _, key := range []string{"Bcc", "X-Evil"} {
+		if entity.Header.Has(key) {
+			t.Errorf("injected %s header found in\n%s", key, msg)
+		}
+	}
+}
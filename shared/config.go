@@ -1,42 +1,130 @@
 package shared
 
 import (
-	"encoding/json"
 	"fmt"
+	"net/mail"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
-// Config holds all configuration for the MCP email server
+// IMAPSettings configures the IMAP connection of an account
+type IMAPSettings struct {
+	Server   string `json:"server"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// PasswordEnv, PasswordFile and PasswordCommand read the password from an
+	// environment variable, a file or a command's output instead
+	PasswordEnv     string `json:"password_env"`
+	PasswordFile    string `json:"password_file"`
+	PasswordCommand string `json:"password_command"`
+	// Security is "tls" (implicit TLS, default), "starttls" or "none"
+	Security string `json:"security"`
+	// UseTLS is superseded by Security
+	UseTLS bool `json:"use_tls"`
+	// AuthMechanism is "login" (default, password), "xoauth2" or "oauthbearer"
+	AuthMechanism string `json:"auth_mechanism"`
+	// MaxConnections caps the number of simultaneous IMAP sessions
+	MaxConnections int `json:"max_connections"`
+}
+
+// SMTPSettings configures the SMTP connection of an account
+type SMTPSettings struct {
+	Server   string `json:"server"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// PasswordEnv, PasswordFile and PasswordCommand read the password from an
+	// environment variable, a file or a command's output instead
+	PasswordEnv     string `json:"password_env"`
+	PasswordFile    string `json:"password_file"`
+	PasswordCommand string `json:"password_command"`
+	// Security is "starttls" (default), "tls" (implicit TLS, default on port 465) or "none"
+	Security string `json:"security"`
+	// AuthMechanism is "plain" (default), "login", "cram-md5", "xoauth2", "oauthbearer"
+	// or "none" (default without username)
+	AuthMechanism string `json:"auth_mechanism"`
+	// RequireTLS is superseded by Security; when Security is not set, true
+	// means "starttls" and false means "none" (or "tls" on port 465)
+	RequireTLS *bool `json:"require_tls"`
+}
+
+// Config holds all configuration for the MCP email server.
+// The top-level imap, smtp and my_email settings form the "default" account;
+// more mailboxes can be added in accounts. After LoadConfig the top-level
+// settings always describe the primary account.
 type Config struct {
-	IMAP struct {
-		Server   string `json:"server"`
-		Port     int    `json:"port"`
-		Username string `json:"username"`
-		Password string `json:"password"`
-		UseTLS   bool   `json:"use_tls"`
-	} `json:"imap"`
-	SMTP struct {
-		Server     string `json:"server"`
-		Port       int    `json:"port"`
-		Username   string `json:"username"`
-		Password   string `json:"password"`
-		RequireTLS bool   `json:"require_tls"`
-	} `json:"smtp"`
-	MyEmail  string            `json:"my_email"`
-	Contacts map[string]string `json:"contacts"`
-	HTTP     struct {
+	// Schema points editors at config.schema.json; it is not used otherwise
+	Schema  string         `json:"$schema"`
+	IMAP    IMAPSettings   `json:"imap"`
+	SMTP    SMTPSettings   `json:"smtp"`
+	TLS     TLSSettings    `json:"tls"`
+	OAuth2  OAuth2Settings `json:"oauth2"`
+	MyEmail string         `json:"my_email"`
+	// Identities are the addresses send_email may use as From, with their signatures
+	Identities []Identity        `json:"identities"`
+	Contacts   map[string]string `json:"contacts"`
+	// ContactsFile keeps the contacts managed by add_contact and update_contact
+	// (default: contacts.json next to config.json)
+	ContactsFile string `json:"contacts_file"`
+	// Correspondents records the senders and recipients of fetched and sent
+	// emails, so that people who are not contacts can be found by name
+	Correspondents struct {
+		Harvest bool `json:"harvest"`
+		// File keeps the known correspondents (default: correspondents.json next to config.json)
+		File string `json:"file"`
+	} `json:"correspondents"`
+	HTTP struct {
 		Host string `json:"host"`
 		Port int    `json:"port"`
 	} `json:"http"`
 	Notifications struct {
 		CheckIntervalSeconds int `json:"check_interval_seconds"`
+		// DisableIdle forces polling even when the server supports IMAP IDLE
+		DisableIdle bool `json:"disable_idle"`
+		// StateFile keeps the last notified email of every account
+		// (default: notification_state.json next to config.json)
+		StateFile string `json:"state_file"`
+		// MaxReplay caps the emails that arrived while the server was down
+		// announced on start (default: 20, negative: none)
+		MaxReplay int `json:"max_replay"`
 	} `json:"notifications"`
+	Attachments struct {
+		// Directory is the only place send_email may read attachment files from
+		Directory string `json:"directory"`
+		// MaxSizeMB caps the size of a single outgoing attachment
+		MaxSizeMB int `json:"max_size_mb"`
+	} `json:"attachments"`
+	Markdown struct {
+		// InlineCSS styles HTML rendered from markdown bodies with inline CSS
+		InlineCSS bool `json:"inline_css"`
+	} `json:"markdown"`
+	Accounts []AccountSettings `json:"accounts"`
+	// PrimaryAccount is the account tools use when none is given (default: the first account)
+	PrimaryAccount string `json:"primary_account"`
+
+	// accountName is the account this config describes; accounts holds the
+	// per-account configs of the loaded file; path is the file it was read
+	// from, if any. All are set by LoadConfig.
+	accountName string
+	accounts    []*Config
+	path        string
+	contacts    *ContactBook
+	// correspondents is nil unless correspondents.harvest is set
+	correspondents *CorrespondentStore
 }
 
-// LoadConfig loads configuration from a JSON file
-// If path is empty, it looks for config.json in the same directory as the executable
+// LoadConfig loads configuration from a JSON file and applies EMAILBOX_*
+// environment overrides. If path is empty, it uses $EMAILBOX_CONFIG or looks for
+// config.json in the same directory as the executable; without a config file
+// the configuration may come from environment variables alone. Unknown keys and
+// invalid values are reported together as a *ConfigError.
 func LoadConfig(path string) (*Config, error) {
+	if path == "" {
+		path = os.Getenv(ConfigPathEnv)
+	}
+	explicitPath := path != ""
 	if path == "" {
 		// Get the directory of the executable
 		execPath, err := os.Executable()
@@ -53,14 +141,20 @@ func LoadConfig(path string) (*Config, error) {
 		}
 	}
 
+	var config Config
+	var problems configProblems
 	data, err := os.ReadFile(path)
-	if err != nil {
+	if err == nil {
+		if err := decodeConfig(data, &config, &problems); err != nil {
+			return nil, err
+		}
+		config.path = path
+	} else if explicitPath || !os.IsNotExist(err) || !hasEnvOverrides() {
 		return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
 	}
 
-	var config Config
-	if err := json.Unmarshal(data, &config); err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	if err := applyEnvOverrides(&config); err != nil {
+		return nil, err
 	}
 
 	// Set defaults
@@ -73,51 +167,320 @@ func LoadConfig(path string) (*Config, error) {
 	if config.Notifications.CheckIntervalSeconds == 0 {
 		config.Notifications.CheckIntervalSeconds = 30
 	}
-	if config.IMAP.Port == 0 {
-		config.IMAP.Port = 993
+	if config.Notifications.StateFile == "" {
+		config.Notifications.StateFile = "notification_state.json"
+		if config.path != "" {
+			config.Notifications.StateFile = filepath.Join(filepath.Dir(config.path), "notification_state.json")
+		}
+	}
+	if config.Notifications.MaxReplay == 0 {
+		config.Notifications.MaxReplay = 20
 	}
-	if config.SMTP.Port == 0 {
-		config.SMTP.Port = 587
+	if config.Attachments.MaxSizeMB == 0 {
+		config.Attachments.MaxSizeMB = 25
+	}
+
+	if config.ContactsFile == "" {
+		config.ContactsFile = "contacts.json"
+		if config.path != "" {
+			config.ContactsFile = filepath.Join(filepath.Dir(config.path), "contacts.json")
+		}
+	}
+	config.contacts = loadContactBook(config.ContactsFile, config.Contacts, &problems)
+
+	if config.Correspondents.File == "" {
+		config.Correspondents.File = "correspondents.json"
+		if config.path != "" {
+			config.Correspondents.File = filepath.Join(filepath.Dir(config.path), "correspondents.json")
+		}
+	}
+	if config.Correspondents.Harvest {
+		store, err := openCorrespondentStore(config.Correspondents.File)
+		if err != nil {
+			problems.add("correspondents.file", "%v", err)
+		}
+		config.correspondents = store
+	}
+
+	config.validateGlobal(&problems)
+	config.buildAccounts(&problems)
+	if err := problems.err(); err != nil {
+		return nil, err
 	}
 
 	return &config, nil
 }
 
+// applyAccountDefaults fills in and checks the IMAP, SMTP and OAuth2 settings of
+// an account config; prefix is the JSON path of the account used in problems
+func (c *Config) applyAccountDefaults(prefix string, problems *configProblems) {
+	var imapPasswordErr, smtpPasswordErr error
+	c.IMAP.Password, imapPasswordErr = resolvePassword(c.IMAP.Password, c.IMAP.PasswordEnv, c.IMAP.PasswordFile, c.IMAP.PasswordCommand)
+	if imapPasswordErr != nil {
+		problems.addErr(prefix+"imap.", imapPasswordErr)
+	}
+	c.SMTP.Password, smtpPasswordErr = resolvePassword(c.SMTP.Password, c.SMTP.PasswordEnv, c.SMTP.PasswordFile, c.SMTP.PasswordCommand)
+	if smtpPasswordErr != nil {
+		problems.addErr(prefix+"smtp.", smtpPasswordErr)
+	}
+
+	if c.IMAP.Server == "" {
+		problems.add(prefix+"imap.server", "is required")
+	}
+	if c.IMAP.Port == 0 {
+		c.IMAP.Port = 993
+	}
+	checkPort(problems, prefix+"imap.port", c.IMAP.Port)
+	c.IMAP.Security = strings.ToLower(c.IMAP.Security)
+	if c.IMAP.Security == "" {
+		// Keep the meaning of use_tls for existing configs; port 993 always means implicit TLS
+		c.IMAP.Security = IMAPSecurityNone
+		if c.IMAP.UseTLS || c.IMAP.Port == 993 {
+			c.IMAP.Security = IMAPSecurityTLS
+		}
+	}
+	switch c.IMAP.Security {
+	case IMAPSecurityNone, IMAPSecurityStartTLS, IMAPSecurityTLS:
+	default:
+		problems.add(prefix+"imap.security", "invalid value %q: expected none, starttls or tls", c.IMAP.Security)
+	}
+	c.IMAP.AuthMechanism = strings.ToLower(c.IMAP.AuthMechanism)
+	if c.IMAP.AuthMechanism == "" {
+		c.IMAP.AuthMechanism = IMAPAuthLogin
+	}
+	switch c.IMAP.AuthMechanism {
+	case IMAPAuthLogin, AuthXOAuth2, AuthOAuthBearer:
+	default:
+		problems.add(prefix+"imap.auth_mechanism", "invalid value %q: expected login, xoauth2 or oauthbearer", c.IMAP.AuthMechanism)
+	}
+	if c.IMAP.Username == "" {
+		problems.add(prefix+"imap.username", "is required")
+	}
+	if c.IMAP.AuthMechanism == IMAPAuthLogin && c.IMAP.Password == "" && imapPasswordErr == nil {
+		problems.add(prefix+"imap.password", "is required (or set password_env, password_file or password_command)")
+	}
+	if c.IMAP.MaxConnections == 0 {
+		c.IMAP.MaxConnections = 4
+	}
+	if c.IMAP.MaxConnections < 1 {
+		problems.add(prefix+"imap.max_connections", "must be at least 1")
+	}
+
+	if c.SMTP.Server == "" {
+		problems.add(prefix+"smtp.server", "is required")
+	}
+	if c.SMTP.Port == 0 {
+		c.SMTP.Port = 587
+	}
+	checkPort(problems, prefix+"smtp.port", c.SMTP.Port)
+	c.SMTP.Security = strings.ToLower(c.SMTP.Security)
+	if c.SMTP.Security == "" {
+		// Keep the meaning of require_tls for existing configs; port 465 always means implicit TLS
+		c.SMTP.Security = SMTPSecurityStartTLS
+		if c.SMTP.RequireTLS != nil && !*c.SMTP.RequireTLS {
+			c.SMTP.Security = SMTPSecurityNone
+		}
+		if c.SMTP.Port == 465 {
+			c.SMTP.Security = SMTPSecurityTLS
+		}
+	}
+	switch c.SMTP.Security {
+	case SMTPSecurityNone, SMTPSecurityStartTLS, SMTPSecurityTLS:
+	default:
+		problems.add(prefix+"smtp.security", "invalid value %q: expected none, starttls or tls", c.SMTP.Security)
+	}
+	c.SMTP.AuthMechanism = strings.ToLower(c.SMTP.AuthMechanism)
+	if c.SMTP.AuthMechanism == "" {
+		c.SMTP.AuthMechanism = SMTPAuthPlain
+		if c.SMTP.Username == "" {
+			c.SMTP.AuthMechanism = SMTPAuthNone
+		}
+	}
+	switch c.SMTP.AuthMechanism {
+	case SMTPAuthPlain, SMTPAuthLogin, SMTPAuthCRAMMD5:
+		if c.SMTP.Username == "" {
+			problems.add(prefix+"smtp.username", "is required for %s authentication", c.SMTP.AuthMechanism)
+		}
+		if c.SMTP.Password == "" && smtpPasswordErr == nil {
+			problems.add(prefix+"smtp.password", "is required for %s authentication (or set password_env, password_file or password_command)", c.SMTP.AuthMechanism)
+		}
+	case AuthXOAuth2, AuthOAuthBearer:
+		if c.SMTP.Username == "" {
+			problems.add(prefix+"smtp.username", "is required for %s authentication", c.SMTP.AuthMechanism)
+		}
+	case SMTPAuthNone:
+	default:
+		problems.add(prefix+"smtp.auth_mechanism", "invalid value %q: expected plain, login, cram-md5, xoauth2, oauthbearer or none", c.SMTP.AuthMechanism)
+	}
+
+	if c.usesOAuth2() {
+		for _, field := range []struct{ name, value string }{
+			{"token_url", c.OAuth2.TokenURL},
+			{"client_id", c.OAuth2.ClientID},
+			{"refresh_token", c.OAuth2.RefreshToken},
+		} {
+			if field.value == "" {
+				problems.add(prefix+"oauth2."+field.name, "is required for OAuth2 authentication")
+			}
+		}
+	}
+
+	if c.MyEmail == "" {
+		problems.add(prefix+"my_email", "is required")
+	} else {
+		checkAddress(problems, prefix+"my_email", c.MyEmail)
+	}
+	c.validateIdentities(prefix, problems)
+
+	// Load CA and client certificate files now, so mistakes surface at startup
+	if _, err := c.TLSConfig(""); err != nil {
+		problems.addErr(prefix, err)
+	}
+}
+
+// usesOAuth2 reports whether IMAP or SMTP authenticates with an OAuth2 access token
+func (c *Config) usesOAuth2() bool {
+	for _, mechanism := range []string{c.IMAP.AuthMechanism, c.SMTP.AuthMechanism} {
+		if mechanism == AuthXOAuth2 || mechanism == AuthOAuthBearer {
+			return true
+		}
+	}
+	return false
+}
+
 // GetContactsDescription returns a formatted string of contacts for tool descriptions
 func (c *Config) GetContactsDescription() string {
-	if len(c.Contacts) == 0 {
-		return "No contacts configured."
-	}
+	return c.ContactBook().Describe()
+}
 
-	result := "Available contacts:\n"
-	for name, email := range c.Contacts {
-		result += fmt.Sprintf("  - %s: %s\n", name, email)
+// ContactBook returns the contacts of the config file and the contacts file
+func (c *Config) ContactBook() *ContactBook {
+	if c.contacts == nil {
+		// Configs not built by LoadConfig only know the contacts of the config file
+		c.contacts = loadContactBook("", c.Contacts, &configProblems{})
 	}
-	return result
+	return c.contacts
 }
 
-// ResolveEmail resolves a contact name to an email address
-// If the input is already an email address, it returns it unchanged
+// ResolveEmail resolves a contact or correspondent name to an email address
+// If the input is already an email address, or names no single person, it returns it unchanged
 func (c *Config) ResolveEmail(nameOrEmail string) string {
-	if email, ok := c.Contacts[nameOrEmail]; ok {
-		return email
+	addresses, err := c.resolveName(nameOrEmail)
+	if err != nil || len(addresses) != 1 {
+		return nameOrEmail
 	}
-	return nameOrEmail
+	return addresses[0].Address
 }
 
-// ValidateConnections tests both IMAP and SMTP connections
-// Returns an error if either connection fails
-func ValidateConnections(config *Config) error {
-	// Test IMAP connection
-	imapClient := NewIMAPClient(config)
-	if err := imapClient.ValidateConnection(); err != nil {
-		return fmt.Errorf("IMAP connection failed: %w", err)
+// resolveName looks a name up in the contact book and then among the known
+// correspondents. It returns nil without error when the name is unknown.
+func (c *Config) resolveName(name string) ([]*mail.Address, error) {
+	addresses, err := c.ContactBook().Resolve(name)
+	if err != nil || addresses != nil || c.correspondents == nil {
+		return addresses, err
+	}
+	addr, err := c.correspondents.Resolve(name)
+	if err != nil || addr == nil {
+		return nil, err
 	}
+	return []*mail.Address{addr}, nil
+}
+
+// ResolveRecipients resolves recipients into validated addresses. Each entry may
+// be a contact name or nickname, a group name, the name of a known correspondent,
+// an email address, "Name <address>", or a comma-separated RFC 5322 list of
+// those. All invalid entries are reported together.
+func (c *Config) ResolveRecipients(entries []string) ([]*mail.Address, error) {
+	var addresses []*mail.Address
+	var invalid []string
 
-	// Test SMTP connection
-	smtpClient := NewSMTPClient(config)
-	if err := smtpClient.ValidateConnection(); err != nil {
-		return fmt.Errorf("SMTP connection failed: %w", err)
+	for _, entry := range entries {
+		for _, part := range splitAddressList(entry) {
+			contactAddresses, err := c.resolveName(part)
+			if err != nil {
+				invalid = append(invalid, err.Error())
+				continue
+			}
+			if contactAddresses != nil {
+				addresses = append(addresses, contactAddresses...)
+				continue
+			}
+
+			addr, err := mail.ParseAddress(part)
+			if err != nil {
+				invalid = append(invalid, fmt.Sprintf("%q (not a valid email address or known contact)", part))
+				continue
+			}
+			addresses = append(addresses, addr)
+		}
+	}
+
+	if len(invalid) > 0 {
+		return nil, fmt.Errorf("invalid recipients: %s", strings.Join(invalid, ", "))
+	}
+	return addresses, nil
+}
+
+// splitAddressList splits an address list on commas and semicolons that are not
+// inside quotes, angle brackets or comments, dropping empty entries
+func splitAddressList(list string) []string {
+	var parts []string
+	var current strings.Builder
+	quoted, escaped := false, false
+	depth := 0
+
+	flush := func() {
+		if part := strings.TrimSpace(current.String()); part != "" {
+			parts = append(parts, part)
+		}
+		current.Reset()
+	}
+
+	for _, r := range list {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && quoted:
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+		case quoted:
+		case r == '<' || r == '(':
+			depth++
+		case (r == '>' || r == ')') && depth > 0:
+			depth--
+		case (r == ',' || r == ';') && depth == 0:
+			flush()
+			continue
+		}
+		current.WriteRune(r)
+	}
+	flush()
+
+	return parts
+}
+
+// ValidateConnections tests both IMAP and SMTP connections of every account
+// Returns an error if any connection fails
+func ValidateConnections(config *Config) error {
+	accounts := config.AccountConfigs()
+	for _, account := range accounts {
+		prefix := ""
+		if len(accounts) > 1 {
+			prefix = fmt.Sprintf("account %s: ", account.AccountName())
+		}
+
+		// Test IMAP connection
+		imapClient := NewIMAPClient(account)
+		if err := imapClient.ValidateConnection(); err != nil {
+			return fmt.Errorf("%sIMAP connection failed: %w", prefix, err)
+		}
+
+		// Test SMTP connection
+		smtpClient := NewSMTPClient(account)
+		if err := smtpClient.ValidateConnection(); err != nil {
+			return fmt.Errorf("%sSMTP connection failed: %w", prefix, err)
+		}
 	}
 
 	return nil
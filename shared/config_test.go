@@ -0,0 +1,130 @@
+package shared
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitAddressList(t *testing.T) {
+	tests := []struct {
+		list string
+		want []string
+	}{
+		{"", nil},
+		{"a@x.com", []string{"a@x.com"}},
+		{"a@x.com, b@x.com;c@x.com", []string{"a@x.com", "b@x.com", "c@x.com"}},
+		{" , a@x.com ,, ", []string{"a@x.com"}},
+		{`"Doe, John" <j@x.com>, Jane <jane@x.com>`, []string{`"Doe, John" <j@x.com>`, "Jane <jane@x.com>"}},
+		{`"Say \"hi, there\"" <s@x.com>;b@x.com`, []string{`"Say \"hi, there\"" <s@x.com>`, "b@x.com"}},
+		{"<odd,route@x.com>, b@x.com", []string{"<odd,route@x.com>", "b@x.com"}},
+		{"a@x.com (Work; main), b@x.com", []string{"a@x.com (Work; main)", "b@x.com"}},
+		{"Team", []string{"Team"}},
+	}
+
+	for _, tt := range tests {
+		if got := splitAddressList(tt.list); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitAddressList(%q) = %q, want %q", tt.list, got, tt.want)
+		}
+	}
+}
+
+func TestResolveRecipients(t *testing.T) {
+	config := &Config{
+		MyEmail: "me@example.com",
+		Contacts: map[string]string{
+			"Jane Doe": "jane@example.com",
+			"Bob":      "bob@example.com",
+		},
+	}
+
+	tests := []struct {
+		name    string
+		entries []string
+		want    []string
+		wantErr []string
+	}{
+		{
+			name:    "addresses and names",
+			entries: []string{"a@x.com", `"Doe, John" <john@x.com>`},
+			want:    []string{"a@x.com", "john@x.com"},
+		},
+		{
+			name:    "comma-separated list in one entry",
+			entries: []string{"a@x.com, Jane Doe; bob"},
+			want:    []string{"a@x.com", "jane@example.com", "bob@example.com"},
+		},
+		{
+			name:    "empty",
+			entries: nil,
+		},
+		{
+			name:    "all invalid entries are reported",
+			entries: []string{"a@x.com", "nobody known", "not@valid@x"},
+			wantErr: []string{`"nobody known"`, `"not@valid@x"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addresses, err := config.ResolveRecipients(tt.entries)
+			if tt.wantErr != nil {
+				if err == nil {
+					t.Fatalf("ResolveRecipients() = %v, want an error", addresses)
+				}
+				for _, want := range tt.wantErr {
+					if !strings.Contains(err.Error(), want) {
+						t.Errorf("error %q does not mention %s", err, want)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveRecipients() error: %v", err)
+			}
+
+			var got []string
+			for _, addr := range addresses {
+				got = append(got, addr.Address)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ResolveRecipients() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigMaxReplayDefault(t *testing.T) {
+	tests := []struct {
+		setting string
+		want    int
+	}{
+		{"", 20},
+		{`, "max_replay": 0`, 20},
+		{`, "max_replay": 5`, 5},
+		{`, "max_replay": -1`, -1},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "config.json")
+		data := `{
+			"imap": {"server": "imap.example.com", "username": "me", "password": "secret"},
+			"smtp": {"server": "smtp.example.com", "username": "me", "password": "secret"},
+			"my_email": "me@example.com",
+			"notifications": {"check_interval_seconds": 30` + tt.setting + `}
+		}`
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		config, err := LoadConfig(path)
+		if err != nil {
+			t.Fatalf("LoadConfig() with %q error: %v", tt.setting, err)
+		}
+		if config.Notifications.MaxReplay != tt.want {
+			t.Errorf("max_replay with %q = %d, want %d", tt.setting, config.Notifications.MaxReplay, tt.want)
+		}
+	}
+}
@@ -0,0 +1,504 @@
+package shared
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// ContactSourceFile marks contacts kept in the contacts file, which the contact tools edit
+	ContactSourceFile = "contacts_file"
+	// ContactSourceConfig marks contacts from the contacts map of config.json
+	ContactSourceConfig = "config"
+)
+
+// maxDescribedContacts caps the contacts listed in tool descriptions
+const maxDescribedContacts = 50
+
+// Contact is an entry of the contact book. The first email address is the one
+// used when the contact is picked as a recipient.
+type Contact struct {
+	Name         string   `json:"name"`
+	Emails       []string `json:"emails"`
+	Nicknames    []string `json:"nicknames,omitempty"`
+	Organization string   `json:"organization,omitempty"`
+	Notes        string   `json:"notes,omitempty"`
+	// Groups are the named groups the contact belongs to; a group name used as a
+	// recipient expands to all of its members
+	Groups []string `json:"groups,omitempty"`
+}
+
+// ContactInfo is a contact as reported by list_contacts
+type ContactInfo struct {
+	Contact
+	Source string `json:"source"`
+}
+
+// contactsFile is the format of the contacts file
+type contactsFile struct {
+	Contacts []*Contact `json:"contacts"`
+}
+
+// ContactBook holds the contacts of the contacts file and of config.json.
+// Contacts of the file take precedence over config.json contacts of the same name.
+type ContactBook struct {
+	mu     sync.RWMutex
+	path   string
+	file   []*Contact
+	config []*Contact
+}
+
+// loadContactBook reads the contacts file, which may not exist yet, and adds
+// the contacts map of config.json. Invalid entries are recorded as problems.
+func loadContactBook(path string, configContacts map[string]string, problems *configProblems) *ContactBook {
+	book := &ContactBook{path: path}
+
+	for _, name := range sortedContactNames(configContacts) {
+		book.config = append(book.config, &Contact{Name: name, Emails: []string{configContacts[name]}})
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return book
+	}
+	if err != nil {
+		problems.add("contacts_file", "%v", err)
+		return book
+	}
+
+	var file contactsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		problems.add("contacts_file", "failed to parse %s: %v", path, err)
+		return book
+	}
+	seen := make(map[string]bool)
+	for i, contact := range file.Contacts {
+		prefix := fmt.Sprintf("%s: contacts[%d]", path, i)
+		if contact == nil {
+			problems.add(prefix, "must be an object")
+			continue
+		}
+		if err := contact.validate(); err != nil {
+			problems.addErr(prefix+".", err)
+			continue
+		}
+		key := normalizeName(contact.Name)
+		if seen[key] {
+			problems.add(prefix+".name", "duplicate contact %q", contact.Name)
+			continue
+		}
+		seen[key] = true
+		book.file = append(book.file, contact)
+	}
+	return book
+}
+
+// validate checks a contact's name and email addresses
+func (c *Contact) validate() error {
+	c.Name = strings.TrimSpace(c.Name)
+	if c.Name == "" {
+		return fmt.Errorf("name: is required")
+	}
+	if strings.ContainsAny(c.Name, "\r\n@") {
+		return fmt.Errorf("name: must not contain line breaks or @")
+	}
+	if len(c.Emails) == 0 {
+		return fmt.Errorf("emails: at least one email address is required")
+	}
+	for i, email := range c.Emails {
+		if _, err := mail.ParseAddress(email); err != nil {
+			return fmt.Errorf("emails[%d]: invalid email address %q", i, email)
+		}
+	}
+	return nil
+}
+
+// all returns the contacts of the file followed by the config.json contacts they do not shadow.
+// The caller must hold the lock.
+func (b *ContactBook) all() []ContactInfo {
+	var contacts []ContactInfo
+	names := make(map[string]bool)
+	for _, contact := range b.file {
+		names[normalizeName(contact.Name)] = true
+		contacts = append(contacts, ContactInfo{Contact: *contact, Source: ContactSourceFile})
+	}
+	for _, contact := range b.config {
+		if !names[normalizeName(contact.Name)] {
+			contacts = append(contacts, ContactInfo{Contact: *contact, Source: ContactSourceConfig})
+		}
+	}
+	sort.Slice(contacts, func(i, j int) bool {
+		return strings.ToLower(contacts[i].Name) < strings.ToLower(contacts[j].Name)
+	})
+	return contacts
+}
+
+// List returns the contacts, optionally only those matching query (in name,
+// nicknames, addresses, organization or notes) or belonging to group
+func (b *ContactBook) List(query, group string) []ContactInfo {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	query = normalizeName(query)
+	contacts := []ContactInfo{}
+	for _, contact := range b.all() {
+		if group != "" && !containsFold(contact.Groups, group) {
+			continue
+		}
+		if query != "" && !strings.Contains(contactSearchText(&contact.Contact), query) {
+			continue
+		}
+		contacts = append(contacts, contact)
+	}
+	return contacts
+}
+
+// Find returns the contacts whose details contain query or whose name or a
+// nickname is close to it, as used for recipients
+func (b *ContactBook) Find(query string) []ContactInfo {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	key := normalizeName(query)
+	contacts := []ContactInfo{}
+	for _, contact := range b.all() {
+		if strings.Contains(contactSearchText(&contact.Contact), key) || contact.matchesFuzzy(key) {
+			contacts = append(contacts, contact)
+		}
+	}
+	return contacts
+}
+
+// Groups returns the group names with the names of their members
+func (b *ContactBook) Groups() map[string][]string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	groups := make(map[string][]string)
+	for _, contact := range b.all() {
+		for _, group := range contact.Groups {
+			groups[group] = append(groups[group], contact.Name)
+		}
+	}
+	return groups
+}
+
+// Resolve turns a contact name, nickname or group name into addresses. Names
+// match case-insensitively; when nothing matches exactly, a unique close match
+// (a prefix, part of the name or a small typo) is used. It returns nil without
+// error when query names no contact, and an error when it matches several.
+func (b *ContactBook) Resolve(query string) ([]*mail.Address, error) {
+	if strings.Contains(query, "@") {
+		return nil, nil
+	}
+	key := normalizeName(query)
+	if key == "" {
+		return nil, nil
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	contacts := b.all()
+
+	var exact []ContactInfo
+	for _, contact := range contacts {
+		if normalizeName(contact.Name) == key || containsFold(contact.Nicknames, key) {
+			exact = append(exact, contact)
+		}
+	}
+	if len(exact) > 1 {
+		return nil, ambiguousContactError(query, exact)
+	}
+	if len(exact) == 1 {
+		return contactAddresses(exact[:1])
+	}
+
+	var members []ContactInfo
+	for _, contact := range contacts {
+		if containsFold(contact.Groups, key) {
+			members = append(members, contact)
+		}
+	}
+	if len(members) > 0 {
+		return contactAddresses(members)
+	}
+
+	var similar []ContactInfo
+	for _, contact := range contacts {
+		if contact.matchesFuzzy(key) {
+			similar = append(similar, contact)
+		}
+	}
+	if len(similar) > 1 {
+		return nil, ambiguousContactError(query, similar)
+	}
+	if len(similar) == 1 {
+		return contactAddresses(similar)
+	}
+	return nil, nil
+}
+
+// matchesFuzzy reports whether a normalized query is close to the contact's name or a nickname
+func (c *ContactInfo) matchesFuzzy(query string) bool {
+	for _, label := range append([]string{c.Name}, c.Nicknames...) {
+		label = normalizeName(label)
+		if len(query) >= 3 && strings.Contains(label, query) {
+			return true
+		}
+		if len(query) >= 2 && matchesWordPrefixes(label, query) {
+			return true
+		}
+		if len(query) >= 4 && levenshtein(label, query) <= len(query)/5+1 {
+			return true
+		}
+	}
+	return false
+}
+
+// Add adds a new contact to the contacts file
+func (b *ContactBook) Add(contact Contact) error {
+	if err := contact.validate(); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, existing := range b.all() {
+		if normalizeName(existing.Name) == normalizeName(contact.Name) {
+			return fmt.Errorf("contact %q already exists; use update_contact to change it", existing.Name)
+		}
+	}
+
+	file := append(append([]*Contact{}, b.file...), &contact)
+	if err := b.save(file); err != nil {
+		return err
+	}
+	b.file = file
+	return nil
+}
+
+// Update changes the contact with the given name (matched case-insensitively).
+// A contact from config.json is copied into the contacts file, where it then
+// takes precedence over the config.json entry.
+func (b *ContactBook) Update(name string, update func(*Contact)) (*Contact, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var current *ContactInfo
+	for _, contact := range b.all() {
+		if normalizeName(contact.Name) == normalizeName(name) {
+			current = &contact
+			break
+		}
+	}
+	if current == nil {
+		return nil, fmt.Errorf("no contact named %q", name)
+	}
+
+	updated := current.Contact
+	updated.Emails = append([]string{}, updated.Emails...)
+	update(&updated)
+	if err := updated.validate(); err != nil {
+		return nil, err
+	}
+	for _, contact := range b.all() {
+		if normalizeName(contact.Name) == normalizeName(updated.Name) && normalizeName(contact.Name) != normalizeName(current.Name) {
+			return nil, fmt.Errorf("contact %q already exists", contact.Name)
+		}
+	}
+
+	var file []*Contact
+	replaced := false
+	for _, contact := range b.file {
+		if normalizeName(contact.Name) == normalizeName(current.Name) {
+			file = append(file, &updated)
+			replaced = true
+			continue
+		}
+		file = append(file, contact)
+	}
+	if !replaced {
+		file = append(file, &updated)
+	}
+
+	if err := b.save(file); err != nil {
+		return nil, err
+	}
+	b.file = file
+	return &updated, nil
+}
+
+// save writes the contacts file through a temporary file, so a crash never leaves it truncated
+func (b *ContactBook) save(contacts []*Contact) error {
+	if b.path == "" {
+		return fmt.Errorf("no contacts file configured")
+	}
+	data, err := json.MarshalIndent(contactsFile{Contacts: contacts}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(b.path, append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write contacts file: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces the file at path through a temporary file in the same directory
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Describe returns a formatted list of contacts and groups for tool descriptions
+func (b *ContactBook) Describe() string {
+	b.mu.RLock()
+	contacts := b.all()
+	b.mu.RUnlock()
+
+	if len(contacts) == 0 {
+		return "No contacts configured."
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Available contacts:\n")
+	groups := make(map[string]bool)
+	for i, contact := range contacts {
+		for _, group := range contact.Groups {
+			groups[group] = true
+		}
+		if i >= maxDescribedContacts {
+			continue
+		}
+
+		sb.WriteString("  - " + contact.Name)
+		var details []string
+		if len(contact.Nicknames) > 0 {
+			details = append(details, "aka "+strings.Join(contact.Nicknames, ", "))
+		}
+		if contact.Organization != "" {
+			details = append(details, contact.Organization)
+		}
+		if len(details) > 0 {
+			sb.WriteString(" (" + strings.Join(details, "; ") + ")")
+		}
+		sb.WriteString(": " + strings.Join(contact.Emails, ", ") + "\n")
+	}
+	if len(contacts) > maxDescribedContacts {
+		sb.WriteString(fmt.Sprintf("  ... and %d more (use list_contacts)\n", len(contacts)-maxDescribedContacts))
+	}
+
+	if len(groups) > 0 {
+		names := make([]string, 0, len(groups))
+		for group := range groups {
+			names = append(names, group)
+		}
+		sort.Strings(names)
+		sb.WriteString("Groups (expand to all members): " + strings.Join(names, ", ") + "\n")
+	}
+	return sb.String()
+}
+
+// contactAddresses returns the first address of each contact, named after the contact
+func contactAddresses(contacts []ContactInfo) ([]*mail.Address, error) {
+	var addresses []*mail.Address
+	for _, contact := range contacts {
+		addr, err := mail.ParseAddress(contact.Emails[0])
+		if err != nil {
+			return nil, fmt.Errorf("contact %q has an invalid address %q", contact.Name, contact.Emails[0])
+		}
+		if addr.Name == "" {
+			addr.Name = contact.Name
+		}
+		addresses = append(addresses, addr)
+	}
+	return addresses, nil
+}
+
+// ambiguousContactError lists the contacts a name could refer to
+func ambiguousContactError(query string, contacts []ContactInfo) error {
+	var candidates []string
+	for _, contact := range contacts {
+		candidates = append(candidates, fmt.Sprintf("%s <%s>", contact.Name, contact.Emails[0]))
+	}
+	return fmt.Errorf("%q is ambiguous, it matches %s; use the full name or an email address", query, strings.Join(candidates, ", "))
+}
+
+// contactSearchText is the lower-cased text list_contacts queries search
+func contactSearchText(c *Contact) string {
+	parts := append([]string{c.Name, c.Organization, c.Notes}, c.Nicknames...)
+	parts = append(parts, c.Emails...)
+	return normalizeName(strings.Join(parts, " "))
+}
+
+// normalizeName lower-cases a name and collapses its whitespace
+func normalizeName(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// containsFold reports whether list contains value, ignoring case and extra whitespace
+func containsFold(list []string, value string) bool {
+	value = normalizeName(value)
+	for _, item := range list {
+		if normalizeName(item) == value {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesWordPrefixes reports whether every word of query starts a distinct
+// word of label, in order ("jo d" matches "john doe")
+func matchesWordPrefixes(label, query string) bool {
+	labelWords, queryWords := strings.Fields(label), strings.Fields(query)
+	if len(queryWords) == 0 || len(queryWords) > len(labelWords) {
+		return false
+	}
+	i := 0
+	for _, word := range labelWords {
+		if i < len(queryWords) && strings.HasPrefix(word, queryWords[i]) {
+			i++
+		}
+	}
+	return i == len(queryWords)
+}
+
+// levenshtein returns the edit distance between two strings
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
@@ -0,0 +1,421 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/mail"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// Contact import formats
+const (
+	ContactFormatVCard = "vcard"
+	ContactFormatCSV   = "csv"
+)
+
+// ImportResult summarizes a contact import
+type ImportResult struct {
+	// Imported are the names of the contacts added to the contact book
+	Imported []string `json:"imported"`
+	// Merged are existing contacts that got new addresses, nicknames or groups
+	Merged     []string           `json:"merged,omitempty"`
+	Duplicates []ContactDuplicate `json:"duplicates,omitempty"`
+	// Skipped are entries that cannot be used, with the reason
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+// ContactDuplicate is an imported contact that matches a contact already in the book
+type ContactDuplicate struct {
+	Name     string `json:"name"`
+	Existing string `json:"existing"`
+	Reason   string `json:"reason"`
+}
+
+// ContactFormat guesses the format of a contacts file from its name or content
+func ContactFormat(filename string, data []byte) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".vcf", ".vcard":
+		return ContactFormatVCard
+	case ".csv":
+		return ContactFormatCSV
+	}
+	start := bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	if len(start) >= len("BEGIN:VCARD") && strings.EqualFold(string(start[:len("BEGIN:VCARD")]), "BEGIN:VCARD") {
+		return ContactFormatVCard
+	}
+	return ContactFormatCSV
+}
+
+// ParseContacts reads contacts from a vCard or CSV file
+func ParseContacts(data []byte, format string) ([]Contact, error) {
+	switch format {
+	case ContactFormatVCard:
+		return parseVCards(data)
+	case ContactFormatCSV:
+		return parseContactsCSV(data)
+	default:
+		return nil, fmt.Errorf("unsupported contacts format %q (supported: vcard, csv)", format)
+	}
+}
+
+// csvColumn is the contact field a CSV column holds
+type csvColumn int
+
+const (
+	csvIgnored csvColumn = iota
+	csvName
+	csvGivenName
+	csvMiddleName
+	csvFamilyName
+	csvEmail
+	csvNickname
+	csvOrganization
+	csvNotes
+	csvGroups
+)
+
+// csvHeaders maps normalized header names of common exports (Google Contacts,
+// Outlook, Thunderbird and plain spreadsheets) to contact fields. Email columns
+// are recognized separately, since exports number them.
+var csvHeaders = map[string]csvColumn{
+	"name":              csvName,
+	"fullname":          csvName,
+	"displayname":       csvName,
+	"contactname":       csvName,
+	"firstname":         csvGivenName,
+	"givenname":         csvGivenName,
+	"middlename":        csvMiddleName,
+	"additionalname":    csvMiddleName,
+	"lastname":          csvFamilyName,
+	"familyname":        csvFamilyName,
+	"surname":           csvFamilyName,
+	"nickname":          csvNickname,
+	"nicknames":         csvNickname,
+	"organization":      csvOrganization,
+	"organisation":      csvOrganization,
+	"organizationname":  csvOrganization,
+	"organization1name": csvOrganization,
+	"company":           csvOrganization,
+	"companyname":       csvOrganization,
+	"notes":             csvNotes,
+	"note":              csvNotes,
+	"groups":            csvGroups,
+	"group":             csvGroups,
+	"groupmembership":   csvGroups,
+	"labels":            csvGroups,
+	"categories":        csvGroups,
+}
+
+// parseContactsCSV reads contacts from a CSV file with a header row. Columns are
+// matched by name; the delimiter may be a comma, semicolon or tab.
+func parseContactsCSV(data []byte) ([]Contact, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	header, _, _ := bytes.Cut(data, []byte("\n"))
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.Comma = ','
+	for _, delimiter := range []rune{';', '\t'} {
+		if bytes.Count(header, []byte(string(delimiter))) > bytes.Count(header, []byte(string(reader.Comma))) {
+			reader.Comma = delimiter
+		}
+	}
+
+	names, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	columns := make([]csvColumn, len(names))
+	hasEmail := false
+	for i, name := range names {
+		columns[i] = csvColumnOf(name)
+		hasEmail = hasEmail || columns[i] == csvEmail
+	}
+	if !hasEmail {
+		return nil, fmt.Errorf("no email column in CSV header %q", strings.Join(names, string(reader.Comma)))
+	}
+
+	var contacts []Contact
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV: %w", err)
+		}
+		if contact, ok := csvContact(columns, record); ok {
+			contacts = append(contacts, contact)
+		}
+	}
+	if len(contacts) == 0 {
+		return nil, fmt.Errorf("no contacts found in CSV")
+	}
+	return contacts, nil
+}
+
+// csvColumnOf identifies a CSV column by its header, ignoring case, spaces and punctuation
+func csvColumnOf(header string) csvColumn {
+	key := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, header)
+
+	if column, ok := csvHeaders[key]; ok {
+		return column
+	}
+	// "E-mail 1 - Value", "E-mail Address", "Primary Email", but not "E-mail 1 - Type"
+	if strings.Contains(key, "mail") && !strings.Contains(key, "type") &&
+		!strings.Contains(key, "label") && !strings.Contains(key, "displayname") {
+		return csvEmail
+	}
+	return csvIgnored
+}
+
+// csvContact builds a contact from a CSV record; blank rows are skipped
+func csvContact(columns []csvColumn, record []string) (Contact, bool) {
+	var contact Contact
+	var given, middle, family string
+	blank := true
+
+	for i, value := range record {
+		value = strings.TrimSpace(value)
+		if i >= len(columns) || value == "" {
+			continue
+		}
+		blank = false
+
+		switch columns[i] {
+		case csvName:
+			contact.Name = value
+		case csvGivenName:
+			given = value
+		case csvMiddleName:
+			middle = value
+		case csvFamilyName:
+			family = value
+		case csvEmail:
+			// Google Contacts separates several values in one cell with " ::: "
+			for _, part := range splitAddressList(strings.ReplaceAll(value, ":::", ",")) {
+				addr, err := mail.ParseAddress(part)
+				if err != nil {
+					// Keep it so that the import reports the invalid address
+					contact.Emails = append(contact.Emails, part)
+					continue
+				}
+				contact.Emails = append(contact.Emails, addr.Address)
+				if contact.Name == "" && addr.Name != "" {
+					contact.Name = addr.Name
+				}
+			}
+		case csvNickname:
+			contact.Nicknames = appendNonEmpty(contact.Nicknames, strings.FieldsFunc(value, isListSeparator)...)
+		case csvOrganization:
+			contact.Organization = value
+		case csvNotes:
+			contact.Notes = value
+		case csvGroups:
+			for _, group := range strings.Split(strings.ReplaceAll(value, ":::", ","), ",") {
+				// Google Contacts system groups look like "* myContacts"
+				if group = strings.TrimSpace(group); group != "" && !strings.HasPrefix(group, "*") {
+					contact.Groups = append(contact.Groups, group)
+				}
+			}
+		}
+	}
+
+	if name := strings.Join(appendNonEmpty(nil, given, middle, family), " "); name != "" && (contact.Name == "" || strings.Contains(contact.Name, "@")) {
+		contact.Name = name
+	}
+	if contact.Name == "" {
+		contact.Name = contact.Organization
+	}
+	return contact, !blank
+}
+
+// isListSeparator reports whether r separates values of a list cell
+func isListSeparator(r rune) bool {
+	return r == ',' || r == ';'
+}
+
+// Import adds contacts read from a vCard or CSV file to the contacts file. An
+// imported contact with an email address (or name) of a contact already in the
+// book is a duplicate: it is reported and skipped, or with merge its addresses,
+// nicknames, groups and missing details are added to the existing contact.
+// Duplicates within the import are detected the same way. With dryRun the
+// result is reported but nothing is saved.
+func (b *ContactBook) Import(contacts []Contact, merge, dryRun bool) (*ImportResult, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	byEmail := make(map[string]*Contact)
+	byName := make(map[string]*Contact)
+	index := func(contact *Contact) {
+		byName[normalizeName(contact.Name)] = contact
+		for _, email := range contact.Emails {
+			if _, ok := byEmail[emailKey(email)]; !ok {
+				byEmail[emailKey(email)] = contact
+			}
+		}
+	}
+
+	// Work on copies, so that a failed save leaves the book unchanged
+	var file []*Contact
+	for _, contact := range b.file {
+		copied := cloneContact(contact)
+		file = append(file, copied)
+		index(copied)
+	}
+	fromConfig := make(map[*Contact]bool)
+	for _, info := range b.all() {
+		if info.Source == ContactSourceConfig {
+			copied := cloneContact(&info.Contact)
+			fromConfig[copied] = true
+			index(copied)
+		}
+	}
+
+	result := &ImportResult{Imported: []string{}}
+	changed := false
+	for i := range contacts {
+		contact := cloneContact(&contacts[i])
+		if err := contact.validate(); err != nil {
+			result.Skipped = append(result.Skipped, fmt.Sprintf("%s: %v", importLabel(contact, i), err))
+			continue
+		}
+
+		existing, reason := findDuplicate(contact, byEmail, byName)
+		if existing == nil {
+			file = append(file, contact)
+			index(contact)
+			result.Imported = append(result.Imported, contact.Name)
+			changed = true
+			continue
+		}
+
+		if !merge || !mergeContact(existing, contact) {
+			if merge {
+				reason += ", nothing new to merge"
+			}
+			result.Duplicates = append(result.Duplicates, ContactDuplicate{Name: contact.Name, Existing: existing.Name, Reason: reason})
+			continue
+		}
+		if fromConfig[existing] {
+			// A merged config.json contact moves to the contacts file, like on update_contact
+			delete(fromConfig, existing)
+			file = append(file, existing)
+		}
+		index(existing)
+		if !containsFold(result.Merged, existing.Name) {
+			result.Merged = append(result.Merged, existing.Name)
+		}
+		changed = true
+	}
+
+	if dryRun || !changed {
+		return result, nil
+	}
+	if err := b.save(file); err != nil {
+		return nil, err
+	}
+	b.file = file
+	return result, nil
+}
+
+// findDuplicate returns the contact sharing an email address or the name with contact, and why
+func findDuplicate(contact *Contact, byEmail, byName map[string]*Contact) (*Contact, string) {
+	for _, email := range contact.Emails {
+		if existing, ok := byEmail[emailKey(email)]; ok {
+			return existing, "same email address " + email
+		}
+	}
+	if existing, ok := byName[normalizeName(contact.Name)]; ok {
+		return existing, "same name"
+	}
+	return nil, ""
+}
+
+// mergeContact adds the addresses, nicknames and groups of from to into, and
+// fills its empty organization and notes. It reports whether into changed.
+func mergeContact(into, from *Contact) bool {
+	changed := false
+	known := make(map[string]bool)
+	for _, email := range into.Emails {
+		known[emailKey(email)] = true
+	}
+	for _, email := range from.Emails {
+		if !known[emailKey(email)] {
+			known[emailKey(email)] = true
+			into.Emails = append(into.Emails, email)
+			changed = true
+		}
+	}
+
+	nicknames := from.Nicknames
+	if normalizeName(from.Name) != normalizeName(into.Name) {
+		nicknames = append([]string{from.Name}, nicknames...)
+	}
+	for _, nickname := range nicknames {
+		if normalizeName(nickname) != normalizeName(into.Name) && !containsFold(into.Nicknames, nickname) {
+			into.Nicknames = append(into.Nicknames, nickname)
+			changed = true
+		}
+	}
+	for _, group := range from.Groups {
+		if !containsFold(into.Groups, group) {
+			into.Groups = append(into.Groups, group)
+			changed = true
+		}
+	}
+
+	if into.Organization == "" && from.Organization != "" {
+		into.Organization = from.Organization
+		changed = true
+	}
+	if into.Notes == "" && from.Notes != "" {
+		into.Notes = from.Notes
+		changed = true
+	}
+	return changed
+}
+
+// emailKey is the lower-cased bare address of an email, for duplicate detection
+func emailKey(email string) string {
+	if addr, err := mail.ParseAddress(email); err == nil {
+		return strings.ToLower(addr.Address)
+	}
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// cloneContact returns a copy of contact that shares no slices with it
+func cloneContact(contact *Contact) *Contact {
+	copied := *contact
+	copied.Emails = append([]string(nil), contact.Emails...)
+	copied.Nicknames = append([]string(nil), contact.Nicknames...)
+	copied.Groups = append([]string(nil), contact.Groups...)
+	return &copied
+}
+
+// importLabel names an imported entry in messages, even when it has no name
+func importLabel(contact *Contact, i int) string {
+	switch {
+	case contact.Name != "":
+		return contact.Name
+	case len(contact.Emails) > 0:
+		return contact.Emails[0]
+	default:
+		return fmt.Sprintf("entry %d", i+1)
+	}
+}
+
+// Export returns the contacts matching query and group as vCard 3.0
+func (b *ContactBook) Export(query, group string) string {
+	return formatVCards(b.List(query, group))
+}
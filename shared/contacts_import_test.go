@@ -0,0 +1,225 @@
+package shared
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestContactFormat(t *testing.T) {
+	tests := []struct {
+		filename string
+		data     string
+		want     string
+	}{
+		{"contacts.vcf", "", ContactFormatVCard},
+		{"contacts.VCARD", "", ContactFormatVCard},
+		{"contacts.csv", "BEGIN:VCARD", ContactFormatCSV},
+		{"", "\xef\xbb\xbf\r\nbegin:vcard\r\n", ContactFormatVCard},
+		{"export.txt", "Name,Email\n", ContactFormatCSV},
+	}
+
+	for _, tt := range tests {
+		if got := ContactFormat(tt.filename, []byte(tt.data)); got != tt.want {
+			t.Errorf("ContactFormat(%q, %q) = %q, want %q", tt.filename, tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestParseContactsCSV(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []Contact
+	}{
+		{
+			name: "Google Contacts export",
+			data: "Name,Given Name,Family Name,Nickname,E-mail 1 - Type,E-mail 1 - Value,E-mail 2 - Type,E-mail 2 - Value,Group Membership,Organization 1 - Name\n" +
+				"Jane Doe,Jane,Doe,JD,* Home,jane@example.com ::: jane@home.example,* Work,jane@work.example,* myContacts ::: Friends,Acme\n" +
+				",,,,,,,,,\n",
+			want: []Contact{{
+				Name:         "Jane Doe",
+				Emails:       []string{"jane@example.com", "jane@home.example", "jane@work.example"},
+				Nicknames:    []string{"JD"},
+				Organization: "Acme",
+				Groups:       []string{"Friends"},
+			}},
+		},
+		{
+			name: "Outlook export with semicolons and name parts",
+			data: "\xef\xbb\xbfFirst Name;Middle Name;Last Name;E-mail Address;E-mail Display Name;Company;Notes\r\n" +
+				"John;Q;Public;john@example.com;John Public (john@example.com);Initech;\"Met at a fair; call back\"\r\n",
+			want: []Contact{{
+				Name:         "John Q Public",
+				Emails:       []string{"john@example.com"},
+				Organization: "Initech",
+				Notes:        "Met at a fair; call back",
+			}},
+		},
+		{
+			name: "tab separated with name in the address",
+			data: "Email\tCategories\n\"\"\"Smith, Bob\"\" <bob@example.com>\"\tWork,Golf\n",
+			want: []Contact{{
+				Name:   "Smith, Bob",
+				Emails: []string{"bob@example.com"},
+				Groups: []string{"Work", "Golf"},
+			}},
+		},
+		{
+			name: "invalid address is kept for the import to report",
+			data: "name,email\nBroken,not-an-address\n",
+			want: []Contact{{Name: "Broken", Emails: []string{"not-an-address"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseContacts([]byte(tt.data), ContactFormatCSV)
+			if err != nil {
+				t.Fatalf("ParseContacts() error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseContacts() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseContactsCSVErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"empty", ""},
+		{"no email column", "Name,Phone\nJane,123\n"},
+		{"header only", "Name,Email\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseContacts([]byte(tt.data), ContactFormatCSV); err == nil {
+				t.Error("ParseContacts() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestCSVColumnOf(t *testing.T) {
+	tests := []struct {
+		header string
+		want   csvColumn
+	}{
+		{"Name", csvName},
+		{"Display Name", csvName},
+		{"Given Name", csvGivenName},
+		{"Surname", csvFamilyName},
+		{"E-mail 1 - Value", csvEmail},
+		{"Primary Email", csvEmail},
+		{"E-mail 1 - Type", csvIgnored},
+		{"E-mail Display Name", csvIgnored},
+		{"Group Membership", csvGroups},
+		{"Phone", csvIgnored},
+	}
+
+	for _, tt := range tests {
+		if got := csvColumnOf(tt.header); got != tt.want {
+			t.Errorf("csvColumnOf(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestContactBookImport(t *testing.T) {
+	imported := []Contact{
+		{Name: "Carol New", Emails: []string{"carol@example.com"}},
+		{Name: "Jane D.", Emails: []string{"JANE@example.com", "jane@home.example"}, Groups: []string{"Friends"}},
+		{Name: "bob", Emails: []string{"bob@other.example"}},
+		{Name: "Carol N.", Emails: []string{"carol@example.com"}},
+		{Name: "Broken", Emails: []string{"not-an-address"}},
+		{Name: "", Emails: []string{"anon@example.com"}},
+	}
+
+	tests := []struct {
+		name           string
+		merge          bool
+		dryRun         bool
+		wantImported   []string
+		wantMerged     []string
+		wantDuplicates []ContactDuplicate
+		wantJane       []string
+		wantSaved      bool
+	}{
+		{
+			name:         "skip duplicates",
+			wantImported: []string{"Carol New"},
+			wantDuplicates: []ContactDuplicate{
+				{Name: "Jane D.", Existing: "Jane Doe", Reason: "same email address JANE@example.com"},
+				{Name: "bob", Existing: "Bob", Reason: "same name"},
+				{Name: "Carol N.", Existing: "Carol New", Reason: "same email address carol@example.com"},
+			},
+			wantJane:  []string{"jane@example.com"},
+			wantSaved: true,
+		},
+		{
+			name:         "merge duplicates",
+			merge:        true,
+			wantImported: []string{"Carol New"},
+			wantMerged:   []string{"Jane Doe", "Bob", "Carol New"},
+			wantJane:     []string{"jane@example.com", "jane@home.example"},
+			wantSaved:    true,
+		},
+		{
+			name:         "dry run",
+			merge:        true,
+			dryRun:       true,
+			wantImported: []string{"Carol New"},
+			wantMerged:   []string{"Jane Doe", "Bob", "Carol New"},
+			wantJane:     []string{"jane@example.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "contacts.json")
+			book := loadContactBook(path, map[string]string{"Bob": "bob@example.com"}, &configProblems{})
+			if err := book.Add(Contact{Name: "Jane Doe", Emails: []string{"jane@example.com"}}); err != nil {
+				t.Fatal(err)
+			}
+			before, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := book.Import(imported, tt.merge, tt.dryRun)
+			if err != nil {
+				t.Fatalf("Import() error: %v", err)
+			}
+
+			if !reflect.DeepEqual(result.Imported, tt.wantImported) {
+				t.Errorf("Imported = %v, want %v", result.Imported, tt.wantImported)
+			}
+			if !reflect.DeepEqual(result.Merged, tt.wantMerged) {
+				t.Errorf("Merged = %v, want %v", result.Merged, tt.wantMerged)
+			}
+			if !reflect.DeepEqual(result.Duplicates, tt.wantDuplicates) {
+				t.Errorf("Duplicates = %+v, want %+v", result.Duplicates, tt.wantDuplicates)
+			}
+			if len(result.Skipped) != 2 {
+				t.Errorf("Skipped = %v, want the invalid address and the unnamed entry", result.Skipped)
+			}
+
+			jane := book.List("Jane Doe", "")
+			if len(jane) != 1 || !reflect.DeepEqual(jane[0].Emails, tt.wantJane) {
+				t.Errorf("Jane Doe = %+v, want emails %v", jane, tt.wantJane)
+			}
+
+			after, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if saved := string(after) != string(before); saved != tt.wantSaved {
+				t.Errorf("contacts file changed = %v, want %v", saved, tt.wantSaved)
+			}
+		})
+	}
+}
@@ -0,0 +1,347 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+const (
+	// correspondentsSaveDelay batches the writes of the correspondents file
+	correspondentsSaveDelay = 5 * time.Second
+	// maxCorrespondents caps the store; the correspondents seen longest ago are dropped first
+	maxCorrespondents = 5000
+	// maxCountedMessages caps the Message-IDs remembered to count every email only once
+	maxCountedMessages = 20000
+)
+
+// correspondentStores holds one store per correspondents file, shared by all
+// configs (and reloads) that use it, so unsaved entries are never read over
+var (
+	correspondentStores   = make(map[string]*CorrespondentStore)
+	correspondentStoresMu sync.Mutex
+)
+
+// Correspondent is a person seen as sender or recipient of an email
+type Correspondent struct {
+	Address      string    `json:"address"`
+	Name         string    `json:"name,omitempty"`
+	MessageCount int       `json:"message_count"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+// correspondentsFile is the format of the correspondents file
+type correspondentsFile struct {
+	Correspondents []*Correspondent `json:"correspondents"`
+	// Messages are the Message-IDs already counted, oldest first, so that
+	// listing a folder again does not count its emails twice
+	Messages []string `json:"messages"`
+}
+
+// CorrespondentStore keeps the known correspondents harvested from fetched and
+// sent emails. Changes are written to its file shortly after they happen.
+type CorrespondentStore struct {
+	mu        sync.Mutex
+	path      string
+	entries   map[string]*Correspondent
+	counted   map[string]bool
+	messages  []string
+	saveTimer *time.Timer
+}
+
+// openCorrespondentStore returns the store of the file at path, reading it on first use
+func openCorrespondentStore(path string) (*CorrespondentStore, error) {
+	key, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	correspondentStoresMu.Lock()
+	defer correspondentStoresMu.Unlock()
+
+	if store, ok := correspondentStores[key]; ok {
+		return store, nil
+	}
+
+	store := &CorrespondentStore{
+		path:    path,
+		entries: make(map[string]*Correspondent),
+		counted: make(map[string]bool),
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		var file correspondentsFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		for _, correspondent := range file.Correspondents {
+			if correspondent != nil && correspondent.Address != "" {
+				store.entries[strings.ToLower(correspondent.Address)] = correspondent
+			}
+		}
+		for _, id := range file.Messages {
+			store.counted[id] = true
+		}
+		store.messages = file.Messages
+	}
+
+	correspondentStores[key] = store
+	return store, nil
+}
+
+// record counts one email for each of addresses. Emails with a Message-ID are
+// counted only once, however often they are fetched.
+func (s *CorrespondentStore) record(messageID string, date time.Time, addresses []*mail.Address) {
+	if len(addresses) == 0 {
+		return
+	}
+	if date.IsZero() || date.After(time.Now()) {
+		date = time.Now()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if messageID != "" {
+		if s.counted[messageID] {
+			return
+		}
+		s.counted[messageID] = true
+		s.messages = append(s.messages, messageID)
+		if len(s.messages) > maxCountedMessages {
+			for _, id := range s.messages[:len(s.messages)-maxCountedMessages] {
+				delete(s.counted, id)
+			}
+			s.messages = append([]string(nil), s.messages[len(s.messages)-maxCountedMessages:]...)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, addr := range addresses {
+		key := strings.ToLower(addr.Address)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		correspondent, ok := s.entries[key]
+		if !ok {
+			correspondent = &Correspondent{Address: addr.Address}
+			s.entries[key] = correspondent
+		}
+		correspondent.MessageCount++
+		if !date.Before(correspondent.LastSeen) {
+			correspondent.LastSeen = date
+			// Prefer the most recent display name, unless it just repeats the address
+			if addr.Name != "" && !strings.Contains(addr.Name, "@") {
+				correspondent.Name = addr.Name
+			}
+		} else if correspondent.Name == "" && !strings.Contains(addr.Name, "@") {
+			correspondent.Name = addr.Name
+		}
+	}
+
+	if s.saveTimer == nil {
+		s.saveTimer = time.AfterFunc(correspondentsSaveDelay, s.flush)
+	}
+}
+
+// flush writes pending changes to the correspondents file
+func (s *CorrespondentStore) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.saveTimer == nil {
+		return
+	}
+	s.saveTimer.Stop()
+	s.saveTimer = nil
+
+	correspondents := make([]*Correspondent, 0, len(s.entries))
+	for _, correspondent := range s.entries {
+		correspondents = append(correspondents, correspondent)
+	}
+	sortCorrespondents(correspondents)
+	if len(correspondents) > maxCorrespondents {
+		// Keep the most active ones
+		sort.SliceStable(correspondents, func(i, j int) bool {
+			return correspondents[i].LastSeen.After(correspondents[j].LastSeen)
+		})
+		for _, dropped := range correspondents[maxCorrespondents:] {
+			delete(s.entries, strings.ToLower(dropped.Address))
+		}
+		correspondents = correspondents[:maxCorrespondents]
+		sortCorrespondents(correspondents)
+	}
+	data, err := json.MarshalIndent(correspondentsFile{Correspondents: correspondents, Messages: s.messages}, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(s.path, append(data, '\n'))
+	}
+	if err != nil {
+		log.Printf("Failed to save correspondents: %v", err)
+	}
+}
+
+// Find returns up to limit correspondents whose name or address contains query,
+// or whose name words start with the query words, most frequent first
+func (s *CorrespondentStore) Find(query string, limit int) []Correspondent {
+	query = normalizeName(query)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var found []*Correspondent
+	for _, correspondent := range s.entries {
+		name := normalizeName(correspondent.Name)
+		if query == "" || strings.Contains(strings.ToLower(correspondent.Address), query) ||
+			strings.Contains(name, query) || matchesWordPrefixes(name, query) {
+			found = append(found, correspondent)
+		}
+	}
+	sortCorrespondents(found)
+	if limit > 0 && len(found) > limit {
+		found = found[:limit]
+	}
+	result := make([]Correspondent, len(found))
+	for i, correspondent := range found {
+		result[i] = *correspondent
+	}
+	return result
+}
+
+// Resolve returns the address of the correspondent with the given display name,
+// matched case-insensitively. It returns nil without error when nobody has
+// that name, and an error when several addresses do.
+func (s *CorrespondentStore) Resolve(name string) (*mail.Address, error) {
+	key := normalizeName(name)
+	if key == "" || strings.Contains(key, "@") {
+		return nil, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var matches []*Correspondent
+	for _, correspondent := range s.entries {
+		if normalizeName(correspondent.Name) == key {
+			matches = append(matches, correspondent)
+		}
+	}
+	sortCorrespondents(matches)
+
+	switch len(matches) {
+	case 0:
+		return nil, nil
+	case 1:
+		return &mail.Address{Name: matches[0].Name, Address: matches[0].Address}, nil
+	}
+	var candidates []string
+	for _, correspondent := range matches {
+		candidates = append(candidates, fmt.Sprintf("%s <%s>", correspondent.Name, correspondent.Address))
+	}
+	return nil, fmt.Errorf("%q is ambiguous, it matches correspondents %s; use an email address", name, strings.Join(candidates, ", "))
+}
+
+// sortCorrespondents orders correspondents by message count, then by last seen
+func sortCorrespondents(correspondents []*Correspondent) {
+	sort.Slice(correspondents, func(i, j int) bool {
+		a, b := correspondents[i], correspondents[j]
+		if a.MessageCount != b.MessageCount {
+			return a.MessageCount > b.MessageCount
+		}
+		if !a.LastSeen.Equal(b.LastSeen) {
+			return a.LastSeen.After(b.LastSeen)
+		}
+		return a.Address < b.Address
+	})
+}
+
+// FlushCorrespondents writes pending correspondent changes, for use on shutdown
+func FlushCorrespondents() {
+	correspondentStoresMu.Lock()
+	stores := make([]*CorrespondentStore, 0, len(correspondentStores))
+	for _, store := range correspondentStores {
+		stores = append(stores, store)
+	}
+	correspondentStoresMu.Unlock()
+
+	for _, store := range stores {
+		store.flush()
+	}
+}
+
+// KnownCorrespondents returns the correspondents store, or nil when harvesting is disabled
+func (c *Config) KnownCorrespondents() *CorrespondentStore {
+	return c.correspondents
+}
+
+// harvest records the correspondents of an email, leaving out our own
+// addresses and automated senders that cannot be written to
+func (c *Config) harvest(messageID string, date time.Time, addresses []*mail.Address) {
+	if c.correspondents == nil {
+		return
+	}
+	var people []*mail.Address
+	for _, addr := range addresses {
+		if addr == nil || !strings.Contains(addr.Address, "@") || c.IsOwnAddress(addr.Address) || isAutomatedAddress(addr.Address) {
+			continue
+		}
+		people = append(people, addr)
+	}
+	c.correspondents.record(messageID, date, people)
+}
+
+// harvestEnvelope records the sender and recipients of a fetched email
+func (c *Config) harvestEnvelope(envelope *imap.Envelope) {
+	if c.correspondents == nil || envelope == nil {
+		return
+	}
+	var addresses []*mail.Address
+	for _, list := range [][]imap.Address{envelope.From, envelope.To, envelope.Cc} {
+		for _, addr := range list {
+			// Group syntax markers have no host
+			if addr.Host != "" {
+				addresses = append(addresses, &mail.Address{Name: addr.Name, Address: addr.Addr()})
+			}
+		}
+	}
+	c.harvest(envelope.MessageID, envelope.Date, addresses)
+}
+
+// harvestSent records the recipients of an email we sent, using the Message-ID
+// of the composed message so that it is not counted again when read from Sent
+func (c *Config) harvestSent(msg []byte, recipients []*mail.Address) {
+	if c.correspondents == nil {
+		return
+	}
+	messageID := ""
+	if parsed, err := mail.ReadMessage(bytes.NewReader(msg)); err == nil {
+		messageID = strings.Trim(parsed.Header.Get("Message-Id"), "<> ")
+	}
+	c.harvest(messageID, time.Now(), recipients)
+}
+
+// isAutomatedAddress reports whether an address belongs to a mailer that does not read replies
+func isAutomatedAddress(address string) bool {
+	local, _, _ := strings.Cut(strings.ToLower(address), "@")
+	local = strings.NewReplacer("-", "", "_", "", ".", "").Replace(local)
+	for _, marker := range []string{"noreply", "donotreply", "mailerdaemon", "postmaster", "bounce"} {
+		if strings.Contains(local, marker) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,288 @@
+package shared
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestCorrespondentStore opens a store on a file in a temp dir and drops it
+// from the shared registry when the test ends
+func newTestCorrespondentStore(t *testing.T) *CorrespondentStore {
+	t.Helper()
+
+	return newTestCorrespondentStoreAt(t, filepath.Join(t.TempDir(), "correspondents.json"))
+}
+
+// newTestCorrespondentStoreAt opens the store of the file at path
+func newTestCorrespondentStoreAt(t *testing.T, path string) *CorrespondentStore {
+	t.Helper()
+
+	store, err := openCorrespondentStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { forgetCorrespondentStore(t, store) })
+	return store
+}
+
+// forgetCorrespondentStore stops the pending save of a store and removes it from the registry
+func forgetCorrespondentStore(t *testing.T, store *CorrespondentStore) {
+	t.Helper()
+
+	store.mu.Lock()
+	if store.saveTimer != nil {
+		store.saveTimer.Stop()
+		store.saveTimer = nil
+	}
+	store.mu.Unlock()
+
+	key, err := filepath.Abs(store.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	correspondentStoresMu.Lock()
+	delete(correspondentStores, key)
+	correspondentStoresMu.Unlock()
+}
+
+// testAddresses parses addresses, panicking on invalid ones
+func testAddresses(list ...string) []*mail.Address {
+	var result []*mail.Address
+	for _, s := range list {
+		addr, err := mail.ParseAddress(s)
+		if err != nil {
+			panic(err)
+		}
+		result = append(result, addr)
+	}
+	return result
+}
+
+func TestCorrespondentStoreRecord(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 12, 0, 0, 0, time.UTC) }
+
+	type email struct {
+		messageID string
+		date      time.Time
+		addresses []*mail.Address
+	}
+	tests := []struct {
+		name      string
+		emails    []email
+		wantCount int
+		wantName  string
+		wantSeen  time.Time
+	}{
+		{
+			name:      "counted once per Message-ID",
+			emails:    []email{{"a@x", day(1), testAddresses("Alice <alice@example.com>")}, {"a@x", day(1), testAddresses("Alice <alice@example.com>")}},
+			wantCount: 1,
+			wantName:  "Alice",
+			wantSeen:  day(1),
+		},
+		{
+			name:      "emails without Message-ID are always counted",
+			emails:    []email{{"", day(1), testAddresses("alice@example.com")}, {"", day(2), testAddresses("alice@example.com")}},
+			wantCount: 2,
+			wantSeen:  day(2),
+		},
+		{
+			name:      "address listed twice in one email, in another case",
+			emails:    []email{{"a@x", day(1), testAddresses("Alice <alice@example.com>", "ALICE@example.com")}},
+			wantCount: 1,
+			wantName:  "Alice",
+			wantSeen:  day(1),
+		},
+		{
+			name: "most recent name wins",
+			emails: []email{
+				{"b@x", day(2), testAddresses("Alice Smith <alice@example.com>")},
+				{"a@x", day(1), testAddresses("A. <alice@example.com>")},
+			},
+			wantCount: 2,
+			wantName:  "Alice Smith",
+			wantSeen:  day(2),
+		},
+		{
+			name: "older name fills a missing one",
+			emails: []email{
+				{"b@x", day(2), testAddresses("alice@example.com")},
+				{"a@x", day(1), testAddresses("Alice <alice@example.com>")},
+			},
+			wantCount: 2,
+			wantName:  "Alice",
+			wantSeen:  day(2),
+		},
+		{
+			name: "name repeating an address is ignored",
+			emails: []email{
+				{"a@x", day(1), testAddresses("Alice <alice@example.com>")},
+				{"b@x", day(2), testAddresses(`"alice@example.com" <alice@example.com>`)},
+			},
+			wantCount: 2,
+			wantName:  "Alice",
+			wantSeen:  day(2),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestCorrespondentStore(t)
+			for _, e := range tt.emails {
+				store.record(e.messageID, e.date, e.addresses)
+			}
+			found := store.Find("alice@example.com", 0)
+			if len(found) != 1 {
+				t.Fatalf("Find() = %+v, want one correspondent", found)
+			}
+			if got := found[0]; got.MessageCount != tt.wantCount || got.Name != tt.wantName || !got.LastSeen.Equal(tt.wantSeen) {
+				t.Errorf("correspondent = %d, %q, %v, want %d, %q, %v", got.MessageCount, got.Name, got.LastSeen, tt.wantCount, tt.wantName, tt.wantSeen)
+			}
+		})
+	}
+}
+
+func TestCorrespondentStoreRecordFutureDate(t *testing.T) {
+	store := newTestCorrespondentStore(t)
+	store.record("a@x", time.Now().Add(48*time.Hour), testAddresses("alice@example.com"))
+	if seen := store.Find("alice", 0)[0].LastSeen; seen.After(time.Now()) {
+		t.Errorf("LastSeen = %v, want a date no later than now", seen)
+	}
+}
+
+func TestCorrespondentStoreCountedMessagesCap(t *testing.T) {
+	store := newTestCorrespondentStore(t)
+	for i := 0; i <= maxCountedMessages; i++ {
+		store.record(fmt.Sprintf("%d@x", i), time.Time{}, testAddresses("alice@example.com"))
+	}
+	if len(store.messages) != maxCountedMessages || len(store.counted) != maxCountedMessages {
+		t.Fatalf("remembered %d/%d Message-IDs, want %d", len(store.messages), len(store.counted), maxCountedMessages)
+	}
+
+	// The newest Message-ID is still known, the oldest one is counted again
+	store.record(fmt.Sprintf("%d@x", maxCountedMessages), time.Time{}, testAddresses("alice@example.com"))
+	store.record("0@x", time.Time{}, testAddresses("alice@example.com"))
+	if got, want := store.Find("alice", 0)[0].MessageCount, maxCountedMessages+2; got != want {
+		t.Errorf("MessageCount = %d, want %d", got, want)
+	}
+}
+
+func TestCorrespondentStoreFlush(t *testing.T) {
+	store := newTestCorrespondentStore(t)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i <= maxCorrespondents; i++ {
+		store.record("", start.Add(time.Duration(i)*time.Minute), testAddresses(fmt.Sprintf("person%d@example.com", i)))
+	}
+	store.record("m@x", start, testAddresses("Alice <alice@example.com>"))
+	store.flush()
+
+	data, err := os.ReadFile(store.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file correspondentsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatal(err)
+	}
+	if len(file.Correspondents) != maxCorrespondents {
+		t.Fatalf("saved %d correspondents, want %d", len(file.Correspondents), maxCorrespondents)
+	}
+	// The two seen longest ago are dropped
+	for _, correspondent := range file.Correspondents {
+		if correspondent.Address == "alice@example.com" || correspondent.Address == "person0@example.com" {
+			t.Errorf("saved %s, want it dropped", correspondent.Address)
+		}
+	}
+	if len(store.Find("", 0)) != maxCorrespondents {
+		t.Errorf("store keeps %d correspondents, want %d", len(store.Find("", 0)), maxCorrespondents)
+	}
+
+	// A store opened on the file again knows the saved correspondents and Message-IDs
+	forgetCorrespondentStore(t, store)
+	reopened := newTestCorrespondentStoreAt(t, store.path)
+	if got := reopened.Find("person1@example.com", 0); len(got) != 1 || got[0].MessageCount != 1 {
+		t.Errorf("reopened Find() = %+v, want person1 with one email", got)
+	}
+	if !reopened.counted["m@x"] {
+		t.Error("reopened store forgot the counted Message-ID")
+	}
+}
+
+func TestCorrespondentStoreResolve(t *testing.T) {
+	store := newTestCorrespondentStore(t)
+	store.record("1@x", time.Time{}, testAddresses("Alice Smith <alice@example.com>", "Bob <bob@work.example>", "Bob <bob@home.example>"))
+
+	tests := []struct {
+		name    string
+		want    string
+		wantErr string
+	}{
+		{name: "Alice Smith", want: "alice@example.com"},
+		{name: "  alice   SMITH ", want: "alice@example.com"},
+		{name: "Alice"},
+		{name: "Carol"},
+		{name: ""},
+		{name: "alice@example.com"},
+		{name: "bob", wantErr: `"bob" is ambiguous, it matches correspondents Bob <bob@home.example>, Bob <bob@work.example>`},
+	}
+
+	for _, tt := range tests {
+		addr, err := store.Resolve(tt.name)
+		if tt.wantErr != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+				t.Errorf("Resolve(%q) error = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		got := ""
+		if addr != nil {
+			got = addr.Address
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Resolve(%q) = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestIsAutomatedAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		want    bool
+	}{
+		{"noreply@example.com", true},
+		{"no-reply@example.com", true},
+		{"No_Reply@example.com", true},
+		{"do-not-reply@example.com", true},
+		{"donotreply@example.com", true},
+		{"MAILER-DAEMON@example.com", true},
+		{"postmaster@example.com", true},
+		{"bounces+123@example.com", true},
+		{"alice@example.com", false},
+		{"reply@example.com", false},
+		{"nora@noreply.example.com", false},
+	}
+
+	for _, tt := range tests {
+		if got := isAutomatedAddress(tt.address); got != tt.want {
+			t.Errorf("isAutomatedAddress(%q) = %v, want %v", tt.address, got, tt.want)
+		}
+	}
+}
+
+func TestConfigHarvest(t *testing.T) {
+	store := newTestCorrespondentStore(t)
+	config := &Config{MyEmail: "me@example.com", correspondents: store}
+	config.harvest("1@x", time.Time{}, testAddresses("Me <ME@example.com>", "Alice <alice@example.com>", "noreply@example.com"))
+	config.harvest("1@x", time.Time{}, testAddresses("Alice <alice@example.com>"))
+
+	found := store.Find("", 0)
+	if len(found) != 1 || found[0].Address != "alice@example.com" || found[0].MessageCount != 1 {
+		t.Errorf("harvested %+v, want only alice@example.com once", found)
+	}
+}
@@ -0,0 +1,105 @@
+package shared
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	// EnvPrefix starts the environment variables that override config fields,
+	// e.g. EMAILBOX_IMAP_PASSWORD for imap.password
+	EnvPrefix = "EMAILBOX_"
+	// ConfigPathEnv names the config file when no path is given
+	ConfigPathEnv = "EMAILBOX_CONFIG"
+)
+
+// hasEnvOverrides reports whether any config field is set through the environment
+func hasEnvOverrides() bool {
+	for _, entry := range os.Environ() {
+		name, _, _ := strings.Cut(entry, "=")
+		if strings.HasPrefix(name, EnvPrefix) && name != ConfigPathEnv {
+			return true
+		}
+	}
+	return false
+}
+
+// applyEnvOverrides sets config fields from EMAILBOX_* environment variables.
+// The variable name is the upper-cased JSON path joined with underscores
+// (imap.password becomes EMAILBOX_IMAP_PASSWORD). Strings, numbers and
+// booleans are taken literally; lists, maps and objects such as contacts or
+// accounts are given as JSON, and string lists may also be comma-separated.
+func applyEnvOverrides(config *Config) error {
+	return applyEnvToStruct(reflect.ValueOf(config).Elem(), strings.TrimSuffix(EnvPrefix, "_"))
+}
+
+// applyEnvToStruct applies environment overrides to the JSON fields of a struct
+func applyEnvToStruct(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || tag == "" || tag == "-" {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(tag)
+
+		if field.Type.Kind() == reflect.Struct {
+			if err := applyEnvToStruct(v.Field(i), name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setFromEnv(v.Field(i), value); err != nil {
+			return fmt.Errorf("invalid environment variable %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// setFromEnv parses an environment variable value into a config field
+func setFromEnv(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("expected a number")
+		}
+		field.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("expected true or false")
+		}
+		field.SetBool(b)
+	default:
+		trimmed := strings.TrimSpace(value)
+		if field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(trimmed, "[") {
+			var items []string
+			for _, item := range strings.Split(trimmed, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			field.Set(reflect.ValueOf(items))
+			return nil
+		}
+		target := reflect.New(field.Type())
+		if err := json.Unmarshal([]byte(trimmed), target.Interface()); err != nil {
+			return fmt.Errorf("expected JSON: %w", err)
+		}
+		field.Set(target.Elem())
+	}
+	return nil
+}
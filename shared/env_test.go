@@ -0,0 +1,208 @@
+package shared
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApplyEnvOverrides(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		check   func(*Config) any
+		want    any
+		wantErr string
+	}{
+		{
+			name:  "string",
+			env:   map[string]string{"EMAILBOX_MY_EMAIL": "me@example.com"},
+			check: func(c *Config) any { return c.MyEmail },
+			want:  "me@example.com",
+		},
+		{
+			name:  "nested struct",
+			env:   map[string]string{"EMAILBOX_IMAP_PASSWORD": "s3cret"},
+			check: func(c *Config) any { return c.IMAP.Password },
+			want:  "s3cret",
+		},
+		{
+			name:  "anonymous nested struct",
+			env:   map[string]string{"EMAILBOX_NOTIFICATIONS_MAX_REPLAY": "-1"},
+			check: func(c *Config) any { return c.Notifications.MaxReplay },
+			want:  -1,
+		},
+		{
+			name:  "number with spaces",
+			env:   map[string]string{"EMAILBOX_IMAP_PORT": " 993 "},
+			check: func(c *Config) any { return c.IMAP.Port },
+			want:  993,
+		},
+		{
+			name:  "boolean",
+			env:   map[string]string{"EMAILBOX_NOTIFICATIONS_DISABLE_IDLE": "TRUE"},
+			check: func(c *Config) any { return c.Notifications.DisableIdle },
+			want:  true,
+		},
+		{
+			name:  "boolean pointer",
+			env:   map[string]string{"EMAILBOX_SMTP_REQUIRE_TLS": "false"},
+			check: func(c *Config) any { return c.SMTP.RequireTLS != nil && !*c.SMTP.RequireTLS },
+			want:  true,
+		},
+		{
+			name:  "string list, comma-separated",
+			env:   map[string]string{"EMAILBOX_OAUTH2_SCOPES": " https://mail.google.com/, ,offline "},
+			check: func(c *Config) any { return c.OAuth2.Scopes },
+			want:  []string{"https://mail.google.com/", "offline"},
+		},
+		{
+			name:  "string list as JSON",
+			env:   map[string]string{"EMAILBOX_OAUTH2_SCOPES": `["a,b"]`},
+			check: func(c *Config) any { return c.OAuth2.Scopes },
+			want:  []string{"a,b"},
+		},
+		{
+			name:  "map as JSON",
+			env:   map[string]string{"EMAILBOX_CONTACTS": `{"alice": "alice@example.com"}`},
+			check: func(c *Config) any { return c.Contacts },
+			want:  map[string]string{"alice": "alice@example.com"},
+		},
+		{
+			name:  "list of objects as JSON",
+			env:   map[string]string{"EMAILBOX_IDENTITIES": `[{"address": "team@example.com", "name": "Team"}]`},
+			check: func(c *Config) any { return c.Identities },
+			want:  []Identity{{Address: "team@example.com", Name: "Team"}},
+		},
+		{
+			name:  "accounts as JSON",
+			env:   map[string]string{"EMAILBOX_ACCOUNTS": `[{"name": "work", "imap": {"server": "imap.work.example"}}]`},
+			check: func(c *Config) any { return c.Accounts[0].Name + " " + c.Accounts[0].IMAP.Server },
+			want:  "work imap.work.example",
+		},
+		{
+			name:  "empty value clears a string",
+			env:   map[string]string{"EMAILBOX_IMAP_SERVER": ""},
+			check: func(c *Config) any { return c.IMAP.Server },
+			want:  "",
+		},
+		{
+			name:  "unset variables keep the file value",
+			env:   map[string]string{"EMAILBOX_IMAP_USERNAME": "other"},
+			check: func(c *Config) any { return c.IMAP.Server },
+			want:  "imap.file.example",
+		},
+		{
+			name:    "bad number",
+			env:     map[string]string{"EMAILBOX_IMAP_PORT": "imaps"},
+			wantErr: "invalid environment variable EMAILBOX_IMAP_PORT: expected a number",
+		},
+		{
+			name:    "bad boolean",
+			env:     map[string]string{"EMAILBOX_MARKDOWN_INLINE_CSS": "yes please"},
+			wantErr: "invalid environment variable EMAILBOX_MARKDOWN_INLINE_CSS: expected true or false",
+		},
+		{
+			name:    "bad JSON map",
+			env:     map[string]string{"EMAILBOX_CONTACTS": "alice=alice@example.com"},
+			wantErr: "invalid environment variable EMAILBOX_CONTACTS: expected JSON",
+		},
+		{
+			name:    "JSON of the wrong type",
+			env:     map[string]string{"EMAILBOX_IDENTITIES": `{"address": "team@example.com"}`},
+			wantErr: "invalid environment variable EMAILBOX_IDENTITIES: expected JSON",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			config := &Config{}
+			config.IMAP.Server = "imap.file.example"
+
+			err := applyEnvOverrides(config)
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Fatalf("applyEnvOverrides() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyEnvOverrides() error: %v", err)
+			}
+			if got := tt.check(config); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+// clearEnvOverrides unsets the EMAILBOX_* variables of the test environment for the duration of t
+func clearEnvOverrides(t *testing.T) {
+	t.Helper()
+
+	for _, entry := range os.Environ() {
+		name, _, _ := strings.Cut(entry, "=")
+		if strings.HasPrefix(name, EnvPrefix) {
+			t.Setenv(name, "")
+			os.Unsetenv(name)
+		}
+	}
+}
+
+func TestHasEnvOverrides(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want bool
+	}{
+		{name: "none"},
+		{name: "config path only", env: map[string]string{ConfigPathEnv: "config.json"}},
+		{name: "unrelated variable", env: map[string]string{"IMAP_PASSWORD": "secret"}},
+		{name: "field override", env: map[string]string{"EMAILBOX_IMAP_PASSWORD": "secret"}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnvOverrides(t)
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			if got := hasEnvOverrides(); got != tt.want {
+				t.Errorf("hasEnvOverrides() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigEnvOverridesFile(t *testing.T) {
+	clearEnvOverrides(t)
+	path := filepath.Join(t.TempDir(), "config.json")
+	data := `{
+		"imap": {"server": "imap.example.com", "username": "me", "password": "from-file"},
+		"smtp": {"server": "smtp.example.com", "username": "me", "password": "secret"},
+		"my_email": "me@example.com"
+	}`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("EMAILBOX_IMAP_PASSWORD", "from-env")
+	t.Setenv("EMAILBOX_IMAP_PORT", "1993")
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error: %v", err)
+	}
+	if config.IMAP.Password != "from-env" || config.IMAP.Port != 1993 || config.IMAP.Server != "imap.example.com" {
+		t.Errorf("imap = %q, %d, %q, want from-env, 1993, imap.example.com", config.IMAP.Password, config.IMAP.Port, config.IMAP.Server)
+	}
+
+	t.Setenv("EMAILBOX_IMAP_PORT", "x")
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "EMAILBOX_IMAP_PORT") {
+		t.Errorf("LoadConfig() with a bad port error = %v, want one naming EMAILBOX_IMAP_PORT", err)
+	}
+}
@@ -0,0 +1,129 @@
+package shared
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+// systemFlags maps lower-case flag names, with or without the leading backslash,
+// to the IMAP system flags that may be set by clients. \Deleted is left out on
+// purpose: deleting goes through delete_email, which expunges only its own emails.
+var systemFlags = map[string]imap.Flag{
+	"seen":     imap.FlagSeen,
+	"flagged":  imap.FlagFlagged,
+	"answered": imap.FlagAnswered,
+	"draft":    imap.FlagDraft,
+}
+
+// ParseFlag converts a user-supplied flag name into an IMAP flag.
+// System flags are accepted case-insensitively with or without the backslash
+// (e.g. "seen", "\\Flagged"); anything else is treated as a keyword such as
+// "$AgentProcessed" and must be a valid IMAP atom.
+func ParseFlag(name string) (imap.Flag, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("empty flag name")
+	}
+
+	key := strings.ToLower(strings.TrimPrefix(name, "\\"))
+	if flag, ok := systemFlags[key]; ok {
+		return flag, nil
+	}
+	if key == "deleted" {
+		return "", fmt.Errorf("the \\Deleted flag cannot be set directly, use delete_email instead")
+	}
+	if strings.HasPrefix(name, "\\") {
+		return "", fmt.Errorf("unsupported system flag %q", name)
+	}
+
+	for _, r := range name {
+		if r <= ' ' || r >= 0x7f || strings.ContainsRune(`(){%*"\]`, r) {
+			return "", fmt.Errorf("invalid keyword %q: keywords cannot contain spaces or any of (){%%*\"\\]", name)
+		}
+	}
+	return imap.Flag(name), nil
+}
+
+// parseFlags converts a list of flag names with ParseFlag
+func parseFlags(names []string) ([]imap.Flag, error) {
+	flags := make([]imap.Flag, 0, len(names))
+	for _, name := range names {
+		flag, err := ParseFlag(name)
+		if err != nil {
+			return nil, err
+		}
+		flags = append(flags, flag)
+	}
+	return flags, nil
+}
+
+// SetFlags adds and removes flags and keywords on one or more emails.
+// It returns the resulting flag list of each email, keyed by email ID.
+func (c *IMAPClient) SetFlags(emailIDs []string, folder string, add, remove []string) (map[string][]string, error) {
+	addFlags, err := parseFlags(add)
+	if err != nil {
+		return nil, err
+	}
+	removeFlags, err := parseFlags(remove)
+	if err != nil {
+		return nil, err
+	}
+	if len(addFlags) == 0 && len(removeFlags) == 0 {
+		return nil, fmt.Errorf("no flags to add or remove")
+	}
+
+	folders, groups, err := groupEmailIDsByFolder(emailIDs, folder)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := c.pool.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer c.pool.release(client)
+
+	result := make(map[string][]string)
+	for _, emailFolder := range folders {
+		uidSet := *groups[emailFolder]
+
+		if _, err := selectFolder(client, emailFolder); err != nil {
+			return nil, err
+		}
+
+		if len(addFlags) > 0 {
+			storeFlags := &imap.StoreFlags{
+				Op:     imap.StoreFlagsAdd,
+				Flags:  addFlags,
+				Silent: true,
+			}
+			if err := client.Store(uidSet, storeFlags, nil).Close(); err != nil {
+				return nil, fmt.Errorf("failed to add flags: %w", err)
+			}
+		}
+
+		if len(removeFlags) > 0 {
+			storeFlags := &imap.StoreFlags{
+				Op:     imap.StoreFlagsDel,
+				Flags:  removeFlags,
+				Silent: true,
+			}
+			if err := client.Store(uidSet, storeFlags, nil).Close(); err != nil {
+				return nil, fmt.Errorf("failed to remove flags: %w", err)
+			}
+		}
+
+		// Read back the resulting flags
+		messages, err := client.Fetch(uidSet, &imap.FetchOptions{UID: true, Flags: true}).Collect()
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch flags: %w", err)
+		}
+		for _, msg := range messages {
+			result[FormatEmailID(emailFolder, msg.UID)] = flagNames(msg.Flags)
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,39 @@
+package shared
+
+import (
+	"testing"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+func TestParseFlag(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    imap.Flag
+		wantErr bool
+	}{
+		{"seen", imap.FlagSeen, false},
+		{"\\Flagged", imap.FlagFlagged, false},
+		{" ANSWERED ", imap.FlagAnswered, false},
+		{"draft", imap.FlagDraft, false},
+		{"$NeedsReply", "$NeedsReply", false},
+		{"deleted", "", true},
+		{"\\Deleted", "", true},
+		{"\\Recent", "", true},
+		{"two words", "", true},
+		{"bad(", "", true},
+		{"", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFlag(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseFlag(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseFlag(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package shared
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	// htmlSkipPattern matches elements whose text is not content
+	htmlSkipPattern = regexp.MustCompile(`(?is)<(style|script|head|title)\b[^>]*>.*?</(style|script|head|title)>|<!--.*?-->`)
+	// htmlLinkPattern matches links, capturing the target and the link text
+	htmlLinkPattern = regexp.MustCompile(`(?is)<a\b[^>]*?href\s*=\s*["']([^"']*)["'][^>]*>(.*?)</a>`)
+	// htmlBreakPattern matches tags that end a line
+	htmlBreakPattern = regexp.MustCompile(`(?i)<br\s*/?>|</(div|li|tr)>`)
+	// htmlBlockPattern matches tags that start or end a paragraph-like block
+	htmlBlockPattern = regexp.MustCompile(`(?i)</?(p|h[1-6]|blockquote|pre|ul|ol|table)\b[^>]*>|<hr\s*/?>`)
+	// htmlListItemPattern matches list item openings
+	htmlListItemPattern = regexp.MustCompile(`(?i)<li\b[^>]*>`)
+	// htmlCellPattern matches the end of a table cell
+	htmlCellPattern = regexp.MustCompile(`(?i)</t[dh]>`)
+	// blankLinesPattern matches runs of more than one blank line
+	blankLinesPattern = regexp.MustCompile(`\n{3,}`)
+)
+
+// htmlToText renders HTML as readable plain text: blocks become paragraphs,
+// list items get a dash, and links keep their target in parentheses
+func htmlToText(s string) string {
+	s = htmlSkipPattern.ReplaceAllString(s, "")
+	s = htmlLinkPattern.ReplaceAllStringFunc(s, func(link string) string {
+		match := htmlLinkPattern.FindStringSubmatch(link)
+		target, text := html.UnescapeString(match[1]), strings.TrimSpace(match[2])
+		plain := strings.TrimSpace(html.UnescapeString(htmlTagPattern.ReplaceAllString(text, "")))
+		if plain == "" || plain == target || strings.TrimPrefix(target, "mailto:") == plain {
+			return text
+		}
+		return fmt.Sprintf("%s (%s)", text, target)
+	})
+
+	// Source line breaks are insignificant in HTML, except inside <pre>
+	s = collapseHTMLWhitespace(s)
+
+	s = htmlBreakPattern.ReplaceAllString(s, "\n")
+	s = htmlBlockPattern.ReplaceAllString(s, "\n\n")
+	s = htmlListItemPattern.ReplaceAllString(s, "- ")
+	s = htmlCellPattern.ReplaceAllString(s, "\t")
+	s = htmlTagPattern.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(strings.TrimLeft(line, " "), " \t")
+	}
+	s = blankLinesPattern.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return strings.TrimSpace(s) + "\n"
+}
+
+// htmlPrePattern matches preformatted blocks
+var htmlPrePattern = regexp.MustCompile(`(?is)<pre\b[^>]*>.*?</pre>`)
+
+// collapseHTMLWhitespace turns source line breaks into spaces outside <pre> blocks,
+// keeping line breaks of preformatted text as <br>
+func collapseHTMLWhitespace(s string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range htmlPrePattern.FindAllStringIndex(s, -1) {
+		b.WriteString(strings.Join(strings.Fields(s[last:loc[0]]), " "))
+		b.WriteString(strings.ReplaceAll(strings.TrimRight(s[loc[0]:loc[1]], "\n"), "\n", "<br>"))
+		last = loc[1]
+	}
+	b.WriteString(strings.Join(strings.Fields(s[last:]), " "))
+	return b.String()
+}
+
+// markdownStyles are inline CSS rules applied to rendered markdown. Gmail and
+// Outlook ignore or strip <style> blocks, so styles go on each element.
+var markdownStyles = map[string]string{
+	"h1":         "font-size:24px;font-weight:bold;margin:16px 0 8px",
+	"h2":         "font-size:20px;font-weight:bold;margin:16px 0 8px",
+	"h3":         "font-size:17px;font-weight:bold;margin:14px 0 6px",
+	"h4":         "font-size:15px;font-weight:bold;margin:12px 0 6px",
+	"p":          "margin:0 0 12px",
+	"blockquote": "margin:0 0 12px;padding:0 0 0 12px;border-left:3px solid #d0d7de;color:#57606a",
+	"pre":        "margin:0 0 12px;padding:12px;background:#f6f8fa;border-radius:4px;font-family:Consolas,Menlo,monospace;font-size:13px;white-space:pre-wrap",
+	"code":       "padding:1px 4px;background:#f6f8fa;border-radius:3px;font-family:Consolas,Menlo,monospace;font-size:13px",
+	"table":      "border-collapse:collapse;margin:0 0 12px",
+	"th":         "border:1px solid #d0d7de;padding:6px 12px;background:#f6f8fa;text-align:left",
+	"td":         "border:1px solid #d0d7de;padding:6px 12px",
+	"a":          "color:#0969da",
+	"hr":         "border:0;border-top:1px solid #d0d7de;margin:16px 0",
+	"img":        "max-width:100%",
+}
+
+// htmlOpenTagPattern matches opening tags of the styled elements, capturing the name and attributes
+var htmlOpenTagPattern = regexp.MustCompile(`<(h1|h2|h3|h4|p|blockquote|pre|code|table|th|td|a|hr|img)(\s[^>]*)?>`)
+
+// styleMarkdownHTML adds inline CSS to HTML rendered from markdown and wraps it in a styled container
+func styleMarkdownHTML(s string) string {
+	s = htmlOpenTagPattern.ReplaceAllStringFunc(s, func(tag string) string {
+		match := htmlOpenTagPattern.FindStringSubmatch(tag)
+		return fmt.Sprintf(`<%s style="%s"%s>`, match[1], markdownStyles[match[1]], match[2])
+	})
+	return `<div style="font-family:-apple-system,'Segoe UI',Helvetica,Arial,sans-serif;font-size:14px;line-height:1.5;color:#1f2328">` +
+		"\n" + s + "</div>\n"
+}
@@ -0,0 +1,39 @@
+package shared
+
+import "testing"
+
+func TestHTMLToText(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{name: "plain text", html: "Hello", want: "Hello\n"},
+		{name: "paragraphs", html: "<p>One</p><p>Two</p>", want: "One\n\nTwo\n"},
+		{name: "source line breaks are spaces", html: "<p>One\n  two</p>", want: "One two\n"},
+		{name: "line breaks", html: "One<br>Two<br/>Three<BR />Four", want: "One\nTwo\nThree\nFour\n"},
+		{name: "divs", html: "<div>One</div><div>Two</div>", want: "One\nTwo\n"},
+		{name: "headings", html: "<h1>Title</h1><p>Text</p>", want: "Title\n\nText\n"},
+		{name: "list", html: "<ul><li>One</li><li>Two</li></ul>", want: "- One\n- Two\n"},
+		{name: "table", html: "<table><tr><td>A</td><td>B</td></tr><tr><td>C</td><td>D</td></tr></table>", want: "A\tB\nC\tD\n"},
+		{name: "link keeps its target", html: `<a href="https://example.com/page">the page</a>`, want: "the page (https://example.com/page)\n"},
+		{name: "link showing its target", html: `<a href="https://example.com">https://example.com</a>`, want: "https://example.com\n"},
+		{name: "mailto link showing the address", html: `<a href='mailto:me@example.com'>me@example.com</a>`, want: "me@example.com\n"},
+		{name: "link target with entities", html: `<a href="https://example.com/?a=1&amp;b=2">query</a>`, want: "query (https://example.com/?a=1&b=2)\n"},
+		{name: "image link without text", html: `<a href="https://example.com"><img src="logo.png"></a>`, want: "\n"},
+		{name: "entities", html: "Fish &amp; chips &lt;3 &nbsp;caf&eacute;", want: "Fish & chips <3  café\n"},
+		{name: "style, script, head and comments", html: "<html><head><title>T</title><style>p{color:red}</style></head><body><!-- hidden --><script>alert(1)</script>Shown</body></html>", want: "Shown\n"},
+		{name: "preformatted text keeps line breaks", html: "<p>Code:</p><pre>a := 1\nb := 2\n</pre>", want: "Code:\n\na := 1\nb := 2\n"},
+		{name: "blank lines collapse", html: "<p>One</p><br><br><br><p>Two</p>", want: "One\n\nTwo\n"},
+		{name: "horizontal rule", html: "One<hr>Two", want: "One\n\nTwo\n"},
+		{name: "blockquote", html: "Wrote:<blockquote>Quoted</blockquote>", want: "Wrote:\n\nQuoted\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := htmlToText(tt.html); got != tt.want {
+				t.Errorf("htmlToText(%q) = %q, want %q", tt.html, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,348 @@
+package shared
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+// The go-imap client can neither fetch nor search Gmail's conversation IDs
+// (X-GM-THRID) and has no way to send raw commands, so they are looked up
+// over a separate, minimal IMAP connection that only speaks the few commands
+// needed: LOGIN or AUTHENTICATE, EXAMINE, UID FETCH, UID SEARCH and LOGOUT.
+// It is short-lived and opened in addition to the pooled sessions.
+
+// rawCommandTimeout bounds dialing and every command round trip of a raw connection
+const rawCommandTimeout = 30 * time.Second
+
+// rawMaxLiteral caps the size of a literal accepted in a server response
+const rawMaxLiteral = 1 << 20
+
+var (
+	// rawLiteralSuffix matches a line that announces a literal, e.g. "{42}"
+	rawLiteralSuffix = regexp.MustCompile(`\{(\d+)\}$`)
+	// gmailThreadIDPattern finds the X-GM-THRID item of a FETCH response
+	gmailThreadIDPattern = regexp.MustCompile(`(?i)X-GM-THRID (\d+)`)
+	// mailboxBase64 is the modified base64 of IMAP mailbox names (RFC 3501 5.1.3)
+	mailboxBase64 = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+,").WithPadding(base64.NoPadding)
+)
+
+// rawIMAPConn is an authenticated IMAP connection driven by raw command lines
+type rawIMAPConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	tag  int
+}
+
+// imapString is a command argument sent as a quoted string, or as a literal
+// when it contains characters a quoted string cannot carry
+type imapString string
+
+// dialRaw opens a raw IMAP connection with the account's security mode and credentials
+func (c *IMAPClient) dialRaw() (*rawIMAPConn, error) {
+	server := c.config.IMAP.Server
+	addr := net.JoinHostPort(server, strconv.Itoa(c.config.IMAP.Port))
+	tlsConfig, err := c.config.TLSConfig(server)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: rawCommandTimeout}
+	var conn net.Conn
+	if c.config.IMAP.Security == IMAPSecurityTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to IMAP server: %w", err)
+	}
+
+	r := &rawIMAPConn{conn: conn, r: bufio.NewReader(conn)}
+	if err := r.open(c, tlsConfig); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// open reads the greeting, upgrades the connection if STARTTLS is configured and logs in
+func (r *rawIMAPConn) open(c *IMAPClient, tlsConfig *tls.Config) error {
+	r.conn.SetDeadline(time.Now().Add(rawCommandTimeout))
+	greeting, err := r.readLine()
+	if err != nil {
+		return fmt.Errorf("failed to read IMAP greeting: %w", err)
+	}
+	if strings.HasPrefix(strings.ToUpper(greeting), "* PREAUTH") {
+		return nil
+	}
+	if !strings.HasPrefix(strings.ToUpper(greeting), "* OK") {
+		return fmt.Errorf("unexpected IMAP greeting %q", greeting)
+	}
+
+	if c.config.IMAP.Security == IMAPSecurityStartTLS {
+		if _, err := r.command("STARTTLS"); err != nil {
+			return err
+		}
+		tlsConn := tls.Client(r.conn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			return fmt.Errorf("failed to start TLS: %w", err)
+		}
+		r.conn, r.r = tlsConn, bufio.NewReader(tlsConn)
+	}
+
+	switch c.config.IMAP.AuthMechanism {
+	case AuthXOAuth2, AuthOAuthBearer:
+		saslClient, err := oauth2SASLClient(c.config, c.config.IMAP.AuthMechanism, c.config.IMAP.Username, c.config.IMAP.Server, c.config.IMAP.Port)
+		if err != nil {
+			return err
+		}
+		mechanism, initial, err := saslClient.Start()
+		if err != nil {
+			return err
+		}
+		if _, err := r.command("AUTHENTICATE", mechanism, base64.StdEncoding.EncodeToString(initial)); err != nil {
+			getTokenSource(c.config).invalidate()
+			return fmt.Errorf("failed to authenticate: %w", err)
+		}
+	default:
+		if _, err := r.command("LOGIN", imapString(c.config.IMAP.Username), imapString(c.config.IMAP.Password)); err != nil {
+			return fmt.Errorf("failed to login: %w", err)
+		}
+	}
+	return nil
+}
+
+// close logs out and closes the connection
+func (r *rawIMAPConn) close() {
+	r.command("LOGOUT")
+	r.conn.Close()
+}
+
+// command sends a command built from args and returns its untagged responses.
+// imapString arguments are quoted or sent as literals; others are written as is.
+// Server challenges (e.g. AUTHENTICATE error details) are answered with an empty line.
+func (r *rawIMAPConn) command(args ...any) ([]string, error) {
+	r.tag++
+	tag := "G" + strconv.Itoa(r.tag)
+	r.conn.SetDeadline(time.Now().Add(rawCommandTimeout))
+
+	line := tag
+	for _, arg := range args {
+		s, ok := arg.(imapString)
+		if !ok {
+			line += " " + fmt.Sprint(arg)
+			continue
+		}
+		if quoted, ok := quoteIMAPString(string(s)); ok {
+			line += " " + quoted
+			continue
+		}
+		// Send the line so far, and the string once the server is ready for it
+		if _, err := fmt.Fprintf(r.conn, "%s {%d}\r\n", line, len(s)); err != nil {
+			return nil, err
+		}
+		if err := r.waitContinuation(tag); err != nil {
+			return nil, err
+		}
+		line = string(s)
+	}
+	if _, err := io.WriteString(r.conn, line+"\r\n"); err != nil {
+		return nil, err
+	}
+
+	var untagged []string
+	for {
+		response, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case strings.HasPrefix(response, "+"):
+			if _, err := io.WriteString(r.conn, "\r\n"); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(response, tag+" "):
+			return untagged, taggedStatus(args[0], strings.TrimPrefix(response, tag+" "))
+		default:
+			untagged = append(untagged, response)
+		}
+	}
+}
+
+// waitContinuation reads responses until the server asks for the rest of a command
+func (r *rawIMAPConn) waitContinuation(tag string) error {
+	for {
+		response, err := r.readLine()
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(response, "+") {
+			return nil
+		}
+		if strings.HasPrefix(response, tag+" ") {
+			return fmt.Errorf("command rejected: %s", strings.TrimPrefix(response, tag+" "))
+		}
+	}
+}
+
+// taggedStatus returns nil for an OK completion and an error naming the command otherwise
+func taggedStatus(command any, status string) error {
+	if code, _, _ := strings.Cut(status, " "); strings.EqualFold(code, "OK") {
+		return nil
+	}
+	return fmt.Errorf("%v failed: %s", command, status)
+}
+
+// readLine reads one response line, including any literals it carries
+func (r *rawIMAPConn) readLine() (string, error) {
+	var line strings.Builder
+	for {
+		part, err := r.r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		part = strings.TrimRight(part, "\r\n")
+		line.WriteString(part)
+
+		m := rawLiteralSuffix.FindStringSubmatch(part)
+		if m == nil {
+			return line.String(), nil
+		}
+		size, err := strconv.Atoi(m[1])
+		if err != nil || size > rawMaxLiteral {
+			return "", fmt.Errorf("literal of %s bytes is too large", m[1])
+		}
+		literal := make([]byte, size)
+		if _, err := io.ReadFull(r.r, literal); err != nil {
+			return "", err
+		}
+		line.Write(literal)
+	}
+}
+
+// quoteIMAPString returns s as an IMAP quoted string, or false if it holds
+// characters that only a literal can carry
+func quoteIMAPString(s string) (string, bool) {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] > 0x7e {
+			return "", false
+		}
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`, true
+}
+
+// encodeMailboxName encodes a mailbox name in IMAP's modified UTF-7 (RFC 3501 5.1.3)
+func encodeMailboxName(name string) string {
+	var b strings.Builder
+	var pending []rune
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		units := utf16.Encode(pending)
+		data := make([]byte, 0, 2*len(units))
+		for _, unit := range units {
+			data = append(data, byte(unit>>8), byte(unit))
+		}
+		b.WriteString("&" + mailboxBase64.EncodeToString(data) + "-")
+		pending = nil
+	}
+
+	for _, r := range name {
+		if r < 0x20 || r > 0x7e {
+			pending = append(pending, r)
+			continue
+		}
+		flush()
+		if r == '&' {
+			b.WriteString("&-")
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	flush()
+	return b.String()
+}
+
+// examine opens folder read-only
+func (r *rawIMAPConn) examine(folder string) error {
+	_, err := r.command("EXAMINE", imapString(encodeMailboxName(folder)))
+	return err
+}
+
+// gmailThreadID returns the X-GM-THRID of the message with uid in the examined folder
+func (r *rawIMAPConn) gmailThreadID(uid imap.UID) (string, error) {
+	responses, err := r.command("UID FETCH", uid, "(X-GM-THRID)")
+	if err != nil {
+		return "", err
+	}
+	for _, response := range responses {
+		if m := gmailThreadIDPattern.FindStringSubmatch(response); m != nil {
+			return m[1], nil
+		}
+	}
+	return "", fmt.Errorf("email not found")
+}
+
+// searchGmailThread returns the UIDs of the messages of a Gmail conversation in the examined folder
+func (r *rawIMAPConn) searchGmailThread(threadID string) ([]imap.UID, error) {
+	responses, err := r.command("UID SEARCH X-GM-THRID", threadID)
+	if err != nil {
+		return nil, err
+	}
+	var uids []imap.UID
+	for _, response := range responses {
+		fields := strings.Fields(response)
+		if len(fields) < 2 || !strings.EqualFold(fields[1], "SEARCH") {
+			continue
+		}
+		for _, field := range fields[2:] {
+			if uid, err := strconv.ParseUint(field, 10, 32); err == nil {
+				uids = append(uids, imap.UID(uid))
+			}
+		}
+	}
+	return uids, nil
+}
+
+// gmailThreadUIDs looks up the Gmail conversation of the message with uid in
+// folder and returns its X-GM-THRID and the UIDs of its messages in each of folders
+func (c *IMAPClient) gmailThreadUIDs(folder string, uid imap.UID, folders []string) (string, map[string][]imap.UID, error) {
+	conn, err := c.dialRaw()
+	if err != nil {
+		return "", nil, err
+	}
+	defer conn.close()
+
+	if err := conn.examine(folder); err != nil {
+		return "", nil, err
+	}
+	threadID, err := conn.gmailThreadID(uid)
+	if err != nil {
+		return "", nil, err
+	}
+
+	folderUIDs := make(map[string][]imap.UID)
+	for _, f := range folders {
+		if err := conn.examine(f); err != nil {
+			return "", nil, err
+		}
+		uids, err := conn.searchGmailThread(threadID)
+		if err != nil {
+			return "", nil, err
+		}
+		folderUIDs[f] = uids
+	}
+	return threadID, folderUIDs, nil
+}
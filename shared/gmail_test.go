@@ -0,0 +1,230 @@
+package shared
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+// fakeGmailServer answers the raw commands of a Gmail thread lookup for one
+// connection and records the commands it received, literals inlined
+type fakeGmailServer struct {
+	listener net.Listener
+	threadID string
+	uids     map[string]string // SEARCH results by encoded mailbox name
+
+	mu       sync.Mutex
+	commands []string
+}
+
+var fakeLiteralPattern = regexp.MustCompile(`\{(\d+)\}$`)
+
+func newFakeGmailServer(t *testing.T, threadID string, uids map[string]string) *fakeGmailServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeGmailServer{listener: listener, threadID: threadID, uids: uids}
+	t.Cleanup(func() { listener.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *fakeGmailServer) serve() {
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	fmt.Fprint(conn, "* OK [CAPABILITY IMAP4rev1 X-GM-EXT-1] ready\r\n")
+	selected := ""
+	for {
+		line, err := s.readCommand(conn, r)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.commands = append(s.commands, line)
+		s.mu.Unlock()
+
+		tag, command, _ := strings.Cut(line, " ")
+		switch {
+		case strings.HasPrefix(command, "LOGIN "):
+			fmt.Fprintf(conn, "%s OK logged in\r\n", tag)
+		case strings.HasPrefix(command, "EXAMINE "):
+			selected = strings.Trim(strings.TrimPrefix(command, "EXAMINE "), `"`)
+			fmt.Fprintf(conn, "* 3 EXISTS\r\n%s OK [READ-ONLY] examined\r\n", tag)
+		case strings.HasPrefix(command, "UID FETCH "):
+			fmt.Fprintf(conn, "* 1 FETCH (X-GM-THRID %s UID 42)\r\n%s OK fetched\r\n", s.threadID, tag)
+		case command == "UID SEARCH X-GM-THRID "+s.threadID:
+			fmt.Fprintf(conn, "* SEARCH %s\r\n%s OK searched\r\n", s.uids[selected], tag)
+		case command == "LOGOUT":
+			fmt.Fprintf(conn, "* BYE bye\r\n%s OK logged out\r\n", tag)
+			return
+		default:
+			fmt.Fprintf(conn, "%s BAD unexpected command\r\n", tag)
+		}
+	}
+}
+
+// readCommand reads a command line, asking for and inlining any literals
+func (s *fakeGmailServer) readCommand(conn net.Conn, r *bufio.Reader) (string, error) {
+	var line strings.Builder
+	for {
+		part, err := r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		part = strings.TrimRight(part, "\r\n")
+		m := fakeLiteralPattern.FindStringSubmatch(part)
+		if m == nil {
+			line.WriteString(part)
+			return line.String(), nil
+		}
+		size, _ := strconv.Atoi(m[1])
+		line.WriteString(strings.TrimSuffix(part, m[0]))
+		fmt.Fprint(conn, "+ ready\r\n")
+		literal := make([]byte, size)
+		if _, err := io.ReadFull(r, literal); err != nil {
+			return "", err
+		}
+		line.WriteString("<" + string(literal) + ">")
+	}
+}
+
+func TestGmailThreadUIDs(t *testing.T) {
+	server := newFakeGmailServer(t, "1278455344230334865", map[string]string{
+		"INBOX":                    "40 42",
+		"[Gmail]/Alle Nachrichten": "7 8 9",
+		"[Gmail]/Entw&APw-rfe":     "",
+	})
+
+	config := &Config{}
+	config.IMAP.Server = "127.0.0.1"
+	config.IMAP.Port = server.listener.Addr().(*net.TCPAddr).Port
+	config.IMAP.Security = IMAPSecurityNone
+	config.IMAP.AuthMechanism = IMAPAuthLogin
+	config.IMAP.Username = `me"x`
+	config.IMAP.Password = "pässword"
+	client := &IMAPClient{config: config}
+
+	folders := []string{"INBOX", "[Gmail]/Alle Nachrichten", "[Gmail]/Entwürfe"}
+	threadID, folderUIDs, err := client.gmailThreadUIDs("INBOX", 42, folders)
+	if err != nil {
+		t.Fatalf("gmailThreadUIDs() error: %v", err)
+	}
+	if threadID != "1278455344230334865" {
+		t.Errorf("thread ID = %q, want 1278455344230334865", threadID)
+	}
+	want := map[string][]imap.UID{
+		"INBOX":                    {40, 42},
+		"[Gmail]/Alle Nachrichten": {7, 8, 9},
+		"[Gmail]/Entwürfe":         nil,
+	}
+	if !reflect.DeepEqual(folderUIDs, want) {
+		t.Errorf("UIDs = %v, want %v", folderUIDs, want)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	wantCommands := []string{
+		`G1 LOGIN "me\"x" <pässword>`,
+		`G2 EXAMINE "INBOX"`,
+		`G3 UID FETCH 42 (X-GM-THRID)`,
+		`G4 EXAMINE "INBOX"`,
+		`G5 UID SEARCH X-GM-THRID 1278455344230334865`,
+		`G6 EXAMINE "[Gmail]/Alle Nachrichten"`,
+		`G7 UID SEARCH X-GM-THRID 1278455344230334865`,
+		`G8 EXAMINE "[Gmail]/Entw&APw-rfe"`,
+		`G9 UID SEARCH X-GM-THRID 1278455344230334865`,
+		`G10 LOGOUT`,
+	}
+	if !reflect.DeepEqual(server.commands, wantCommands) {
+		t.Errorf("commands =\n%s\nwant\n%s", strings.Join(server.commands, "\n"), strings.Join(wantCommands, "\n"))
+	}
+}
+
+func TestGmailThreadUIDsLoginRejected(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fmt.Fprint(conn, "* OK ready\r\n")
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		tag, _, _ := strings.Cut(line, " ")
+		fmt.Fprintf(conn, "%s NO [AUTHENTICATIONFAILED] invalid credentials\r\n", tag)
+	}()
+
+	config := &Config{}
+	config.IMAP.Server = "127.0.0.1"
+	config.IMAP.Port = listener.Addr().(*net.TCPAddr).Port
+	config.IMAP.Security = IMAPSecurityNone
+	config.IMAP.Username = "me"
+	config.IMAP.Password = "wrong"
+
+	_, _, err = (&IMAPClient{config: config}).gmailThreadUIDs("INBOX", 1, []string{"INBOX"})
+	if err == nil || !strings.Contains(err.Error(), "invalid credentials") {
+		t.Errorf("gmailThreadUIDs() error = %v, want the login failure", err)
+	}
+}
+
+func TestEncodeMailboxName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"INBOX", "INBOX"},
+		{"[Gmail]/All Mail", "[Gmail]/All Mail"},
+		{"Entwürfe", "Entw&APw-rfe"},
+		{"Tom & Jerry", "Tom &- Jerry"},
+		{"☺!", "&Jjo-!"},
+		{"日本語", "&ZeVnLIqe-"},
+	}
+
+	for _, tt := range tests {
+		if got := encodeMailboxName(tt.name); got != tt.want {
+			t.Errorf("encodeMailboxName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestQuoteIMAPString(t *testing.T) {
+	tests := []struct {
+		s      string
+		want   string
+		wantOK bool
+	}{
+		{"plain", `"plain"`, true},
+		{`a"b\c`, `"a\"b\\c"`, true},
+		{"", `""`, true},
+		{"pässword", "", false},
+		{"line\r\nbreak", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := quoteIMAPString(tt.s)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("quoteIMAPString(%q) = %q, %v, want %q, %v", tt.s, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
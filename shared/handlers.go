@@ -4,22 +4,130 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"os"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/emersion/go-imap/v2"
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
 )
 
-// RegisterTools registers all MCP tools with the server
+// accountArgDescription describes the optional account argument shared by all mailbox tools
+const accountArgDescription = "Account name or email address as returned by list_accounts (default: the primary account)"
+
+// mailAccount bundles the config and clients of one mailbox account
+type mailAccount struct {
+	config *Config
+	imap   *IMAPClient
+	smtp   *SMTPClient
+}
+
+// folderArgDescription describes the optional folder argument shared by IMAP tools
+const folderArgDescription = "Folder (mailbox) name as returned by list_folders (default: INBOX). Email IDs already carry their folder, so this is only needed for bare numeric IDs."
+
+var (
+	registeredConfigsMu sync.Mutex
+	// registeredConfigs is the config the tools of each server were last registered for
+	registeredConfigs = make(map[*server.MCPServer]*Config)
+)
+
+// RegisterTools registers all MCP tools with the server, replacing the tools of a previous config
 func RegisterTools(s *server.MCPServer, config *Config) {
-	imapClient := NewIMAPClient(config)
-	smtpClient := NewSMTPClient(config)
+	registeredConfigsMu.Lock()
+	defer registeredConfigsMu.Unlock()
+
+	registerTools(s, config)
+}
+
+// refreshTools registers the tools again for the config currently registered on s,
+// so that the descriptions listing the contacts pick up changes. Handlers must not
+// re-register with the config they captured: a reload may have replaced it since.
+func refreshTools(s *server.MCPServer) {
+	registeredConfigsMu.Lock()
+	defer registeredConfigsMu.Unlock()
+
+	if config := registeredConfigs[s]; config != nil {
+		registerTools(s, config)
+	}
+}
+
+// registerTools is RegisterTools with registeredConfigsMu held
+func registerTools(s *server.MCPServer, config *Config) {
+	registeredConfigs[s] = config
+
+	var serverTools []server.ServerTool
+	addTool := func(tool mcp.Tool, handler server.ToolHandlerFunc) {
+		serverTools = append(serverTools, server.ServerTool{Tool: tool, Handler: handler})
+	}
+
+	accounts := make(map[string]*mailAccount)
+	for _, accountConfig := range config.AccountConfigs() {
+		accounts[accountConfig.AccountName()] = &mailAccount{
+			config: accountConfig,
+			imap:   NewIMAPClient(accountConfig),
+			smtp:   NewSMTPClient(accountConfig),
+		}
+	}
+
+	// addAccountTool registers a tool that acts on one account, selected by its account argument
+	addAccountTool := func(tool mcp.Tool, handler func(ctx context.Context, request mcp.CallToolRequest, account *mailAccount) (*mcp.CallToolResult, error)) {
+		mcp.WithString("account",
+			mcp.Description(accountArgDescription))(&tool)
+
+		addTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			accountConfig, err := config.Account(request.GetString("account", ""))
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			return handler(ctx, request, accounts[accountConfig.AccountName()])
+		})
+	}
 
 	// Build contacts description for tool help
 	contactsInfo := config.GetContactsDescription()
 	senderInfo := fmt.Sprintf("Your email address: %s", config.MyEmail)
+	if len(accounts) > 1 {
+		senderInfo = "Your accounts (pick one with the 'account' argument):"
+		for _, info := range config.ListAccounts() {
+			senderInfo += fmt.Sprintf("\n  - %s: %s", info.Name, info.Email)
+			if info.Primary {
+				senderInfo += " (default)"
+			}
+		}
+	}
+	for _, accountConfig := range config.AccountConfigs() {
+		identitiesInfo := accountConfig.GetIdentitiesDescription()
+		if identitiesInfo == "" {
+			continue
+		}
+		if len(accounts) > 1 {
+			identitiesInfo = fmt.Sprintf("Account %s: %s", accountConfig.AccountName(), identitiesInfo)
+		}
+		senderInfo += "\n\n" + strings.TrimRight(identitiesInfo, "\n")
+	}
+
+	// Register list_accounts tool
+	listAccountsTool := mcp.NewTool("list_accounts",
+		mcp.WithDescription("List the configured mailbox accounts. Every other tool takes an optional 'account' argument; without it the primary account is used."),
+	)
+
+	addTool(listAccountsTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		infos := config.ListAccounts()
+		result, err := json.MarshalIndent(map[string]interface{}{
+			"accounts": infos,
+			"count":    len(infos),
+		}, "", "  ")
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to serialize response: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(result)), nil
+	})
 
 	// Register send_email tool
 	sendEmailTool := mcp.NewTool("send_email",
@@ -29,10 +137,13 @@ func RegisterTools(s *server.MCPServer, config *Config) {
 
 %s
 
-You can use contact names instead of email addresses for the 'to', 'cc', and 'bcc' fields.`, senderInfo, contactsInfo)),
-		mcp.WithString("to",
+You can use contact names instead of email addresses for the 'to', 'cc', and 'bcc' fields. Each of them takes a list of recipients; a single comma-separated string is accepted as well.
+
+Files can be attached either as base64 content with a filename, or by path inside the configured attachments directory.`, senderInfo, contactsInfo)),
+		mcp.WithArray("to",
 			mcp.Required(),
-			mcp.Description("Recipient email address or contact name")),
+			mcp.Description("Recipients: email addresses, \"Name <address>\" or contact names"),
+			mcp.Items(map[string]any{"type": "string"})),
 		mcp.WithString("subject",
 			mcp.Required(),
 			mcp.Description("Email subject")),
@@ -40,48 +151,228 @@ You can use contact names instead of email addresses for the 'to', 'cc', and 'bc
 			mcp.Required(),
 			mcp.Description("Email body content")),
 		mcp.WithString("body_format",
-			mcp.Description("Body format: 'text' (default), 'markdown', or 'html'")),
-		mcp.WithString("cc",
-			mcp.Description("CC recipient email address or contact name")),
-		mcp.WithString("bcc",
-			mcp.Description("BCC recipient email address or contact name")),
+			mcp.Description("Body format: 'text', 'markdown', or 'html' (default: the identity's body format, otherwise 'text')")),
+		mcp.WithString("from",
+			mcp.Description("Sender identity address to send as (default: your main address). Its signature is appended automatically")),
+		mcp.WithArray("cc",
+			mcp.Description("CC recipients: email addresses, \"Name <address>\" or contact names"),
+			mcp.Items(map[string]any{"type": "string"})),
+		mcp.WithArray("bcc",
+			mcp.Description("BCC recipients: email addresses, \"Name <address>\" or contact names"),
+			mcp.Items(map[string]any{"type": "string"})),
+		mcp.WithArray("attachments",
+			mcp.Description("Files to attach. Each item needs either 'content_base64' with 'filename', or 'path' relative to the attachments directory; 'content_type' is guessed from the filename when omitted"),
+			mcp.Items(map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"filename":       map[string]any{"type": "string", "description": "File name shown to the recipient"},
+					"content_type":   map[string]any{"type": "string", "description": "MIME type, e.g. text/csv or application/pdf"},
+					"content_base64": map[string]any{"type": "string", "description": "Base64-encoded file content"},
+					"path":           map[string]any{"type": "string", "description": "Path of a file inside the attachments directory"},
+				},
+			})),
 	)
 
-	s.AddTool(sendEmailTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		to := request.GetString("to", "")
+	addAccountTool(sendEmailTool, func(ctx context.Context, request mcp.CallToolRequest, account *mailAccount) (*mcp.CallToolResult, error) {
+		to := getRecipientsArg(request, "to")
 		subject := request.GetString("subject", "")
 		body := request.GetString("body", "")
-		bodyFormat := request.GetString("body_format", "text")
-		cc := request.GetString("cc", "")
-		bcc := request.GetString("bcc", "")
 
-		if to == "" || subject == "" || body == "" {
+		if len(to) == 0 || subject == "" || body == "" {
 			return mcp.NewToolResultError("Missing required parameters: to, subject, and body are required"), nil
 		}
 
-		err := smtpClient.SendEmail(to, subject, body, bodyFormat, cc, bcc)
+		specs, err := getAttachmentSpecs(request)
 		if err != nil {
-			return mcp.NewToolResultError(fmt.Sprintf("Failed to send email: %v", err)), nil
+			return mcp.NewToolResultError(fmt.Sprintf("Invalid parameter attachments: %v", err)), nil
+		}
+		attachments, err := LoadAttachments(account.config, specs)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to load attachments: %v", err)), nil
 		}
 
-		resolvedTo := config.ResolveEmail(to)
-		return mcp.NewToolResultText(fmt.Sprintf("Email sent successfully to %s", resolvedTo)), nil
+		identity, err := account.config.Identity(request.GetString("from", ""))
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Invalid parameter from: %v", err)), nil
+		}
+
+		email := &OutgoingEmail{
+			From:            identity.Address,
+			To:              to,
+			Cc:              getRecipientsArg(request, "cc"),
+			Bcc:             getRecipientsArg(request, "bcc"),
+			Subject:         subject,
+			Body:            body,
+			BodyFormat:      request.GetString("body_format", ""),
+			Attachments:     attachments,
+			AppendSignature: true,
+		}
+		sendErr := account.smtp.Send(email)
+		if sendErr != nil && !isPartialDelivery(sendErr) {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to send email: %v", sendErr)), nil
+		}
+
+		text := fmt.Sprintf("Email sent successfully to %s", recipientsText(account.config, to))
+		if request.GetString("from", "") != "" {
+			text += " as " + identity.Address
+		}
+		if len(attachments) > 0 {
+			text += fmt.Sprintf(" with %d attachment(s)", len(attachments))
+		}
+		return mcp.NewToolResultText(withDeliveryWarning(text, sendErr)), nil
+	})
+
+	// Register list_folders tool
+	listFoldersTool := mcp.NewTool("list_folders",
+		mcp.WithDescription("List all mailbox folders on the IMAP server with their attributes (including special-use such as \\Sent, \\Archive, \\Junk, \\Trash), total message count and unread count."),
+	)
+
+	addAccountTool(listFoldersTool, func(ctx context.Context, request mcp.CallToolRequest, account *mailAccount) (*mcp.CallToolResult, error) {
+		folders, err := account.imap.ListFolders()
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to list folders: %v", err)), nil
+		}
+
+		result, err := json.MarshalIndent(map[string]interface{}{
+			"folders": folders,
+			"count":   len(folders),
+		}, "", "  ")
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to serialize response: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(result)), nil
+	})
+
+	// Register reply_email and reply_all_email tools
+	for _, replyAll := range []bool{false, true} {
+		name, description := "reply_email", "Reply to the sender of an email. The reply keeps the conversation thread (In-Reply-To/References headers) and the original email is flagged as answered. The signature of the identity the reply is sent from follows the reply text, above the quote."
+		if replyAll {
+			name, description = "reply_all_email", "Reply to the sender and all other recipients of an email (excluding your own address). The reply keeps the conversation thread (In-Reply-To/References headers) and the original email is flagged as answered. The signature of the identity the reply is sent from follows the reply text, above the quote."
+		}
+
+		replyTool := mcp.NewTool(name,
+			mcp.WithDescription(description),
+			mcp.WithString("email_id",
+				mcp.Required(),
+				mcp.Description("ID of the email to reply to")),
+			mcp.WithString("body",
+				mcp.Required(),
+				mcp.Description("Reply text")),
+			mcp.WithString("body_format",
+				mcp.Description("Body format: 'text', 'markdown', or 'html' (default: the identity's body format, otherwise 'text')")),
+			mcp.WithBoolean("quote",
+				mcp.Description("Quote the original email below the reply (default: true)")),
+			mcp.WithString("folder",
+				mcp.Description(folderArgDescription)),
+		)
+
+		addAccountTool(replyTool, func(ctx context.Context, request mcp.CallToolRequest, account *mailAccount) (*mcp.CallToolResult, error) {
+			emailID := request.GetString("email_id", "")
+			body := request.GetString("body", "")
+			if emailID == "" || body == "" {
+				return mcp.NewToolResultError("Missing required parameters: email_id and body are required"), nil
+			}
+
+			original, err := account.imap.GetEmailContents(emailID, request.GetString("folder", ""), false)
+			if err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("Failed to get original email: %v", err)), nil
+			}
+
+			reply := BuildReply(account.config, original, body, request.GetString("body_format", ""), replyAll, request.GetBool("quote", true))
+			sendErr := account.smtp.Send(reply)
+			if sendErr != nil && !isPartialDelivery(sendErr) {
+				return mcp.NewToolResultError(fmt.Sprintf("Failed to send reply: %v", sendErr)), nil
+			}
+
+			if _, err := account.imap.SetFlags([]string{original.ID}, "", []string{string(imap.FlagAnswered)}, nil); err != nil {
+				log.Printf("Failed to flag email %s as answered: %v", original.ID, err)
+			}
+
+			recipients := append(append([]string{}, reply.To...), reply.Cc...)
+			text := fmt.Sprintf("Reply sent successfully to %s", strings.Join(recipients, ", "))
+			return mcp.NewToolResultText(withDeliveryWarning(text, sendErr)), nil
+		})
+	}
+
+	// Register forward_email tool
+	forwardEmailTool := mcp.NewTool("forward_email",
+		mcp.WithDescription(fmt.Sprintf(`Forward an email, including its attachments, to new recipients. Your default signature follows the note in body, above the forwarded email.
+
+%s
+
+You can use contact names instead of email addresses for the 'to' field.`, contactsInfo)),
+		mcp.WithString("email_id",
+			mcp.Required(),
+			mcp.Description("ID of the email to forward")),
+		mcp.WithArray("to",
+			mcp.Required(),
+			mcp.Description("Recipients: email addresses, \"Name <address>\" or contact names"),
+			mcp.Items(map[string]any{"type": "string"})),
+		mcp.WithString("body",
+			mcp.Description("Text to add above the forwarded email")),
+		mcp.WithString("body_format",
+			mcp.Description("Body format: 'text', 'markdown', or 'html' (default: the identity's body format, otherwise 'text')")),
+		mcp.WithBoolean("include_attachments",
+			mcp.Description("Re-attach the original attachments (default: true)")),
+		mcp.WithString("folder",
+			mcp.Description(folderArgDescription)),
+	)
+
+	addAccountTool(forwardEmailTool, func(ctx context.Context, request mcp.CallToolRequest, account *mailAccount) (*mcp.CallToolResult, error) {
+		emailID := request.GetString("email_id", "")
+		to := getRecipientsArg(request, "to")
+		if emailID == "" || len(to) == 0 {
+			return mcp.NewToolResultError("Missing required parameters: email_id and to are required"), nil
+		}
+
+		original, err := account.imap.GetEmailContents(emailID, request.GetString("folder", ""), false)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to get original email: %v", err)), nil
+		}
+
+		forward := BuildForward(account.config, original, to, request.GetString("body", ""), request.GetString("body_format", ""))
+
+		if request.GetBool("include_attachments", true) {
+			for _, info := range original.Attachments {
+				filename, contentType, data, err := account.imap.GetAttachment(original.ID, "", info.Index)
+				if err != nil {
+					return mcp.NewToolResultError(fmt.Sprintf("Failed to get attachment %s: %v", info.Filename, err)), nil
+				}
+				forward.Attachments = append(forward.Attachments, OutgoingAttachment{
+					Filename:    filename,
+					ContentType: contentType,
+					Data:        data,
+				})
+			}
+		}
+
+		sendErr := account.smtp.Send(forward)
+		if sendErr != nil && !isPartialDelivery(sendErr) {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to forward email: %v", sendErr)), nil
+		}
+
+		text := fmt.Sprintf("Email forwarded successfully to %s with %d attachment(s)", recipientsText(account.config, to), len(forward.Attachments))
+		return mcp.NewToolResultText(withDeliveryWarning(text, sendErr)), nil
 	})
 
 	// Register get_inbox tool
 	getInboxTool := mcp.NewTool("get_inbox",
-		mcp.WithDescription("Retrieve emails from the inbox via IMAP."),
+		mcp.WithDescription("Retrieve emails from the inbox (or another folder) via IMAP."),
 		mcp.WithNumber("limit",
 			mcp.Description("Maximum number of emails to return (default: 20)")),
 		mcp.WithBoolean("unread_only",
 			mcp.Description("Only return unread emails")),
+		mcp.WithString("folder",
+			mcp.Description(folderArgDescription)),
 	)
 
-	s.AddTool(getInboxTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	addAccountTool(getInboxTool, func(ctx context.Context, request mcp.CallToolRequest, account *mailAccount) (*mcp.CallToolResult, error) {
 		limit := request.GetInt("limit", 20)
 		unreadOnly := request.GetBool("unread_only", false)
+		folder := request.GetString("folder", "")
 
-		emails, err := imapClient.GetInbox(limit, unreadOnly)
+		emails, err := account.imap.GetInbox(folder, limit, unreadOnly)
 		if err != nil {
 			return mcp.NewToolResultError(fmt.Sprintf("Failed to get inbox: %v", err)), nil
 		}
@@ -97,21 +388,104 @@ You can use contact names instead of email addresses for the 'to', 'cc', and 'bc
 		return mcp.NewToolResultText(string(result)), nil
 	})
 
+	// Register search_emails tool
+	searchEmailsTool := mcp.NewTool("search_emails",
+		mcp.WithDescription("Search emails in a folder on the IMAP server. All given criteria must match. Results are ordered newest first and paged with limit/offset."),
+		mcp.WithString("folder",
+			mcp.Description("Folder (mailbox) to search in, as returned by list_folders (default: INBOX)")),
+		mcp.WithString("from",
+			mcp.Description("Match text in the From header (name or address)")),
+		mcp.WithString("to",
+			mcp.Description("Match text in the To header")),
+		mcp.WithString("cc",
+			mcp.Description("Match text in the Cc header")),
+		mcp.WithString("subject",
+			mcp.Description("Match text in the subject")),
+		mcp.WithString("body",
+			mcp.Description("Match text in the message body")),
+		mcp.WithString("text",
+			mcp.Description("Match text anywhere in the message (headers or body)")),
+		mcp.WithString("since",
+			mcp.Description("Only emails received on or after this date (YYYY-MM-DD)")),
+		mcp.WithString("before",
+			mcp.Description("Only emails received before this date (YYYY-MM-DD)")),
+		mcp.WithBoolean("seen",
+			mcp.Description("true: only read emails, false: only unread emails")),
+		mcp.WithBoolean("flagged",
+			mcp.Description("true: only flagged emails, false: only unflagged emails")),
+		mcp.WithBoolean("answered",
+			mcp.Description("true: only answered emails, false: only unanswered emails")),
+		mcp.WithNumber("larger",
+			mcp.Description("Only emails larger than this size in bytes")),
+		mcp.WithNumber("smaller",
+			mcp.Description("Only emails smaller than this size in bytes")),
+		mcp.WithBoolean("has_attachment",
+			mcp.Description("true: only emails with attachments, false: only emails without")),
+		mcp.WithNumber("limit",
+			mcp.Description("Maximum number of emails to return (default: 20)")),
+		mcp.WithNumber("offset",
+			mcp.Description("Number of matching emails to skip, for paging (default: 0)")),
+	)
+
+	addAccountTool(searchEmailsTool, func(ctx context.Context, request mcp.CallToolRequest, account *mailAccount) (*mcp.CallToolResult, error) {
+		query := &SearchQuery{
+			Folder:        request.GetString("folder", ""),
+			From:          request.GetString("from", ""),
+			To:            request.GetString("to", ""),
+			Cc:            request.GetString("cc", ""),
+			Subject:       request.GetString("subject", ""),
+			Body:          request.GetString("body", ""),
+			Text:          request.GetString("text", ""),
+			Seen:          getOptionalBool(request, "seen"),
+			Flagged:       getOptionalBool(request, "flagged"),
+			Answered:      getOptionalBool(request, "answered"),
+			Larger:        int64(request.GetInt("larger", 0)),
+			Smaller:       int64(request.GetInt("smaller", 0)),
+			HasAttachment: getOptionalBool(request, "has_attachment"),
+			Limit:         request.GetInt("limit", 20),
+			Offset:        request.GetInt("offset", 0),
+		}
+
+		var err error
+		if query.Since, err = parseDateArg(request.GetString("since", "")); err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Invalid parameter since: %v", err)), nil
+		}
+		if query.Before, err = parseDateArg(request.GetString("before", "")); err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Invalid parameter before: %v", err)), nil
+		}
+
+		searchResult, err := account.imap.SearchEmails(query)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to search emails: %v", err)), nil
+		}
+
+		result, err := json.MarshalIndent(searchResult, "", "  ")
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to serialize response: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(result)), nil
+	})
+
 	// Register get_email_contents tool
 	getEmailContentsTool := mcp.NewTool("get_email_contents",
-		mcp.WithDescription("Get the full content of a specific email."),
+		mcp.WithDescription("Get the full content of a specific email. Reading does not mark the email as read unless mark_as_read is set."),
 		mcp.WithString("email_id",
 			mcp.Required(),
 			mcp.Description("ID of the email to retrieve")),
+		mcp.WithString("folder",
+			mcp.Description(folderArgDescription)),
+		mcp.WithBoolean("mark_as_read",
+			mcp.Description("Also mark the email as read on the server (default: false)")),
 	)
 
-	s.AddTool(getEmailContentsTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	addAccountTool(getEmailContentsTool, func(ctx context.Context, request mcp.CallToolRequest, account *mailAccount) (*mcp.CallToolResult, error) {
 		emailID := request.GetString("email_id", "")
 		if emailID == "" {
 			return mcp.NewToolResultError("Missing required parameter: email_id"), nil
 		}
 
-		email, err := imapClient.GetEmailContents(emailID)
+		email, err := account.imap.GetEmailContents(emailID, request.GetString("folder", ""), request.GetBool("mark_as_read", false))
 		if err != nil {
 			return mcp.NewToolResultError(fmt.Sprintf("Failed to get email: %v", err)), nil
 		}
@@ -124,21 +498,52 @@ You can use contact names instead of email addresses for the 'to', 'cc', and 'bc
 		return mcp.NewToolResultText(string(result)), nil
 	})
 
+	// Register get_thread tool
+	getThreadTool := mcp.NewTool("get_thread",
+		mcp.WithDescription("Get the whole conversation an email belongs to, including your replies from the Sent folder, ordered by date. On Gmail, the thread is the set of messages sharing the email's Gmail thread ID (X-GM-THRID) in the folder and All Mail. Elsewhere messages are linked through server-side threading (THREAD=REFERENCES) where available, otherwise through Message-ID/In-Reply-To/References headers and finally the subject."),
+		mcp.WithString("email_id",
+			mcp.Required(),
+			mcp.Description("ID of any email in the conversation")),
+		mcp.WithString("folder",
+			mcp.Description(folderArgDescription)),
+	)
+
+	addAccountTool(getThreadTool, func(ctx context.Context, request mcp.CallToolRequest, account *mailAccount) (*mcp.CallToolResult, error) {
+		emailID := request.GetString("email_id", "")
+		if emailID == "" {
+			return mcp.NewToolResultError("Missing required parameter: email_id"), nil
+		}
+
+		thread, err := account.imap.GetThread(emailID, request.GetString("folder", ""))
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to get thread: %v", err)), nil
+		}
+
+		result, err := json.MarshalIndent(thread, "", "  ")
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to serialize response: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(result)), nil
+	})
+
 	// Register mark_email_read tool
 	markEmailReadTool := mcp.NewTool("mark_email_read",
 		mcp.WithDescription("Mark an email as read on the IMAP server."),
 		mcp.WithString("email_id",
 			mcp.Required(),
 			mcp.Description("ID of the email to mark as read")),
+		mcp.WithString("folder",
+			mcp.Description(folderArgDescription)),
 	)
 
-	s.AddTool(markEmailReadTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	addAccountTool(markEmailReadTool, func(ctx context.Context, request mcp.CallToolRequest, account *mailAccount) (*mcp.CallToolResult, error) {
 		emailID := request.GetString("email_id", "")
 		if emailID == "" {
 			return mcp.NewToolResultError("Missing required parameter: email_id"), nil
 		}
 
-		err := imapClient.MarkAsRead(emailID)
+		err := account.imap.MarkAsRead(emailID, request.GetString("folder", ""))
 		if err != nil {
 			return mcp.NewToolResultError(fmt.Sprintf("Failed to mark email as read: %v", err)), nil
 		}
@@ -146,12 +551,427 @@ You can use contact names instead of email addresses for the 'to', 'cc', and 'bc
 		return mcp.NewToolResultText(fmt.Sprintf("Email %s marked as read", emailID)), nil
 	})
 
+	// Register set_flags tool
+	setFlagsTool := mcp.NewTool("set_flags",
+		mcp.WithDescription("Add or remove flags on one or more emails. Supports the system flags Seen, Flagged, Answered, Draft and arbitrary IMAP keywords such as $AgentProcessed or $NeedsReply, which can be used to coordinate state between agents."),
+		mcp.WithArray("email_ids",
+			mcp.Required(),
+			mcp.Items(map[string]any{"type": "string"}),
+			mcp.Description("IDs of the emails to update")),
+		mcp.WithArray("add",
+			mcp.Items(map[string]any{"type": "string"}),
+			mcp.Description("Flags or keywords to add, e.g. [\"Flagged\", \"$NeedsReply\"]. System flags may be given with or without the leading backslash")),
+		mcp.WithArray("remove",
+			mcp.Items(map[string]any{"type": "string"}),
+			mcp.Description("Flags or keywords to remove, e.g. [\"Seen\"] to mark as unread")),
+		mcp.WithString("folder",
+			mcp.Description(folderArgDescription)),
+	)
+
+	addAccountTool(setFlagsTool, func(ctx context.Context, request mcp.CallToolRequest, account *mailAccount) (*mcp.CallToolResult, error) {
+		emailIDs := request.GetStringSlice("email_ids", nil)
+		add := request.GetStringSlice("add", nil)
+		remove := request.GetStringSlice("remove", nil)
+		if len(emailIDs) == 0 {
+			return mcp.NewToolResultError("Missing required parameter: email_ids"), nil
+		}
+		if len(add) == 0 && len(remove) == 0 {
+			return mcp.NewToolResultError("At least one of add or remove must be given"), nil
+		}
+
+		flags, err := account.imap.SetFlags(emailIDs, request.GetString("folder", ""), add, remove)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to set flags: %v", err)), nil
+		}
+
+		result, err := json.MarshalIndent(map[string]interface{}{
+			"flags": flags,
+			"count": len(flags),
+		}, "", "  ")
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to serialize response: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(result)), nil
+	})
+
+	// Register move_email tool
+	moveEmailTool := mcp.NewTool("move_email",
+		mcp.WithDescription("Move one or more emails to another folder. Uses the IMAP MOVE extension when available."),
+		mcp.WithArray("email_ids",
+			mcp.Required(),
+			mcp.Items(map[string]any{"type": "string"}),
+			mcp.Description("IDs of the emails to move")),
+		mcp.WithString("destination",
+			mcp.Required(),
+			mcp.Description("Destination folder name, as returned by list_folders")),
+		mcp.WithString("folder",
+			mcp.Description(folderArgDescription)),
+	)
+
+	addAccountTool(moveEmailTool, func(ctx context.Context, request mcp.CallToolRequest, account *mailAccount) (*mcp.CallToolResult, error) {
+		emailIDs := request.GetStringSlice("email_ids", nil)
+		destination := request.GetString("destination", "")
+		if len(emailIDs) == 0 || destination == "" {
+			return mcp.NewToolResultError("Missing required parameters: email_ids and destination are required"), nil
+		}
+
+		transferResult, err := account.imap.MoveEmails(emailIDs, request.GetString("folder", ""), destination)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to move emails: %v", err)), nil
+		}
+
+		return transferResultText(transferResult)
+	})
+
+	// Register copy_email tool
+	copyEmailTool := mcp.NewTool("copy_email",
+		mcp.WithDescription("Copy one or more emails to another folder, keeping the originals."),
+		mcp.WithArray("email_ids",
+			mcp.Required(),
+			mcp.Items(map[string]any{"type": "string"}),
+			mcp.Description("IDs of the emails to copy")),
+		mcp.WithString("destination",
+			mcp.Required(),
+			mcp.Description("Destination folder name, as returned by list_folders")),
+		mcp.WithString("folder",
+			mcp.Description(folderArgDescription)),
+	)
+
+	addAccountTool(copyEmailTool, func(ctx context.Context, request mcp.CallToolRequest, account *mailAccount) (*mcp.CallToolResult, error) {
+		emailIDs := request.GetStringSlice("email_ids", nil)
+		destination := request.GetString("destination", "")
+		if len(emailIDs) == 0 || destination == "" {
+			return mcp.NewToolResultError("Missing required parameters: email_ids and destination are required"), nil
+		}
+
+		transferResult, err := account.imap.CopyEmails(emailIDs, request.GetString("folder", ""), destination)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to copy emails: %v", err)), nil
+		}
+
+		return transferResultText(transferResult)
+	})
+
+	// Register archive_email tool
+	archiveEmailTool := mcp.NewTool("archive_email",
+		mcp.WithDescription("Move one or more emails to the Archive folder (found through the \\Archive special-use attribute)."),
+		mcp.WithArray("email_ids",
+			mcp.Required(),
+			mcp.Items(map[string]any{"type": "string"}),
+			mcp.Description("IDs of the emails to archive")),
+		mcp.WithString("folder",
+			mcp.Description(folderArgDescription)),
+	)
+
+	addAccountTool(archiveEmailTool, func(ctx context.Context, request mcp.CallToolRequest, account *mailAccount) (*mcp.CallToolResult, error) {
+		emailIDs := request.GetStringSlice("email_ids", nil)
+		if len(emailIDs) == 0 {
+			return mcp.NewToolResultError("Missing required parameter: email_ids"), nil
+		}
+
+		transferResult, err := account.imap.ArchiveEmails(emailIDs, request.GetString("folder", ""))
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to archive emails: %v", err)), nil
+		}
+
+		return transferResultText(transferResult)
+	})
+
+	// Register delete_email tool
+	deleteEmailTool := mcp.NewTool("delete_email",
+		mcp.WithDescription("Delete one or more emails by moving them to the Trash folder (found through the \\Trash special-use attribute). Emails already in Trash are removed permanently."),
+		mcp.WithArray("email_ids",
+			mcp.Required(),
+			mcp.Items(map[string]any{"type": "string"}),
+			mcp.Description("IDs of the emails to delete")),
+		mcp.WithString("folder",
+			mcp.Description(folderArgDescription)),
+		mcp.WithBoolean("permanent",
+			mcp.Description("Permanently remove the emails instead of moving them to Trash (default: false)")),
+	)
+
+	addAccountTool(deleteEmailTool, func(ctx context.Context, request mcp.CallToolRequest, account *mailAccount) (*mcp.CallToolResult, error) {
+		emailIDs := request.GetStringSlice("email_ids", nil)
+		if len(emailIDs) == 0 {
+			return mcp.NewToolResultError("Missing required parameter: email_ids"), nil
+		}
+
+		transferResult, err := account.imap.DeleteEmails(emailIDs, request.GetString("folder", ""), request.GetBool("permanent", false))
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to delete emails: %v", err)), nil
+		}
+
+		return transferResultText(transferResult)
+	})
+
+	// Register list_contacts tool
+	listContactsTool := mcp.NewTool("list_contacts",
+		mcp.WithDescription("List contacts with their email addresses, nicknames, organization, notes and groups. Contact names, nicknames and group names can be used as recipients in send_email and forward_email."),
+		mcp.WithString("query",
+			mcp.Description("Only list contacts whose name, nicknames, addresses, organization or notes contain this text")),
+		mcp.WithString("group",
+			mcp.Description("Only list members of this group")),
+	)
+
+	addTool(listContactsTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		book := config.ContactBook()
+		contacts := book.List(request.GetString("query", ""), request.GetString("group", ""))
+
+		result, err := json.MarshalIndent(map[string]interface{}{
+			"contacts": contacts,
+			"count":    len(contacts),
+			"groups":   book.Groups(),
+		}, "", "  ")
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to serialize response: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(result)), nil
+	})
+
+	// Register find_contact tool
+	findContactTool := mcp.NewTool("find_contact",
+		mcp.WithDescription("Find the email address of a person by name or part of their address. Searches the contact book first, then the known correspondents: people who wrote to you or whom you wrote to, with how many emails were exchanged and when they were last seen (when correspondent harvesting is enabled). Use it instead of guessing addresses."),
+		mcp.WithString("query",
+			mcp.Required(),
+			mcp.Description("Name, nickname or part of an email address")),
+		mcp.WithNumber("limit",
+			mcp.Description("Maximum number of correspondents to return (default: 10)")),
+	)
+
+	addTool(findContactTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		query := request.GetString("query", "")
+		if strings.TrimSpace(query) == "" {
+			return mcp.NewToolResultError("Missing required parameter: query"), nil
+		}
+		limit := request.GetInt("limit", 10)
+
+		correspondents := []Correspondent{}
+		if store := config.KnownCorrespondents(); store != nil {
+			correspondents = store.Find(query, limit)
+		}
+
+		result, err := json.MarshalIndent(map[string]interface{}{
+			"contacts":           config.ContactBook().Find(query),
+			"correspondents":     correspondents,
+			"harvesting_enabled": config.KnownCorrespondents() != nil,
+		}, "", "  ")
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to serialize response: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(result)), nil
+	})
+
+	// Register add_contact tool
+	addContactTool := mcp.NewTool("add_contact",
+		mcp.WithDescription("Add a contact to the contact book. The first email address is used when the contact is picked as a recipient."),
+		mcp.WithString("name",
+			mcp.Required(),
+			mcp.Description("Full name of the contact")),
+		mcp.WithArray("emails",
+			mcp.Required(),
+			mcp.Description("Email addresses, the preferred one first"),
+			mcp.Items(map[string]any{"type": "string"})),
+		mcp.WithArray("nicknames",
+			mcp.Description("Other names the contact can be addressed by"),
+			mcp.Items(map[string]any{"type": "string"})),
+		mcp.WithString("organization",
+			mcp.Description("Company or organization")),
+		mcp.WithString("notes",
+			mcp.Description("Free-form notes")),
+		mcp.WithArray("groups",
+			mcp.Description("Groups the contact belongs to, e.g. 'team'"),
+			mcp.Items(map[string]any{"type": "string"})),
+	)
+
+	addTool(addContactTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		contact := Contact{
+			Name:         request.GetString("name", ""),
+			Emails:       request.GetStringSlice("emails", nil),
+			Nicknames:    request.GetStringSlice("nicknames", nil),
+			Organization: request.GetString("organization", ""),
+			Notes:        request.GetString("notes", ""),
+			Groups:       request.GetStringSlice("groups", nil),
+		}
+		if err := config.ContactBook().Add(contact); err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to add contact: %v", err)), nil
+		}
+
+		// Rebuild the tool descriptions that list the contacts
+		refreshTools(s)
+
+		return mcp.NewToolResultText(fmt.Sprintf("Contact %s added", contact.Name)), nil
+	})
+
+	// Register update_contact tool
+	updateContactTool := mcp.NewTool("update_contact",
+		mcp.WithDescription("Update a contact. Only the given fields change; list fields (emails, nicknames, groups) are replaced as a whole."),
+		mcp.WithString("name",
+			mcp.Required(),
+			mcp.Description("Current full name of the contact")),
+		mcp.WithString("new_name",
+			mcp.Description("New full name")),
+		mcp.WithArray("emails",
+			mcp.Description("Email addresses, the preferred one first"),
+			mcp.Items(map[string]any{"type": "string"})),
+		mcp.WithArray("nicknames",
+			mcp.Description("Other names the contact can be addressed by"),
+			mcp.Items(map[string]any{"type": "string"})),
+		mcp.WithString("organization",
+			mcp.Description("Company or organization")),
+		mcp.WithString("notes",
+			mcp.Description("Free-form notes")),
+		mcp.WithArray("groups",
+			mcp.Description("Groups the contact belongs to"),
+			mcp.Items(map[string]any{"type": "string"})),
+	)
+
+	addTool(updateContactTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		name := request.GetString("name", "")
+		if name == "" {
+			return mcp.NewToolResultError("Missing required parameter: name"), nil
+		}
+
+		args := request.GetArguments()
+		contact, err := config.ContactBook().Update(name, func(contact *Contact) {
+			if _, ok := args["new_name"]; ok {
+				contact.Name = request.GetString("new_name", "")
+			}
+			if _, ok := args["emails"]; ok {
+				contact.Emails = request.GetStringSlice("emails", nil)
+			}
+			if _, ok := args["nicknames"]; ok {
+				contact.Nicknames = request.GetStringSlice("nicknames", nil)
+			}
+			if _, ok := args["organization"]; ok {
+				contact.Organization = request.GetString("organization", "")
+			}
+			if _, ok := args["notes"]; ok {
+				contact.Notes = request.GetString("notes", "")
+			}
+			if _, ok := args["groups"]; ok {
+				contact.Groups = request.GetStringSlice("groups", nil)
+			}
+		})
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to update contact: %v", err)), nil
+		}
+
+		// Rebuild the tool descriptions that list the contacts
+		refreshTools(s)
+
+		result, err := json.MarshalIndent(contact, "", "  ")
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to serialize response: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(result)), nil
+	})
+
+	// Register import_contacts tool
+	importContactsTool := mcp.NewTool("import_contacts",
+		mcp.WithDescription("Import contacts from a vCard (3.0/4.0) or CSV export (Google Contacts, Outlook, Thunderbird or any CSV with a header row and an email column) into the contact book. Contacts with an email address or name already in the book are reported as duplicates and skipped, unless on_duplicate is 'merge'."),
+		mcp.WithString("path",
+			mcp.Description("Path of the file inside the attachments directory")),
+		mcp.WithString("content",
+			mcp.Description("File content, instead of path")),
+		mcp.WithString("format",
+			mcp.Description("'vcard' or 'csv' (default: detected from the file name or content)")),
+		mcp.WithString("on_duplicate",
+			mcp.Description("'skip' (default) or 'merge' to add new addresses, nicknames and groups to the existing contact")),
+		mcp.WithBoolean("dry_run",
+			mcp.Description("Report what would be imported without changing the contact book (default: false)")),
+	)
+
+	addTool(importContactsTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		path := request.GetString("path", "")
+		content := request.GetString("content", "")
+
+		var data []byte
+		switch {
+		case path != "" && content != "":
+			return mcp.NewToolResultError("Give either path or content, not both"), nil
+		case path != "":
+			resolved, err := sandboxedAttachmentPath(config, path)
+			if err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("Failed to read contacts file: %v", err)), nil
+			}
+			data, err = os.ReadFile(resolved)
+			if err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("Failed to read contacts file: %v", err)), nil
+			}
+		case content != "":
+			data = []byte(content)
+		default:
+			return mcp.NewToolResultError("Either path or content is required"), nil
+		}
+
+		format := request.GetString("format", "")
+		if format == "" {
+			format = ContactFormat(path, data)
+		}
+		onDuplicate := request.GetString("on_duplicate", "skip")
+		if onDuplicate != "skip" && onDuplicate != "merge" {
+			return mcp.NewToolResultError(fmt.Sprintf("Invalid on_duplicate %q: use 'skip' or 'merge'", onDuplicate)), nil
+		}
+		dryRun := request.GetBool("dry_run", false)
+
+		contacts, err := ParseContacts(data, format)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to parse contacts: %v", err)), nil
+		}
+
+		imported, err := config.ContactBook().Import(contacts, onDuplicate == "merge", dryRun)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to import contacts: %v", err)), nil
+		}
+
+		if !dryRun && (len(imported.Imported) > 0 || len(imported.Merged) > 0) {
+			// Rebuild the tool descriptions that list the contacts
+			refreshTools(s)
+		}
+
+		result, err := json.MarshalIndent(map[string]interface{}{
+			"format":     format,
+			"dry_run":    dryRun,
+			"imported":   imported.Imported,
+			"merged":     imported.Merged,
+			"duplicates": imported.Duplicates,
+			"skipped":    imported.Skipped,
+		}, "", "  ")
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to serialize response: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(result)), nil
+	})
+
+	// Register export_contacts tool
+	exportContactsTool := mcp.NewTool("export_contacts",
+		mcp.WithDescription("Export contacts as a vCard 3.0 file, which phones and mail clients can import"),
+		mcp.WithString("query",
+			mcp.Description("Only export contacts whose name, nicknames, addresses, organization or notes contain this text")),
+		mcp.WithString("group",
+			mcp.Description("Only export members of this group")),
+	)
+
+	addTool(exportContactsTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		vcards := config.ContactBook().Export(request.GetString("query", ""), request.GetString("group", ""))
+		if vcards == "" {
+			return mcp.NewToolResultError("No contacts to export"), nil
+		}
+
+		return mcp.NewToolResultText(vcards), nil
+	})
+
 	// Register introduction tool
 	introductionTool := mcp.NewTool("introduction",
 		mcp.WithDescription("Returns information about this MCP server, including its description, supported tools, and notifications."),
 	)
 
-	s.AddTool(introductionTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	addTool(introductionTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		// Query the server for registered tools via HandleMessage
 		listToolsMsg := []byte(`{"jsonrpc":"2.0","id":1,"method":"tools/list","params":{}}`)
 		resp := s.HandleMessage(ctx, listToolsMsg)
@@ -221,7 +1041,7 @@ You can use contact names instead of email addresses for the 'to', 'cc', and 'bc
 			"notifications": []map[string]string{
 				{
 					"name":        "new_email",
-					"description": "Sent when a new email is received. Includes email_id, from, subject, received_at, and a short preview of the email body.",
+					"description": "Sent when a new email is received. Includes account, email_id, from, subject, received_at, and a short preview of the email body.",
 				},
 			},
 		}
@@ -243,9 +1063,11 @@ You can use contact names instead of email addresses for the 'to', 'cc', and 'bc
 		mcp.WithNumber("attachment_index",
 			mcp.Required(),
 			mcp.Description("Index of the attachment (from get_email_contents attachments list)")),
+		mcp.WithString("folder",
+			mcp.Description(folderArgDescription)),
 	)
 
-	s.AddTool(getAttachmentTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	addAccountTool(getAttachmentTool, func(ctx context.Context, request mcp.CallToolRequest, account *mailAccount) (*mcp.CallToolResult, error) {
 		emailID := request.GetString("email_id", "")
 		attachmentIndex := request.GetInt("attachment_index", 0)
 
@@ -256,7 +1078,7 @@ You can use contact names instead of email addresses for the 'to', 'cc', and 'bc
 			return mcp.NewToolResultError("Missing or invalid required parameter: attachment_index (must be >= 1)"), nil
 		}
 
-		filename, contentType, data, err := imapClient.GetAttachment(emailID, attachmentIndex)
+		filename, contentType, data, err := account.imap.GetAttachment(emailID, request.GetString("folder", ""), attachmentIndex)
 		if err != nil {
 			return mcp.NewToolResultError(fmt.Sprintf("Failed to get attachment: %v", err)), nil
 		}
@@ -276,4 +1098,93 @@ You can use contact names instead of email addresses for the 'to', 'cc', and 'bc
 			Blob:     encoded,
 		}), nil
 	})
+
+	// Swap in the whole set at once, so a config reload sends a single list_changed notification
+	s.SetTools(serverTools...)
+}
+
+// transferResultText serializes the result of a move/copy/archive/delete operation
+func transferResultText(transferResult *TransferResult) (*mcp.CallToolResult, error) {
+	result, err := json.MarshalIndent(transferResult, "", "  ")
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to serialize response: %v", err)), nil
+	}
+
+	return mcp.NewToolResultText(string(result)), nil
+}
+
+// getOptionalBool returns a pointer to a boolean argument, or nil if it was not given
+func getOptionalBool(request mcp.CallToolRequest, key string) *bool {
+	if _, ok := request.GetArguments()[key]; !ok {
+		return nil
+	}
+	value := request.GetBool(key, false)
+	return &value
+}
+
+// getRecipientsArg returns a recipients argument given either as an array or as a single string
+func getRecipientsArg(request mcp.CallToolRequest, key string) []string {
+	if value, ok := request.GetArguments()[key].(string); ok {
+		if strings.TrimSpace(value) == "" {
+			return nil
+		}
+		return []string{value}
+	}
+	return request.GetStringSlice(key, nil)
+}
+
+// recipientsText lists the resolved addresses of recipients for tool results
+func recipientsText(config *Config, recipients []string) string {
+	addresses, err := config.ResolveRecipients(recipients)
+	if err != nil {
+		return strings.Join(recipients, ", ")
+	}
+	var list []string
+	for _, addr := range addresses {
+		list = append(list, addr.Address)
+	}
+	return strings.Join(list, ", ")
+}
+
+// isPartialDelivery reports whether a send error means the email went out but some recipients were refused
+func isPartialDelivery(err error) bool {
+	var partial *PartialDeliveryError
+	return errors.As(err, &partial)
+}
+
+// withDeliveryWarning appends the recipients refused by the server to a success message
+func withDeliveryWarning(text string, sendErr error) string {
+	if sendErr == nil {
+		return text
+	}
+	return fmt.Sprintf("%s\nWarning: %v", text, sendErr)
+}
+
+// getAttachmentSpecs decodes the optional attachments argument
+func getAttachmentSpecs(request mcp.CallToolRequest) ([]AttachmentSpec, error) {
+	value, ok := request.GetArguments()["attachments"]
+	if !ok || value == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var specs []AttachmentSpec
+	if err := json.Unmarshal(data, &specs); err != nil {
+		return nil, fmt.Errorf("expected an array of attachment objects: %w", err)
+	}
+	return specs, nil
+}
+
+// parseDateArg parses a YYYY-MM-DD date argument; an empty string yields the zero time
+func parseDateArg(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected date in YYYY-MM-DD format, got %q", value)
+	}
+	return date, nil
 }
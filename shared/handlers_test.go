@@ -0,0 +1,61 @@
+package shared
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// toolDescription returns the description of a tool as listed by the server
+func toolDescription(t *testing.T, s *server.MCPServer, name string) string {
+	t.Helper()
+
+	response := s.HandleMessage(context.Background(), json.RawMessage(`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`))
+	data, err := json.Marshal(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listed struct {
+		Result struct {
+			Tools []struct {
+				Name        string `json:"name"`
+				Description string `json:"description"`
+			} `json:"tools"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(data, &listed); err != nil {
+		t.Fatalf("invalid tools/list response %s: %v", data, err)
+	}
+	for _, tool := range listed.Result.Tools {
+		if tool.Name == name {
+			return tool.Description
+		}
+	}
+	t.Fatalf("tool %s not registered", name)
+	return ""
+}
+
+func TestRefreshToolsUsesReloadedConfig(t *testing.T) {
+	s := server.NewMCPServer("test", "1.0", server.WithToolCapabilities(true))
+
+	previous := &Config{MyEmail: "me@example.com", Contacts: map[string]string{"Old Friend": "old@example.com"}}
+	RegisterTools(s, previous)
+	if description := toolDescription(t, s, "send_email"); !strings.Contains(description, "Old Friend") {
+		t.Fatalf("send_email description does not list the contacts:\n%s", description)
+	}
+
+	// A reload registers the tools for the new config
+	reloaded := &Config{MyEmail: "me@example.com", Contacts: map[string]string{"New Friend": "new@example.com"}}
+	RegisterTools(s, reloaded)
+
+	// A contact tool of the previous registration finishing afterwards must not bring the previous config back
+	refreshTools(s)
+
+	description := toolDescription(t, s, "send_email")
+	if strings.Contains(description, "Old Friend") || !strings.Contains(description, "New Friend") {
+		t.Errorf("send_email description after refresh lists the wrong contacts:\n%s", description)
+	}
+}
@@ -0,0 +1,161 @@
+package shared
+
+import (
+	"fmt"
+	"html"
+	"net/mail"
+	"strings"
+)
+
+// Identity is an address the account may send as, such as an alias or a shared mailbox
+type Identity struct {
+	Address string `json:"address"`
+	// Name is the display name of the From header
+	Name string `json:"name"`
+	// ReplyTo sets a Reply-To header, e.g. a team address
+	ReplyTo string `json:"reply_to"`
+	// Signature is appended to plain text bodies; SignatureHTML to HTML bodies.
+	// Either one is derived from the other when only one is given.
+	Signature     string `json:"signature"`
+	SignatureHTML string `json:"signature_html"`
+	// BodyFormat is the body format used when send_email does not specify one
+	BodyFormat string `json:"body_format"`
+}
+
+// validate checks an identity's addresses and body format
+func (i *Identity) validate(prefix string, problems *configProblems) {
+	if i.Address == "" {
+		problems.add(prefix+"address", "is required")
+	} else {
+		checkAddress(problems, prefix+"address", i.Address)
+	}
+	if strings.ContainsAny(i.Name, "\r\n") {
+		problems.add(prefix+"name", "must not contain line breaks")
+	}
+	if i.ReplyTo != "" {
+		if _, err := mail.ParseAddressList(i.ReplyTo); err != nil {
+			problems.add(prefix+"reply_to", "invalid email address list %q", i.ReplyTo)
+		}
+	}
+	i.BodyFormat = strings.ToLower(i.BodyFormat)
+	switch i.BodyFormat {
+	case "", "text", "markdown", "html":
+	default:
+		problems.add(prefix+"body_format", "invalid value %q: expected text, markdown or html", i.BodyFormat)
+	}
+}
+
+// validateIdentities checks the configured identities of an account
+func (c *Config) validateIdentities(prefix string, problems *configProblems) {
+	seen := make(map[string]bool)
+	for i := range c.Identities {
+		identity := &c.Identities[i]
+		identityPrefix := fmt.Sprintf("%sidentities[%d].", prefix, i)
+		identity.validate(identityPrefix, problems)
+		key := addressKey(identity.Address)
+		if key != "" && seen[key] {
+			problems.add(identityPrefix+"address", "duplicate identity %s", identity.Address)
+		}
+		seen[key] = true
+	}
+}
+
+// SenderIdentities returns the identities the account may send as. my_email is
+// always one of them and comes first, so it is the default identity.
+func (c *Config) SenderIdentities() []Identity {
+	var identities []Identity
+	for _, identity := range c.Identities {
+		if sameAddress(identity.Address, c.MyEmail) {
+			identities = append([]Identity{identity}, identities...)
+		} else {
+			identities = append(identities, identity)
+		}
+	}
+	if c.MyEmail != "" && (len(identities) == 0 || !sameAddress(identities[0].Address, c.MyEmail)) {
+		identities = append([]Identity{{Address: c.MyEmail}}, identities...)
+	}
+	return identities
+}
+
+// Identity returns the identity to send as. An empty from selects the default
+// identity; otherwise from must be the address of a configured identity.
+func (c *Config) Identity(from string) (*Identity, error) {
+	identities := c.SenderIdentities()
+	if len(identities) == 0 {
+		return nil, fmt.Errorf("no sender address configured")
+	}
+	if from == "" {
+		return &identities[0], nil
+	}
+
+	for i := range identities {
+		if sameAddress(identities[i].Address, from) {
+			return &identities[i], nil
+		}
+	}
+
+	var addresses []string
+	for _, identity := range identities {
+		addresses = append(addresses, identity.Address)
+	}
+	return nil, fmt.Errorf("%s is not one of your sender identities (available: %s)", from, strings.Join(addresses, ", "))
+}
+
+// IsOwnAddress reports whether address belongs to one of the account's identities
+func (c *Config) IsOwnAddress(address string) bool {
+	for _, identity := range c.SenderIdentities() {
+		if sameAddress(identity.Address, address) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetIdentitiesDescription returns a formatted string of sender identities for tool descriptions
+func (c *Config) GetIdentitiesDescription() string {
+	identities := c.SenderIdentities()
+	if len(identities) < 2 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("You can send as (use the 'from' argument):\n")
+	for i, identity := range identities {
+		b.WriteString("  - " + identity.Address)
+		if identity.Name != "" {
+			b.WriteString(" (" + identity.Name + ")")
+		}
+		if i == 0 {
+			b.WriteString(" [default]")
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// appendSignature adds the identity's signature below the plain text body and,
+// with hasHTML, below the HTML body, separated by the conventional "-- " line
+func (i *Identity) appendSignature(text, htmlBody string, hasHTML bool) (string, string) {
+	signature, signatureHTML := i.Signature, i.SignatureHTML
+	if signature == "" && signatureHTML == "" {
+		return text, htmlBody
+	}
+	if signature == "" {
+		signature = strings.TrimRight(htmlToText(signatureHTML), "\n")
+	}
+	if signatureHTML == "" {
+		signatureHTML = strings.ReplaceAll(html.EscapeString(signature), "\n", "<br>\n")
+	}
+
+	text = appendBlock(text, "-- \n"+signature+"\n", "\n\n")
+	if hasHTML {
+		block := `<div class="signature">-- <br>` + "\n" + signatureHTML + "</div>\n"
+		// Keep the signature inside the body of complete HTML documents
+		if end := strings.LastIndex(strings.ToLower(htmlBody), "</body>"); end >= 0 {
+			htmlBody = htmlBody[:end] + block + htmlBody[end:]
+		} else {
+			htmlBody += block
+		}
+	}
+	return text, htmlBody
+}
@@ -0,0 +1,177 @@
+package shared
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSenderIdentities(t *testing.T) {
+	tests := []struct {
+		name       string
+		myEmail    string
+		identities []Identity
+		want       []string
+	}{
+		{name: "nothing configured"},
+		{name: "my_email only", myEmail: "me@example.com", want: []string{"me@example.com"}},
+		{
+			name:       "my_email comes first",
+			myEmail:    "me@example.com",
+			identities: []Identity{{Address: "team@example.com"}, {Address: "alias@example.com"}},
+			want:       []string{"me@example.com", "team@example.com", "alias@example.com"},
+		},
+		{
+			name:       "configured my_email identity moves first",
+			myEmail:    "me@example.com",
+			identities: []Identity{{Address: "team@example.com"}, {Address: "Me <ME@example.com>", Name: "Me"}},
+			want:       []string{"Me <ME@example.com>", "team@example.com"},
+		},
+		{
+			name:       "identities without my_email",
+			identities: []Identity{{Address: "team@example.com"}},
+			want:       []string{"team@example.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{MyEmail: tt.myEmail, Identities: tt.identities}
+			var got []string
+			for _, identity := range config.SenderIdentities() {
+				got = append(got, identity.Address)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SenderIdentities() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigIdentity(t *testing.T) {
+	config := &Config{
+		MyEmail: "me@example.com",
+		Identities: []Identity{
+			{Address: "team@example.com", Name: "Team", Signature: "The team"},
+		},
+	}
+
+	tests := []struct {
+		from    string
+		want    string
+		wantErr string
+	}{
+		{from: "", want: "me@example.com"},
+		{from: "me@example.com", want: "me@example.com"},
+		{from: "TEAM@example.com", want: "team@example.com"},
+		{from: "Someone <team@example.com>", want: "team@example.com"},
+		{from: "other@example.com", wantErr: "other@example.com is not one of your sender identities (available: me@example.com, team@example.com)"},
+	}
+
+	for _, tt := range tests {
+		identity, err := config.Identity(tt.from)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("Identity(%q) error = %v, want %q", tt.from, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || identity.Address != tt.want {
+			t.Errorf("Identity(%q) = %v, %v, want %s", tt.from, identity, err, tt.want)
+		}
+	}
+
+	if identity, _ := config.Identity("team@example.com"); identity.Signature != "The team" {
+		t.Errorf("Identity() signature = %q, want the configured one", identity.Signature)
+	}
+	if _, err := (&Config{}).Identity(""); err == nil || !strings.Contains(err.Error(), "no sender address") {
+		t.Errorf("Identity() without addresses error = %v, want no sender address", err)
+	}
+}
+
+func TestAppendSignature(t *testing.T) {
+	tests := []struct {
+		name     string
+		identity Identity
+		text     string
+		html     string
+		hasHTML  bool
+		wantText string
+		wantHTML string
+	}{
+		{
+			name:     "no signature",
+			text:     "Hi",
+			wantText: "Hi",
+		},
+		{
+			name:     "plain text",
+			identity: Identity{Signature: "Jane\nACME"},
+			text:     "Hi\n\n",
+			wantText: "Hi\n\n-- \nJane\nACME\n",
+		},
+		{
+			name:     "empty body",
+			identity: Identity{Signature: "Jane"},
+			wantText: "-- \nJane\n",
+		},
+		{
+			name:     "html derived from text",
+			identity: Identity{Signature: "Jane <CEO>\nACME"},
+			text:     "Hi",
+			html:     "<p>Hi</p>",
+			hasHTML:  true,
+			wantText: "Hi\n\n-- \nJane <CEO>\nACME\n",
+			wantHTML: "<p>Hi</p><div class=\"signature\">-- <br>\nJane &lt;CEO&gt;<br>\nACME</div>\n",
+		},
+		{
+			name:     "text derived from html",
+			identity: Identity{SignatureHTML: "<b>Jane</b><br>ACME"},
+			text:     "Hi",
+			html:     "<p>Hi</p>",
+			hasHTML:  true,
+			wantText: "Hi\n\n-- \nJane\nACME\n",
+			wantHTML: "<p>Hi</p><div class=\"signature\">-- <br>\n<b>Jane</b><br>ACME</div>\n",
+		},
+		{
+			name:     "both given",
+			identity: Identity{Signature: "Jane (text)", SignatureHTML: "<i>Jane</i>"},
+			text:     "Hi",
+			html:     "<p>Hi</p>",
+			hasHTML:  true,
+			wantText: "Hi\n\n-- \nJane (text)\n",
+			wantHTML: "<p>Hi</p><div class=\"signature\">-- <br>\n<i>Jane</i></div>\n",
+		},
+		{
+			name:     "inside the body of an html document",
+			identity: Identity{SignatureHTML: "Jane"},
+			text:     "Hi",
+			html:     "<html><body><p>Hi</p></BODY></html>",
+			hasHTML:  true,
+			wantText: "Hi\n\n-- \nJane\n",
+			wantHTML: "<html><body><p>Hi</p><div class=\"signature\">-- <br>\nJane</div>\n</BODY></html>",
+		},
+		{
+			name:     "html of an empty forward note",
+			identity: Identity{Signature: "Jane"},
+			hasHTML:  true,
+			wantText: "-- \nJane\n",
+			wantHTML: "<div class=\"signature\">-- <br>\nJane</div>\n",
+		},
+		{
+			name:     "text only message",
+			identity: Identity{Signature: "Jane", SignatureHTML: "<b>Jane</b>"},
+			text:     "Hi",
+			wantText: "Hi\n\n-- \nJane\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, html := tt.identity.appendSignature(tt.text, tt.html, tt.hasHTML)
+			if text != tt.wantText || html != tt.wantHTML {
+				t.Errorf("appendSignature() = %q, %q, want %q, %q", text, html, tt.wantText, tt.wantHTML)
+			}
+		})
+	}
+}
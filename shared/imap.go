@@ -1,11 +1,15 @@
 package shared
 
 import (
+	"bufio"
 	"bytes"
-	"crypto/tls"
+	"encoding/base64"
 	"fmt"
+	"html"
 	"io"
 	"mime"
+	"mime/quotedprintable"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -13,16 +17,33 @@ import (
 	"github.com/emersion/go-imap/v2"
 	"github.com/emersion/go-imap/v2/imapclient"
 	gomessage "github.com/emersion/go-message"
+	"github.com/emersion/go-message/charset"
 	"github.com/emersion/go-message/mail"
+	"github.com/emersion/go-message/textproto"
 )
 
+// DefaultFolder is the mailbox used when a tool call does not name one
+const DefaultFolder = "INBOX"
+
 // Email represents basic email information
 type Email struct {
 	ID      string    `json:"id"`
+	Folder  string    `json:"folder"`
 	From    string    `json:"from"`
 	Subject string    `json:"subject"`
 	Date    time.Time `json:"date"`
-	Read    bool      `json:"read"`
+	Flags   []string  `json:"flags"`
+}
+
+// Folder represents an IMAP mailbox as returned by LIST
+type Folder struct {
+	Name       string   `json:"name"`
+	Delimiter  string   `json:"delimiter,omitempty"`
+	Attributes []string `json:"attributes,omitempty"`
+	SpecialUse string   `json:"special_use,omitempty"`
+	Selectable bool     `json:"selectable"`
+	Messages   uint32   `json:"messages"`
+	Unread     uint32   `json:"unread"`
 }
 
 // AttachmentInfo represents metadata about an email attachment
@@ -36,28 +57,48 @@ type AttachmentInfo struct {
 // EmailDetail represents full email content
 type EmailDetail struct {
 	ID          string           `json:"id"`
+	Folder      string           `json:"folder"`
+	MessageID   string           `json:"message_id,omitempty"`
+	InReplyTo   []string         `json:"in_reply_to,omitempty"`
+	References  []string         `json:"references,omitempty"`
 	From        string           `json:"from"`
+	ReplyTo     []string         `json:"reply_to,omitempty"`
 	To          []string         `json:"to"`
 	CC          []string         `json:"cc,omitempty"`
 	Subject     string           `json:"subject"`
 	Date        time.Time        `json:"date"`
 	Body        string           `json:"body"`
 	ContentType string           `json:"content_type"`
-	Read        bool             `json:"read"`
+	Flags       []string         `json:"flags"`
 	Attachments []AttachmentInfo `json:"attachments,omitempty"`
 }
 
+// IMAPAuthLogin authenticates IMAP sessions with the LOGIN command and the configured password
+const IMAPAuthLogin = "login"
+
+// IMAP security modes
+const (
+	IMAPSecurityNone     = "none"
+	IMAPSecurityStartTLS = "starttls"
+	IMAPSecurityTLS      = "tls"
+)
+
 // IMAPClient handles IMAP operations
 type IMAPClient struct {
 	config *Config
+	pool   *imapPool
 }
 
-// NewIMAPClient creates a new IMAP client
+// NewIMAPClient creates a new IMAP client.
+// Clients for the same account share one pool of authenticated sessions.
 func NewIMAPClient(config *Config) *IMAPClient {
-	return &IMAPClient{config: config}
+	c := &IMAPClient{config: config}
+	c.pool = getIMAPPool(c)
+	return c
 }
 
-// ValidateConnection tests the IMAP connection and credentials
+// ValidateConnection tests the IMAP connection and credentials.
+// It always dials a fresh connection instead of using the pool.
 func (c *IMAPClient) ValidateConnection() error {
 	client, err := c.Connect()
 	if err != nil {
@@ -69,46 +110,256 @@ func (c *IMAPClient) ValidateConnection() error {
 
 // Connect establishes a connection to the IMAP server
 func (c *IMAPClient) Connect() (*imapclient.Client, error) {
+	return c.connect(nil)
+}
+
+// connect dials and logs in to the IMAP server.
+// If handler is set, it receives unilateral server data such as EXISTS updates during IDLE.
+func (c *IMAPClient) connect(handler *imapclient.UnilateralDataHandler) (*imapclient.Client, error) {
 	addr := fmt.Sprintf("%s:%d", c.config.IMAP.Server, c.config.IMAP.Port)
 
 	var client *imapclient.Client
 	var err error
 
-	if c.config.IMAP.UseTLS {
-		client, err = imapclient.DialTLS(addr, &imapclient.Options{
-			TLSConfig: &tls.Config{
-				ServerName: c.config.IMAP.Server,
-			},
-		})
-	} else {
-		client, err = imapclient.DialInsecure(addr, nil)
+	tlsConfig, err := c.config.TLSConfig(c.config.IMAP.Server)
+	if err != nil {
+		return nil, err
+	}
+
+	options := &imapclient.Options{
+		UnilateralDataHandler: handler,
+		TLSConfig:             tlsConfig,
+	}
+
+	switch c.config.IMAP.Security {
+	case IMAPSecurityTLS:
+		client, err = imapclient.DialTLS(addr, options)
+	case IMAPSecurityStartTLS:
+		client, err = imapclient.DialStartTLS(addr, options)
+	default:
+		client, err = imapclient.DialInsecure(addr, options)
 	}
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to IMAP server: %w", err)
 	}
 
-	// Login
-	if err := client.Login(c.config.IMAP.Username, c.config.IMAP.Password).Wait(); err != nil {
+	if err := c.login(client); err != nil {
 		client.Close()
-		return nil, fmt.Errorf("failed to login: %w", err)
+		return nil, err
 	}
 
 	return client, nil
 }
 
-// GetInbox retrieves emails from the inbox
-func (c *IMAPClient) GetInbox(limit int, unreadOnly bool) ([]*Email, error) {
-	client, err := c.Connect()
+// login authenticates with the password, or with an OAuth2 access token via AUTHENTICATE
+func (c *IMAPClient) login(client *imapclient.Client) error {
+	switch c.config.IMAP.AuthMechanism {
+	case AuthXOAuth2, AuthOAuthBearer:
+		saslClient, err := oauth2SASLClient(c.config, c.config.IMAP.AuthMechanism, c.config.IMAP.Username, c.config.IMAP.Server, c.config.IMAP.Port)
+		if err != nil {
+			return err
+		}
+		if err := client.Authenticate(saslClient); err != nil {
+			getTokenSource(c.config).invalidate()
+			return fmt.Errorf("failed to authenticate: %w", err)
+		}
+	default:
+		if err := client.Login(c.config.IMAP.Username, c.config.IMAP.Password).Wait(); err != nil {
+			return fmt.Errorf("failed to login: %w", err)
+		}
+	}
+	return nil
+}
+
+// FormatEmailID builds an email ID that carries the folder along with the UID,
+// so a later call cannot silently act on a message in a different mailbox
+func FormatEmailID(folder string, uid imap.UID) string {
+	return fmt.Sprintf("%s:%d", folder, uid)
+}
+
+// ParseEmailID splits an email ID into its folder and UID.
+// Bare numeric IDs are resolved against the given folder (INBOX if empty).
+// If the ID carries a folder and a different folder is requested explicitly,
+// an error is returned instead of guessing which one was meant.
+func ParseEmailID(emailID, folder string) (string, imap.UID, error) {
+	idFolder := ""
+	uidPart := emailID
+	if idx := strings.LastIndex(emailID, ":"); idx >= 0 {
+		idFolder = emailID[:idx]
+		uidPart = emailID[idx+1:]
+	}
+
+	uid, err := strconv.ParseUint(uidPart, 10, 32)
+	if err != nil || uid == 0 {
+		return "", 0, fmt.Errorf("invalid email ID: %q", emailID)
+	}
+
+	switch {
+	case idFolder == "":
+		if folder == "" {
+			folder = DefaultFolder
+		}
+	case folder == "":
+		folder = idFolder
+	case folder != idFolder:
+		return "", 0, fmt.Errorf("email ID %s belongs to folder %q, not %q", emailID, idFolder, folder)
+	}
+
+	return folder, imap.UID(uid), nil
+}
+
+// selectFolder selects the given folder, defaulting to INBOX
+func selectFolder(client *imapclient.Client, folder string) (*imap.SelectData, error) {
+	if folder == "" {
+		folder = DefaultFolder
+	}
+	mbox, err := client.Select(folder, nil).Wait()
+	if err != nil {
+		return nil, fmt.Errorf("failed to select folder %s: %w", folder, err)
+	}
+	return mbox, nil
+}
+
+// hasFlag reports whether the flag list contains the given flag
+func hasFlag(flags []imap.Flag, flag imap.Flag) bool {
+	for _, f := range flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// flagNames converts IMAP flags to strings for JSON output, never returning nil
+func flagNames(flags []imap.Flag) []string {
+	names := make([]string, 0, len(flags))
+	for _, flag := range flags {
+		names = append(names, string(flag))
+	}
+	return names
+}
+
+// formatAddress renders an envelope address as "Name <addr>" or just "addr".
+// Names with special characters, such as "Doe, John", are quoted so that the
+// result parses back as a single address.
+func formatAddress(addr imap.Address) string {
+	if addr.Name == "" {
+		return addr.Addr()
+	}
+	name := addr.Name
+	if strings.ContainsAny(name, "()<>[]:;@\\,.\"") {
+		name = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(name) + `"`
+	}
+	return fmt.Sprintf("%s <%s>", name, addr.Addr())
+}
+
+// newEmailFromMessage builds an Email summary from fetched envelope data
+func newEmailFromMessage(folder string, msg *imapclient.FetchMessageBuffer) *Email {
+	email := &Email{
+		ID:     FormatEmailID(folder, msg.UID),
+		Folder: folder,
+		Flags:  flagNames(msg.Flags),
+	}
+
+	if msg.Envelope != nil {
+		if len(msg.Envelope.From) > 0 {
+			email.From = formatAddress(msg.Envelope.From[0])
+		}
+		email.Subject = msg.Envelope.Subject
+		email.Date = msg.Envelope.Date
+	}
+
+	return email
+}
+
+// ListFolders returns all mailboxes with their attributes and message counts
+func (c *IMAPClient) ListFolders() ([]*Folder, error) {
+	client, err := c.pool.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer c.pool.release(client)
+
+	caps := client.Caps()
+	statusOptions := &imap.StatusOptions{
+		NumMessages: true,
+		NumUnseen:   true,
+	}
+
+	listOptions := &imap.ListOptions{}
+	if caps.Has(imap.CapSpecialUse) {
+		listOptions.ReturnSpecialUse = true
+	}
+	// Get counters in the same round trip when the server supports it
+	if caps.Has(imap.CapListStatus) || caps.Has(imap.CapIMAP4rev2) {
+		listOptions.ReturnStatus = statusOptions
+	}
+
+	mailboxes, err := client.List("", "*", listOptions).Collect()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list folders: %w", err)
+	}
+
+	folders := make([]*Folder, 0, len(mailboxes))
+	for _, mbox := range mailboxes {
+		folder := &Folder{
+			Name:       mbox.Mailbox,
+			Selectable: true,
+		}
+		if mbox.Delim != 0 {
+			folder.Delimiter = string(mbox.Delim)
+		}
+
+		for _, attr := range mbox.Attrs {
+			folder.Attributes = append(folder.Attributes, string(attr))
+			switch attr {
+			case imap.MailboxAttrNoSelect, imap.MailboxAttrNonExistent:
+				folder.Selectable = false
+			case imap.MailboxAttrAll, imap.MailboxAttrArchive, imap.MailboxAttrDrafts,
+				imap.MailboxAttrFlagged, imap.MailboxAttrJunk, imap.MailboxAttrSent,
+				imap.MailboxAttrTrash, imap.MailboxAttrImportant:
+				folder.SpecialUse = string(attr)
+			}
+		}
+
+		if folder.Selectable {
+			status := mbox.Status
+			if status == nil {
+				status, err = client.Status(mbox.Mailbox, statusOptions).Wait()
+				if err != nil {
+					return nil, fmt.Errorf("failed to get status of folder %s: %w", mbox.Mailbox, err)
+				}
+			}
+			if status.NumMessages != nil {
+				folder.Messages = *status.NumMessages
+			}
+			if status.NumUnseen != nil {
+				folder.Unread = *status.NumUnseen
+			}
+		}
+
+		folders = append(folders, folder)
+	}
+
+	return folders, nil
+}
+
+// GetInbox retrieves the latest emails from a folder (INBOX if empty)
+func (c *IMAPClient) GetInbox(folder string, limit int, unreadOnly bool) ([]*Email, error) {
+	if folder == "" {
+		folder = DefaultFolder
+	}
+
+	client, err := c.pool.acquire()
 	if err != nil {
 		return nil, err
 	}
-	defer client.Close()
+	defer c.pool.release(client)
 
-	// Select INBOX
-	mbox, err := client.Select("INBOX", nil).Wait()
+	mbox, err := selectFolder(client, folder)
 	if err != nil {
-		return nil, fmt.Errorf("failed to select INBOX: %w", err)
+		return nil, err
 	}
 
 	if mbox.NumMessages == 0 {
@@ -138,43 +389,15 @@ func (c *IMAPClient) GetInbox(limit int, unreadOnly bool) ([]*Email, error) {
 
 	var emails []*Email
 	for _, msg := range messages {
-		isRead := false
-		for _, flag := range msg.Flags {
-			if flag == imap.FlagSeen {
-				isRead = true
-				break
-			}
-		}
+		email := newEmailFromMessage(folder, msg)
+		c.config.harvestEnvelope(msg.Envelope)
 
 		// Skip read emails if unreadOnly is true
-		if unreadOnly && isRead {
+		if unreadOnly && hasFlag(msg.Flags, imap.FlagSeen) {
 			continue
 		}
 
-		from := ""
-		if msg.Envelope != nil && len(msg.Envelope.From) > 0 {
-			addr := msg.Envelope.From[0]
-			if addr.Name != "" {
-				from = fmt.Sprintf("%s <%s>", addr.Name, addr.Addr())
-			} else {
-				from = addr.Addr()
-			}
-		}
-
-		subject := ""
-		date := time.Time{}
-		if msg.Envelope != nil {
-			subject = msg.Envelope.Subject
-			date = msg.Envelope.Date
-		}
-
-		emails = append(emails, &Email{
-			ID:      strconv.FormatUint(uint64(msg.UID), 10),
-			From:    from,
-			Subject: subject,
-			Date:    date,
-			Read:    isRead,
-		})
+		emails = append(emails, email)
 	}
 
 	// Reverse to show newest first
@@ -185,35 +408,35 @@ func (c *IMAPClient) GetInbox(limit int, unreadOnly bool) ([]*Email, error) {
 	return emails, nil
 }
 
-// GetEmailContents retrieves the full content of an email
-func (c *IMAPClient) GetEmailContents(emailID string) (*EmailDetail, error) {
-	uid, err := strconv.ParseUint(emailID, 10, 32)
+// GetEmailContents retrieves the full content of an email.
+// The body is fetched with BODY.PEEK so reading does not set \Seen;
+// pass markAsRead to flag the email as read explicitly.
+func (c *IMAPClient) GetEmailContents(emailID, folder string, markAsRead bool) (*EmailDetail, error) {
+	folder, uid, err := ParseEmailID(emailID, folder)
 	if err != nil {
-		return nil, fmt.Errorf("invalid email ID: %w", err)
+		return nil, err
 	}
 
-	client, err := c.Connect()
+	client, err := c.pool.acquire()
 	if err != nil {
 		return nil, err
 	}
-	defer client.Close()
+	defer c.pool.release(client)
 
-	// Select INBOX
-	_, err = client.Select("INBOX", nil).Wait()
-	if err != nil {
-		return nil, fmt.Errorf("failed to select INBOX: %w", err)
+	if _, err := selectFolder(client, folder); err != nil {
+		return nil, err
 	}
 
 	// Create UID set
 	var uidSet imap.UIDSet
-	uidSet.AddNum(imap.UID(uid))
+	uidSet.AddNum(uid)
 
-	// Fetch with body
+	// Fetch with body, without implicitly setting \Seen
 	fetchOptions := &imap.FetchOptions{
 		Envelope:    true,
 		Flags:       true,
 		UID:         true,
-		BodySection: []*imap.FetchItemBodySection{{}},
+		BodySection: []*imap.FetchItemBodySection{{Peek: true}},
 	}
 
 	messages, err := client.Fetch(uidSet, fetchOptions).Collect()
@@ -226,28 +449,36 @@ func (c *IMAPClient) GetEmailContents(emailID string) (*EmailDetail, error) {
 	}
 
 	msg := messages[0]
+	c.config.harvestEnvelope(msg.Envelope)
 
-	isRead := false
-	for _, flag := range msg.Flags {
-		if flag == imap.FlagSeen {
-			isRead = true
-			break
+	flags := msg.Flags
+
+	if markAsRead && !hasFlag(flags, imap.FlagSeen) {
+		storeFlags := &imap.StoreFlags{
+			Op:     imap.StoreFlagsAdd,
+			Flags:  []imap.Flag{imap.FlagSeen},
+			Silent: true,
 		}
+		if err := client.Store(uidSet, storeFlags, nil).Close(); err != nil {
+			return nil, fmt.Errorf("failed to mark email as read: %w", err)
+		}
+		flags = append(flags, imap.FlagSeen)
 	}
 
 	from := ""
-	var to, cc []string
+	var replyTo, to, cc []string
 	subject := ""
 	date := time.Time{}
+	messageID := ""
+	var inReplyTo []string
 
 	if msg.Envelope != nil {
 		if len(msg.Envelope.From) > 0 {
-			addr := msg.Envelope.From[0]
-			if addr.Name != "" {
-				from = fmt.Sprintf("%s <%s>", addr.Name, addr.Addr())
-			} else {
-				from = addr.Addr()
-			}
+			from = formatAddress(msg.Envelope.From[0])
+		}
+
+		for _, addr := range msg.Envelope.ReplyTo {
+			replyTo = append(replyTo, addr.Addr())
 		}
 
 		for _, addr := range msg.Envelope.To {
@@ -260,6 +491,8 @@ func (c *IMAPClient) GetEmailContents(emailID string) (*EmailDetail, error) {
 
 		subject = msg.Envelope.Subject
 		date = msg.Envelope.Date
+		messageID = msg.Envelope.MessageID
+		inReplyTo = msg.Envelope.InReplyTo
 	}
 
 	// Get body content
@@ -274,46 +507,52 @@ func (c *IMAPClient) GetEmailContents(emailID string) (*EmailDetail, error) {
 		rawBody = msg.BodySection[0].Bytes
 	}
 
+	var references []string
 	if rawBody != nil {
 		body, contentType, attachments = parseEmailBodyWithGoMessage(rawBody)
+		references = parseReferences(rawBody)
 	}
 
 	return &EmailDetail{
-		ID:          emailID,
+		ID:          FormatEmailID(folder, uid),
+		Folder:      folder,
+		MessageID:   messageID,
+		InReplyTo:   inReplyTo,
+		References:  references,
 		From:        from,
+		ReplyTo:     replyTo,
 		To:          to,
 		CC:          cc,
 		Subject:     subject,
 		Date:        date,
 		Body:        body,
 		ContentType: contentType,
-		Read:        isRead,
+		Flags:       flagNames(flags),
 		Attachments: attachments,
 	}, nil
 }
 
 // MarkAsRead marks an email as read
-func (c *IMAPClient) MarkAsRead(emailID string) error {
-	uid, err := strconv.ParseUint(emailID, 10, 32)
+func (c *IMAPClient) MarkAsRead(emailID, folder string) error {
+	folder, uid, err := ParseEmailID(emailID, folder)
 	if err != nil {
-		return fmt.Errorf("invalid email ID: %w", err)
+		return err
 	}
 
-	client, err := c.Connect()
+	client, err := c.pool.acquire()
 	if err != nil {
 		return err
 	}
-	defer client.Close()
+	defer c.pool.release(client)
 
-	// Select INBOX (not read-only)
-	_, err = client.Select("INBOX", nil).Wait()
-	if err != nil {
-		return fmt.Errorf("failed to select INBOX: %w", err)
+	// Select folder (not read-only)
+	if _, err := selectFolder(client, folder); err != nil {
+		return err
 	}
 
 	// Create UID set
 	var uidSet imap.UIDSet
-	uidSet.AddNum(imap.UID(uid))
+	uidSet.AddNum(uid)
 
 	// Add \Seen flag
 	storeFlags := &imap.StoreFlags{
@@ -329,22 +568,28 @@ func (c *IMAPClient) MarkAsRead(emailID string) error {
 	return nil
 }
 
-// GetLatestUID returns the highest UID in the inbox
-func (c *IMAPClient) GetLatestUID() (uint32, error) {
-	client, err := c.Connect()
+// GetLatestUID returns the highest UID in a folder (INBOX if empty)
+func (c *IMAPClient) GetLatestUID(folder string) (uint32, error) {
+	_, latestUID, err := c.GetFolderUIDs(folder)
+	return latestUID, err
+}
+
+// GetFolderUIDs returns the UIDVALIDITY of a folder (INBOX if empty) and its highest UID.
+// UIDs are only comparable while UIDVALIDITY stays the same.
+func (c *IMAPClient) GetFolderUIDs(folder string) (uidValidity, latestUID uint32, err error) {
+	client, err := c.pool.acquire()
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
-	defer client.Close()
+	defer c.pool.release(client)
 
-	// Select INBOX
-	mbox, err := client.Select("INBOX", nil).Wait()
+	mbox, err := selectFolder(client, folder)
 	if err != nil {
-		return 0, fmt.Errorf("failed to select INBOX: %w", err)
+		return 0, 0, err
 	}
 
 	if mbox.NumMessages == 0 {
-		return 0, nil
+		return mbox.UIDValidity, 0, nil
 	}
 
 	// Fetch the last message to get its UID
@@ -357,30 +602,56 @@ func (c *IMAPClient) GetLatestUID() (uint32, error) {
 
 	messages, err := client.Fetch(seqSet, fetchOptions).Collect()
 	if err != nil {
-		return 0, fmt.Errorf("failed to fetch latest message: %w", err)
+		return 0, 0, fmt.Errorf("failed to fetch latest message: %w", err)
 	}
 
 	if len(messages) == 0 {
-		return 0, nil
+		return mbox.UIDValidity, 0, nil
 	}
 
-	return uint32(messages[0].UID), nil
+	return mbox.UIDValidity, uint32(messages[0].UID), nil
 }
 
-// GetEmailsSinceUID retrieves emails with UID greater than the given UID.
+// GetEmailsSinceUID retrieves emails in a folder with UID greater than the given UID.
 // Uses UIDNEXT from SELECT to quickly detect if new messages exist,
 // then fetches them using UID FETCH with a range.
-func (c *IMAPClient) GetEmailsSinceUID(sinceUID uint32) ([]*Email, error) {
-	client, err := c.Connect()
+func (c *IMAPClient) GetEmailsSinceUID(folder string, sinceUID uint32) ([]*Email, error) {
+	return c.emailsSinceUID(folder, 0, sinceUID)
+}
+
+// UIDValidityError reports that a folder's UIDVALIDITY changed, so UIDs seen
+// before no longer identify the same emails
+type UIDValidityError struct {
+	Folder   string
+	Expected uint32
+	Current  uint32
+}
+
+func (e *UIDValidityError) Error() string {
+	return fmt.Sprintf("UIDVALIDITY of %s changed from %d to %d", e.Folder, e.Expected, e.Current)
+}
+
+// emailsSinceUID is GetEmailsSinceUID that first checks the folder's UIDVALIDITY
+// against uidValidity, unless it is 0, and returns a *UIDValidityError if it differs
+func (c *IMAPClient) emailsSinceUID(folder string, uidValidity, sinceUID uint32) ([]*Email, error) {
+	if folder == "" {
+		folder = DefaultFolder
+	}
+
+	client, err := c.pool.acquire()
 	if err != nil {
 		return nil, err
 	}
-	defer client.Close()
+	defer c.pool.release(client)
 
-	// Select INBOX - check UIDNEXT to see if new messages exist
-	mbox, err := client.Select("INBOX", nil).Wait()
+	// Select folder - check UIDNEXT to see if new messages exist
+	mbox, err := selectFolder(client, folder)
 	if err != nil {
-		return nil, fmt.Errorf("failed to select INBOX: %w", err)
+		return nil, err
+	}
+
+	if uidValidity != 0 && mbox.UIDValidity != uidValidity {
+		return nil, &UIDValidityError{Folder: folder, Expected: uidValidity, Current: mbox.UIDValidity}
 	}
 
 	// If UIDNEXT <= sinceUID+1, no new messages
@@ -410,38 +681,8 @@ func (c *IMAPClient) GetEmailsSinceUID(sinceUID uint32) ([]*Email, error) {
 			continue
 		}
 
-		isRead := false
-		for _, flag := range msg.Flags {
-			if flag == imap.FlagSeen {
-				isRead = true
-				break
-			}
-		}
-
-		from := ""
-		if msg.Envelope != nil && len(msg.Envelope.From) > 0 {
-			addr := msg.Envelope.From[0]
-			if addr.Name != "" {
-				from = fmt.Sprintf("%s <%s>", addr.Name, addr.Addr())
-			} else {
-				from = addr.Addr()
-			}
-		}
-
-		subject := ""
-		date := time.Time{}
-		if msg.Envelope != nil {
-			subject = msg.Envelope.Subject
-			date = msg.Envelope.Date
-		}
-
-		emails = append(emails, &Email{
-			ID:      strconv.FormatUint(uint64(msg.UID), 10),
-			From:    from,
-			Subject: subject,
-			Date:    date,
-			Read:    isRead,
-		})
+		emails = append(emails, newEmailFromMessage(folder, msg))
+		c.config.harvestEnvelope(msg.Envelope)
 	}
 
 	return emails, nil
@@ -573,31 +814,41 @@ func parseEmailBodyWithGoMessage(data []byte) (body string, contentType string,
 	return body, contentType, attachments
 }
 
-// GetAttachment retrieves a specific attachment from an email by part index
-func (c *IMAPClient) GetAttachment(emailID string, partIndex int) (filename, contentType string, data []byte, err error) {
-	uid, err := strconv.ParseUint(emailID, 10, 32)
+// parseReferences extracts the message IDs from the References header of a raw message or header block
+func parseReferences(data []byte) []string {
+	header, err := textproto.ReadHeader(bufio.NewReader(bytes.NewReader(data)))
 	if err != nil {
-		return "", "", nil, fmt.Errorf("invalid email ID: %w", err)
+		return nil
 	}
+	mailHeader := mail.Header{Header: gomessage.Header{Header: header}}
+	references, _ := mailHeader.MsgIDList("References")
+	return references
+}
 
-	client, err := c.Connect()
+// GetAttachment retrieves a specific attachment from an email by part index
+func (c *IMAPClient) GetAttachment(emailID, folder string, partIndex int) (filename, contentType string, data []byte, err error) {
+	folder, uid, err := ParseEmailID(emailID, folder)
 	if err != nil {
 		return "", "", nil, err
 	}
-	defer client.Close()
 
-	_, err = client.Select("INBOX", nil).Wait()
+	client, err := c.pool.acquire()
 	if err != nil {
-		return "", "", nil, fmt.Errorf("failed to select INBOX: %w", err)
+		return "", "", nil, err
+	}
+	defer c.pool.release(client)
+
+	if _, err := selectFolder(client, folder); err != nil {
+		return "", "", nil, err
 	}
 
 	var uidSet imap.UIDSet
-	uidSet.AddNum(imap.UID(uid))
+	uidSet.AddNum(uid)
 
-	// Fetch full body to parse with go-message
+	// Fetch full body to parse with go-message, without implicitly setting \Seen
 	fetchOptions := &imap.FetchOptions{
 		UID:         true,
-		BodySection: []*imap.FetchItemBodySection{{}},
+		BodySection: []*imap.FetchItemBodySection{{Peek: true}},
 	}
 
 	messages, err := client.Fetch(uidSet, fetchOptions).Collect()
@@ -682,6 +933,148 @@ func (c *IMAPClient) GetAttachment(emailID string, partIndex int) (filename, con
 	return "", "", nil, fmt.Errorf("attachment at index %d not found", partIndex)
 }
 
+// FetchPreviewText returns up to maxBytes of an email's text body without marking it as read.
+// Only a partial BODY.PEEK of the first text part is fetched, so large messages stay cheap.
+func (c *IMAPClient) FetchPreviewText(emailID, folder string, maxBytes int) (string, error) {
+	folder, uid, err := ParseEmailID(emailID, folder)
+	if err != nil {
+		return "", err
+	}
+
+	client, err := c.pool.acquire()
+	if err != nil {
+		return "", err
+	}
+	defer c.pool.release(client)
+
+	if _, err := selectFolder(client, folder); err != nil {
+		return "", err
+	}
+
+	var uidSet imap.UIDSet
+	uidSet.AddNum(uid)
+
+	messages, err := client.Fetch(uidSet, &imap.FetchOptions{
+		UID:           true,
+		BodyStructure: &imap.FetchItemBodyStructure{Extended: true},
+	}).Collect()
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch body structure: %w", err)
+	}
+	if len(messages) == 0 || messages[0].BodyStructure == nil {
+		return "", fmt.Errorf("email not found")
+	}
+
+	part, section := findPreviewPart(messages[0].BodyStructure)
+	if part == nil {
+		return "", nil
+	}
+	section.Peek = true
+	section.Partial = &imap.SectionPartial{Offset: 0, Size: int64(maxBytes)}
+
+	messages, err = client.Fetch(uidSet, &imap.FetchOptions{
+		UID:         true,
+		BodySection: []*imap.FetchItemBodySection{section},
+	}).Collect()
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch message text: %w", err)
+	}
+	if len(messages) == 0 {
+		return "", fmt.Errorf("email not found")
+	}
+
+	data := messages[0].FindBodySection(section)
+	if data == nil && len(messages[0].BodySection) > 0 {
+		data = messages[0].BodySection[0].Bytes
+	}
+
+	text := decodePartialText(data, part.Encoding, part.Params["charset"])
+	if strings.EqualFold(part.Subtype, "html") {
+		text = stripHTMLTags(text)
+	}
+	return text, nil
+}
+
+// findPreviewPart locates the first non-attachment text/plain part (or text/html if there is none)
+// and returns it with the body section that addresses its content
+func findPreviewPart(bs imap.BodyStructure) (*imap.BodyStructureSinglePart, *imap.FetchItemBodySection) {
+	// A single-part message body is addressed as TEXT
+	if single, ok := bs.(*imap.BodyStructureSinglePart); ok {
+		if !strings.EqualFold(single.Type, "text") {
+			return nil, nil
+		}
+		return single, &imap.FetchItemBodySection{Specifier: imap.PartSpecifierText}
+	}
+
+	var plain, html *imap.BodyStructureSinglePart
+	var plainPath, htmlPath []int
+	bs.Walk(func(path []int, part imap.BodyStructure) bool {
+		single, ok := part.(*imap.BodyStructureSinglePart)
+		if !ok {
+			return true
+		}
+		if disp := single.Disposition(); disp != nil && strings.EqualFold(disp.Value, "attachment") {
+			return true
+		}
+		switch single.MediaType() {
+		case "text/plain":
+			if plain == nil {
+				plain, plainPath = single, append([]int(nil), path...)
+			}
+		case "text/html":
+			if html == nil {
+				html, htmlPath = single, append([]int(nil), path...)
+			}
+		}
+		return true
+	})
+
+	if plain != nil {
+		return plain, &imap.FetchItemBodySection{Part: plainPath}
+	}
+	if html != nil {
+		return html, &imap.FetchItemBodySection{Part: htmlPath}
+	}
+	return nil, nil
+}
+
+// decodePartialText decodes a possibly truncated part body according to its transfer encoding and charset
+func decodePartialText(data []byte, encoding, charsetName string) string {
+	var r io.Reader = bytes.NewReader(data)
+
+	switch strings.ToLower(encoding) {
+	case "base64":
+		// Drop whitespace and any incomplete trailing quantum left by the partial fetch
+		clean := strings.Join(strings.Fields(string(data)), "")
+		clean = clean[:len(clean)-len(clean)%4]
+		r = base64.NewDecoder(base64.StdEncoding, strings.NewReader(clean))
+	case "quoted-printable":
+		// Drop an escape cut short by the partial fetch, which the decoder would pass through as is
+		if i := bytes.LastIndexByte(data, '='); i >= 0 && i >= len(data)-2 {
+			data = data[:i]
+		}
+		r = quotedprintable.NewReader(bytes.NewReader(data))
+	}
+
+	if charsetName != "" && !strings.EqualFold(charsetName, "utf-8") && !strings.EqualFold(charsetName, "us-ascii") {
+		if cr, err := charset.Reader(charsetName, r); err == nil {
+			r = cr
+		}
+	}
+
+	// A truncated part can end mid-sequence, so keep whatever decoded cleanly
+	decoded, _ := io.ReadAll(r)
+	return strings.ToValidUTF8(string(decoded), "")
+}
+
+// htmlTagPattern matches HTML tags, and whole style/script blocks whose text is not content
+var htmlTagPattern = regexp.MustCompile(`(?is)<(style|script)[^>]*>.*?</(style|script)>|<[^>]*>`)
+
+// stripHTMLTags reduces HTML to its visible text for previews
+func stripHTMLTags(s string) string {
+	return html.UnescapeString(htmlTagPattern.ReplaceAllString(s, " "))
+}
+
 // GetEmailPreview returns a preview of an email body (first ~100 chars)
 func GetEmailPreview(body string, maxLen int) string {
 	body = strings.TrimSpace(body)
@@ -693,4 +1086,3 @@ func GetEmailPreview(body string, maxLen int) string {
 	}
 	return body[:maxLen] + "..."
 }
-
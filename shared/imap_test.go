@@ -0,0 +1,256 @@
+package shared
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapserver"
+	"github.com/emersion/go-imap/v2/imapserver/imapmemserver"
+)
+
+func TestParseEmailID(t *testing.T) {
+	tests := []struct {
+		name       string
+		emailID    string
+		folder     string
+		wantFolder string
+		wantUID    imap.UID
+		wantErr    bool
+	}{
+		{"bare UID", "42", "", DefaultFolder, 42, false},
+		{"bare UID with folder", "42", "Archive", "Archive", 42, false},
+		{"folder ID", "INBOX:1234", "", "INBOX", 1234, false},
+		{"folder with colon", "Work:Projects:7", "", "Work:Projects", 7, false},
+		{"Gmail folder", "[Gmail]/Sent Mail:42", "", "[Gmail]/Sent Mail", 42, false},
+		{"matching folder", "Archive:9", "Archive", "Archive", 9, false},
+		{"conflicting folder", "Archive:9", "INBOX", "", 0, true},
+		{"zero UID", "INBOX:0", "", "", 0, true},
+		{"not a number", "INBOX:abc", "", "", 0, true},
+		{"negative", "-5", "", "", 0, true},
+		{"too large", "4294967296", "", "", 0, true},
+		{"empty", "", "", "", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			folder, uid, err := ParseEmailID(tt.emailID, tt.folder)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseEmailID(%q, %q) error = %v, wantErr %v", tt.emailID, tt.folder, err, tt.wantErr)
+			}
+			if folder != tt.wantFolder || uid != tt.wantUID {
+				t.Errorf("ParseEmailID(%q, %q) = %q, %d, want %q, %d", tt.emailID, tt.folder, folder, uid, tt.wantFolder, tt.wantUID)
+			}
+		})
+	}
+}
+
+func TestFormatEmailIDRoundTrip(t *testing.T) {
+	for _, folder := range []string{"INBOX", "[Gmail]/All Mail", "Work:Projects"} {
+		id := FormatEmailID(folder, 77)
+		gotFolder, gotUID, err := ParseEmailID(id, "")
+		if err != nil || gotFolder != folder || gotUID != 77 {
+			t.Errorf("ParseEmailID(FormatEmailID(%q, 77)) = %q, %d, %v", folder, gotFolder, gotUID, err)
+		}
+	}
+}
+
+// testIMAPServer is an in-memory IMAP server with one user whose INBOX starts empty
+type testIMAPServer struct {
+	user   *imapmemserver.User
+	config *Config
+}
+
+// newTestIMAPServer starts an in-memory IMAP server and returns it with a
+// config that logs in to it without TLS
+func newTestIMAPServer(t *testing.T) *testIMAPServer {
+	t.Helper()
+
+	memServer := imapmemserver.New()
+	user := imapmemserver.NewUser("me", "secret")
+	if err := user.Create(DefaultFolder, nil); err != nil {
+		t.Fatal(err)
+	}
+	memServer.AddUser(user)
+
+	server := imapserver.New(&imapserver.Options{
+		NewSession: func(*imapserver.Conn) (imapserver.Session, *imapserver.GreetingData, error) {
+			return memServer.NewSession(), nil, nil
+		},
+		Caps:         imap.CapSet{imap.CapIMAP4rev1: {}, imap.CapIMAP4rev2: {}},
+		Logger:       log.New(io.Discard, "", 0),
+		InsecureAuth: true,
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listener)
+	t.Cleanup(func() {
+		resetIMAPPools()
+		server.Close()
+	})
+
+	config := &Config{MyEmail: "me@example.com"}
+	config.IMAP.Server = "127.0.0.1"
+	config.IMAP.Port = listener.Addr().(*net.TCPAddr).Port
+	config.IMAP.Security = IMAPSecurityNone
+	config.IMAP.AuthMechanism = IMAPAuthLogin
+	config.IMAP.Username = "me"
+	config.IMAP.Password = "secret"
+	config.IMAP.MaxConnections = 2
+	return &testIMAPServer{user: user, config: config}
+}
+
+// testLiteral is a message appended to a test mailbox
+type testLiteral struct {
+	*bytes.Reader
+}
+
+func (l testLiteral) Size() int64 {
+	return l.Reader.Size()
+}
+
+// appendMessage adds a message with the given subject to a folder and returns its UID
+func (s *testIMAPServer) appendMessage(t *testing.T, folder, subject string) imap.UID {
+	t.Helper()
+
+	msg := "From: Sender <sender@example.org>\r\n" +
+		"To: me@example.com\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Date: Mon, 02 Jan 2006 15:04:05 +0000\r\n" +
+		"Message-ID: <" + strings.ReplaceAll(subject, " ", "-") + "@example.org>\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Body of " + subject + "\r\n"
+	data, err := s.user.Append(folder, testLiteral{bytes.NewReader([]byte(msg))}, &imap.AppendOptions{})
+	if err != nil {
+		t.Fatalf("failed to append %q: %v", subject, err)
+	}
+	return data.UID
+}
+
+// recreateInbox deletes and re-creates the INBOX, which changes its UIDVALIDITY
+func (s *testIMAPServer) recreateInbox(t *testing.T) {
+	t.Helper()
+
+	if err := s.user.Delete(DefaultFolder); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.user.Create(DefaultFolder, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecodePartialText(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		encoding string
+		charset  string
+		want     string
+	}{
+		{name: "plain", data: "Hello world", encoding: "7bit", want: "Hello world"},
+		{name: "utf-8 cut inside a rune", data: "caf\xc3", encoding: "8bit", charset: "utf-8", want: "caf"},
+		{name: "quoted-printable", data: "caf=C3=A9 au lait", encoding: "quoted-printable", charset: "utf-8", want: "café au lait"},
+		{name: "quoted-printable soft break", data: "long li=\r\nne", encoding: "Quoted-Printable", want: "long line"},
+		{name: "quoted-printable cut after a soft break marker", data: "long li=", encoding: "quoted-printable", want: "long li"},
+		{name: "quoted-printable cut inside a soft break", data: "long li=\r", encoding: "quoted-printable", want: "long li"},
+		{name: "quoted-printable cut inside an escape", data: "caf=C", encoding: "quoted-printable", want: "caf"},
+		{name: "quoted-printable cut between escaped bytes", data: "caf=C3", encoding: "quoted-printable", charset: "utf-8", want: "caf"},
+		{name: "base64", data: "aGVsbG8gd29ybGQ=", encoding: "base64", want: "hello world"},
+		{name: "base64 with line breaks", data: "aGVs\r\nbG8g\r\nd29y\r\nbGQ=", encoding: "BASE64", want: "hello world"},
+		{name: "base64 cut inside a quantum", data: "aGVsbG8gd29y\r\nbG", encoding: "base64", want: "hello wor"},
+		{name: "base64 cut after one character", data: "aGVsbG8gd", encoding: "base64", want: "hello "},
+		{name: "base64 cut inside a rune", data: "Y2Fmw6k=", encoding: "base64", charset: "utf-8", want: "café"},
+		{name: "base64 quantum ending inside a rune", data: "Y2Fmw6", encoding: "base64", charset: "utf-8", want: "caf"},
+		{name: "latin-1", data: "caf\xe9", encoding: "8bit", charset: "ISO-8859-1", want: "café"},
+		{name: "windows-1252 quoted-printable", data: "=93quoted=94", encoding: "quoted-printable", charset: "windows-1252", want: "“quoted”"},
+		{name: "koi8-r base64", data: "8NLJ18XU", encoding: "base64", charset: "koi8-r", want: "Привет"},
+		{name: "unknown charset keeps the bytes", data: "hello", encoding: "7bit", charset: "x-unknown", want: "hello"},
+		{name: "us-ascii", data: "hello", encoding: "", charset: "US-ASCII", want: "hello"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decodePartialText([]byte(tt.data), tt.encoding, tt.charset); got != tt.want {
+				t.Errorf("decodePartialText(%q, %q, %q) = %q, want %q", tt.data, tt.encoding, tt.charset, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindPreviewPart(t *testing.T) {
+	plain := &imap.BodyStructureSinglePart{Type: "text", Subtype: "plain"}
+	html := &imap.BodyStructureSinglePart{Type: "TEXT", Subtype: "HTML"}
+	attachedText := &imap.BodyStructureSinglePart{Type: "text", Subtype: "plain", Extended: &imap.BodyStructureSinglePartExt{
+		Disposition: &imap.BodyStructureDisposition{Value: "attachment"},
+	}}
+	pdf := &imap.BodyStructureSinglePart{Type: "application", Subtype: "pdf"}
+
+	tests := []struct {
+		name        string
+		bs          imap.BodyStructure
+		wantPart    *imap.BodyStructureSinglePart
+		wantSection *imap.FetchItemBodySection
+	}{
+		{
+			name:        "single text part",
+			bs:          plain,
+			wantPart:    plain,
+			wantSection: &imap.FetchItemBodySection{Specifier: imap.PartSpecifierText},
+		},
+		{
+			name:        "single html part",
+			bs:          html,
+			wantPart:    html,
+			wantSection: &imap.FetchItemBodySection{Specifier: imap.PartSpecifierText},
+		},
+		{name: "single non-text part", bs: pdf},
+		{
+			name:        "alternative prefers plain text",
+			bs:          &imap.BodyStructureMultiPart{Subtype: "alternative", Children: []imap.BodyStructure{html, plain}},
+			wantPart:    plain,
+			wantSection: &imap.FetchItemBodySection{Part: []int{2}},
+		},
+		{
+			name:        "html only",
+			bs:          &imap.BodyStructureMultiPart{Subtype: "mixed", Children: []imap.BodyStructure{html, pdf}},
+			wantPart:    html,
+			wantSection: &imap.FetchItemBodySection{Part: []int{1}},
+		},
+		{
+			name: "nested alternative",
+			bs: &imap.BodyStructureMultiPart{Subtype: "mixed", Children: []imap.BodyStructure{
+				&imap.BodyStructureMultiPart{Subtype: "alternative", Children: []imap.BodyStructure{plain, html}},
+				pdf,
+			}},
+			wantPart:    plain,
+			wantSection: &imap.FetchItemBodySection{Part: []int{1, 1}},
+		},
+		{
+			name:        "attached text file is skipped",
+			bs:          &imap.BodyStructureMultiPart{Subtype: "mixed", Children: []imap.BodyStructure{html, attachedText}},
+			wantPart:    html,
+			wantSection: &imap.FetchItemBodySection{Part: []int{1}},
+		},
+		{
+			name: "attachments only",
+			bs:   &imap.BodyStructureMultiPart{Subtype: "mixed", Children: []imap.BodyStructure{attachedText, pdf}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			part, section := findPreviewPart(tt.bs)
+			if part != tt.wantPart || !reflect.DeepEqual(section, tt.wantSection) {
+				t.Errorf("findPreviewPart() = %+v, %+v, want %+v, %+v", part, section, tt.wantPart, tt.wantSection)
+			}
+		})
+	}
+}
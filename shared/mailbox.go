@@ -0,0 +1,327 @@
+package shared
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// Common folder names used when the server does not advertise SPECIAL-USE attributes
+var (
+	archiveFolderNames = []string{"Archive", "Archives", "[Gmail]/All Mail", "INBOX.Archive"}
+	trashFolderNames   = []string{"Trash", "Deleted Items", "Deleted Messages", "[Gmail]/Trash", "INBOX.Trash"}
+	sentFolderNames    = []string{"Sent", "Sent Items", "Sent Messages", "Sent Mail", "[Gmail]/Sent Mail", "INBOX.Sent"}
+	allMailFolderNames = []string{"[Gmail]/All Mail", "[Google Mail]/All Mail"}
+)
+
+// TransferResult reports the outcome of moving, copying or deleting emails
+type TransferResult struct {
+	Destination string `json:"destination,omitempty"`
+	Count       int    `json:"count"`
+	// NewIDs maps each source email ID to its ID in the destination folder (requires UIDPLUS)
+	NewIDs map[string]string `json:"new_ids,omitempty"`
+	// Expunged is true when emails were permanently removed rather than moved to Trash
+	Expunged bool `json:"expunged,omitempty"`
+	// FlaggedDeleted lists source emails that were flagged \Deleted but not expunged,
+	// because the server cannot expunge single messages (no UIDPLUS)
+	FlaggedDeleted []string `json:"flagged_deleted,omitempty"`
+	Warning        string   `json:"warning,omitempty"`
+}
+
+// noUIDExpungeWarning explains FlaggedDeleted to the caller
+const noUIDExpungeWarning = "The server does not support UIDPLUS, so these emails were only flagged \\Deleted and remain in their folder. " +
+	"They were not expunged because a plain EXPUNGE would also remove every other message flagged \\Deleted in the folder."
+
+// groupEmailIDsByFolder parses email IDs and groups their UIDs by folder, keeping first-seen folder order
+func groupEmailIDsByFolder(emailIDs []string, folder string) ([]string, map[string]*imap.UIDSet, error) {
+	if len(emailIDs) == 0 {
+		return nil, nil, fmt.Errorf("no email IDs given")
+	}
+
+	var folders []string
+	groups := make(map[string]*imap.UIDSet)
+	for _, emailID := range emailIDs {
+		emailFolder, uid, err := ParseEmailID(emailID, folder)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, ok := groups[emailFolder]; !ok {
+			folders = append(folders, emailFolder)
+			groups[emailFolder] = &imap.UIDSet{}
+		}
+		groups[emailFolder].AddNum(uid)
+	}
+
+	return folders, groups, nil
+}
+
+// MoveEmails moves emails to the destination folder
+func (c *IMAPClient) MoveEmails(emailIDs []string, folder, destination string) (*TransferResult, error) {
+	return c.transferEmails(emailIDs, folder, destination, true)
+}
+
+// CopyEmails copies emails to the destination folder
+func (c *IMAPClient) CopyEmails(emailIDs []string, folder, destination string) (*TransferResult, error) {
+	return c.transferEmails(emailIDs, folder, destination, false)
+}
+
+// ArchiveEmails moves emails to the folder marked \Archive (or a commonly named archive folder)
+func (c *IMAPClient) ArchiveEmails(emailIDs []string, folder string) (*TransferResult, error) {
+	archive, err := c.findSpecialUseFolder(imap.MailboxAttrArchive, archiveFolderNames)
+	if err != nil {
+		return nil, err
+	}
+	if archive == "" {
+		return nil, fmt.Errorf("no archive folder found on the server, use move_email with an explicit destination")
+	}
+	return c.transferEmails(emailIDs, folder, archive, true)
+}
+
+// DeleteEmails moves emails to the folder marked \Trash. Emails already in Trash,
+// or all emails when permanent is set, are flagged \Deleted and expunged instead.
+func (c *IMAPClient) DeleteEmails(emailIDs []string, folder string, permanent bool) (*TransferResult, error) {
+	folders, groups, err := groupEmailIDsByFolder(emailIDs, folder)
+	if err != nil {
+		return nil, err
+	}
+
+	trash := ""
+	if !permanent {
+		trash, err = c.findSpecialUseFolder(imap.MailboxAttrTrash, trashFolderNames)
+		if err != nil {
+			return nil, err
+		}
+		if trash == "" {
+			return nil, fmt.Errorf("no Trash folder found on the server, set permanent to delete emails for good")
+		}
+	}
+
+	client, err := c.pool.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer c.pool.release(client)
+
+	result := &TransferResult{Destination: trash}
+	for _, emailFolder := range folders {
+		uidSet := *groups[emailFolder]
+
+		if _, err := selectFolder(client, emailFolder); err != nil {
+			return result, err
+		}
+
+		if trash == "" || emailFolder == trash {
+			expunged, err := expungeUIDs(client, uidSet)
+			if err != nil {
+				return result, err
+			}
+			if expunged {
+				result.Expunged = true
+			} else {
+				result.addFlaggedDeleted(emailFolder, uidSet)
+			}
+		} else {
+			data, expunged, err := moveUIDs(client, uidSet, trash)
+			if err != nil {
+				return result, err
+			}
+			result.addNewIDs(emailFolder, trash, data)
+			if !expunged {
+				result.addFlaggedDeleted(emailFolder, uidSet)
+			}
+		}
+
+		result.Count += countUIDs(uidSet)
+	}
+
+	return result, nil
+}
+
+// transferEmails moves or copies emails, grouped by their source folder, into destination
+func (c *IMAPClient) transferEmails(emailIDs []string, folder, destination string, move bool) (*TransferResult, error) {
+	if destination == "" {
+		return nil, fmt.Errorf("destination folder is required")
+	}
+
+	folders, groups, err := groupEmailIDsByFolder(emailIDs, folder)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := c.pool.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer c.pool.release(client)
+
+	result := &TransferResult{Destination: destination}
+	for _, emailFolder := range folders {
+		uidSet := *groups[emailFolder]
+
+		// Moving a message onto its own folder is a no-op
+		if move && emailFolder == destination {
+			continue
+		}
+
+		if _, err := selectFolder(client, emailFolder); err != nil {
+			return result, err
+		}
+
+		var data *imap.CopyData
+		expunged := true
+		if move {
+			data, expunged, err = moveUIDs(client, uidSet, destination)
+		} else {
+			data, err = client.Copy(uidSet, destination).Wait()
+			if err != nil {
+				err = fmt.Errorf("failed to copy emails to %s: %w", destination, err)
+			}
+		}
+		if err != nil {
+			return result, err
+		}
+
+		result.addNewIDs(emailFolder, destination, data)
+		if !expunged {
+			result.addFlaggedDeleted(emailFolder, uidSet)
+		}
+		result.Count += countUIDs(uidSet)
+	}
+
+	return result, nil
+}
+
+// addFlaggedDeleted records source emails that were flagged \Deleted but left in place
+func (r *TransferResult) addFlaggedDeleted(sourceFolder string, uidSet imap.UIDSet) {
+	uids, _ := uidSet.Nums()
+	for _, uid := range uids {
+		r.FlaggedDeleted = append(r.FlaggedDeleted, FormatEmailID(sourceFolder, uid))
+	}
+	r.Warning = noUIDExpungeWarning
+}
+
+// addNewIDs records source-to-destination ID mappings from COPYUID data, if the server sent any
+func (r *TransferResult) addNewIDs(sourceFolder, destination string, data *imap.CopyData) {
+	if data == nil {
+		return
+	}
+	sourceUIDs, ok := data.SourceUIDs.Nums()
+	if !ok {
+		return
+	}
+	destUIDs, ok := data.DestUIDs.Nums()
+	if !ok || len(sourceUIDs) != len(destUIDs) {
+		return
+	}
+
+	if r.NewIDs == nil {
+		r.NewIDs = make(map[string]string)
+	}
+	for i, uid := range sourceUIDs {
+		r.NewIDs[FormatEmailID(sourceFolder, uid)] = FormatEmailID(destination, destUIDs[i])
+	}
+}
+
+// moveUIDs moves messages from the selected folder using MOVE when the server supports it.
+// Otherwise it falls back to COPY, and only once the copy succeeded flags the originals
+// \Deleted and expunges them. expunged is false when the originals could only be flagged.
+func moveUIDs(client *imapclient.Client, uidSet imap.UIDSet, destination string) (*imap.CopyData, bool, error) {
+	if client.Caps().Has(imap.CapMove) {
+		data, err := client.Move(uidSet, destination).Wait()
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to move emails to %s: %w", destination, err)
+		}
+		copyData := &imap.CopyData{UIDValidity: data.UIDValidity}
+		if src, ok := data.SourceUIDs.(imap.UIDSet); ok {
+			copyData.SourceUIDs = src
+		}
+		if dst, ok := data.DestUIDs.(imap.UIDSet); ok {
+			copyData.DestUIDs = dst
+		}
+		return copyData, true, nil
+	}
+
+	copyData, err := client.Copy(uidSet, destination).Wait()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to copy emails to %s: %w", destination, err)
+	}
+	expunged, err := expungeUIDs(client, uidSet)
+	if err != nil {
+		return nil, false, err
+	}
+	return copyData, expunged, nil
+}
+
+// expungeUIDs flags messages in the selected folder \Deleted and expunges them with
+// UID EXPUNGE. Without UIDPLUS the messages are only flagged and expunged is false:
+// a plain EXPUNGE would also remove other messages flagged \Deleted in the folder.
+func expungeUIDs(client *imapclient.Client, uidSet imap.UIDSet) (bool, error) {
+	storeFlags := &imap.StoreFlags{
+		Op:     imap.StoreFlagsAdd,
+		Flags:  []imap.Flag{imap.FlagDeleted},
+		Silent: true,
+	}
+	if err := client.Store(uidSet, storeFlags, nil).Close(); err != nil {
+		return false, fmt.Errorf("failed to flag emails as deleted: %w", err)
+	}
+
+	if !client.Caps().Has(imap.CapUIDPlus) {
+		return false, nil
+	}
+	if err := client.UIDExpunge(uidSet).Close(); err != nil {
+		return false, fmt.Errorf("failed to expunge emails: %w", err)
+	}
+	return true, nil
+}
+
+// countUIDs returns the number of UIDs in a static UID set
+func countUIDs(uidSet imap.UIDSet) int {
+	uids, _ := uidSet.Nums()
+	return len(uids)
+}
+
+// findSpecialUseFolder returns the folder carrying the given SPECIAL-USE attribute,
+// falling back to the first existing folder from names (case-insensitive).
+// An empty name is returned if neither is found.
+func (c *IMAPClient) findSpecialUseFolder(attr imap.MailboxAttr, names []string) (string, error) {
+	client, err := c.pool.acquire()
+	if err != nil {
+		return "", err
+	}
+	defer c.pool.release(client)
+
+	return specialUseFolder(client, attr, names)
+}
+
+// specialUseFolder is findSpecialUseFolder on an already acquired session
+func specialUseFolder(client *imapclient.Client, attr imap.MailboxAttr, names []string) (string, error) {
+	listOptions := &imap.ListOptions{}
+	if client.Caps().Has(imap.CapSpecialUse) {
+		listOptions.ReturnSpecialUse = true
+	}
+
+	mailboxes, err := client.List("", "*", listOptions).Collect()
+	if err != nil {
+		return "", fmt.Errorf("failed to list folders: %w", err)
+	}
+
+	for _, mbox := range mailboxes {
+		for _, a := range mbox.Attrs {
+			if a == attr {
+				return mbox.Mailbox, nil
+			}
+		}
+	}
+
+	for _, name := range names {
+		for _, mbox := range mailboxes {
+			if strings.EqualFold(mbox.Mailbox, name) {
+				return mbox.Mailbox, nil
+			}
+		}
+	}
+
+	return "", nil
+}
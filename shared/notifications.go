@@ -2,15 +2,30 @@ package shared
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
 	"github.com/mark3labs/mcp-go/server"
 )
 
+const (
+	// idleReissueInterval is how often IDLE is restarted to stay under the 29-minute server timeout
+	idleReissueInterval = 25 * time.Minute
+	// idleRetryDelay is how long to poll after an IDLE connection drops before trying IDLE again
+	idleRetryDelay = 2 * time.Minute
+	// previewFetchBytes is how much of the message text is fetched to build a notification preview
+	previewFetchBytes = 2048
+)
+
+// errIdleNotSupported is returned by watchWithIdle when the server lacks the IDLE capability
+var errIdleNotSupported = errors.New("IMAP server does not support IDLE")
+
 // notificationBroadcaster interface for servers that support broadcasting to all clients
 type notificationBroadcaster interface {
 	SendNotificationToAllClients(method string, params map[string]any)
@@ -21,12 +36,18 @@ type EmailNotificationChecker struct {
 	config      *Config
 	imapClient  *IMAPClient
 	lastUID     uint32
+	uidValidity uint32
+	resume      bool   // keep lastUID on the next Start, see resumeFrom
+	catchUp     bool   // the next check announces emails missed while not running
+	catchUpUID  uint32 // the newest inbox email when catching up started, 0 if not known yet
+	state       *notificationState
 	mu          sync.Mutex
 	broadcaster notificationBroadcaster
 
 	// For starting/stopping the checker
 	running    atomic.Bool
 	cancelFunc context.CancelFunc
+	done       chan struct{} // closed when the checking goroutine exits
 	cancelMu   sync.Mutex
 	ctx        context.Context // parent context
 }
@@ -43,6 +64,12 @@ func NewEmailNotificationChecker(config *Config, mcpServer *server.MCPServer) *E
 		checker.broadcaster = broadcaster
 	}
 
+	state, err := openNotificationState(config.Notifications.StateFile)
+	if err != nil {
+		checker.logf("Warning: Could not read notification state, missed emails will not be announced: %v", err)
+	}
+	checker.state = state
+
 	return checker
 }
 
@@ -54,7 +81,7 @@ func (c *EmailNotificationChecker) SetContext(ctx context.Context) {
 // Start begins the background email checking goroutine
 func (c *EmailNotificationChecker) Start() {
 	if c.broadcaster == nil {
-		log.Println("Warning: Server does not support SendNotificationToAllClients, notifications disabled")
+		c.logf("Warning: Server does not support SendNotificationToAllClients, notifications disabled")
 		return
 	}
 
@@ -69,15 +96,14 @@ func (c *EmailNotificationChecker) Start() {
 		return // Double-check after acquiring lock
 	}
 
-	// Get initial latest UID
-	lastUID, err := c.imapClient.GetLatestUID()
-	if err != nil {
-		log.Printf("Warning: Could not get initial email UID: %v", err)
-		lastUID = 0
-	}
+	// Find where to start, unless continuing where a previous checker stopped
 	c.mu.Lock()
-	c.lastUID = lastUID
+	resume := c.resume
+	c.resume = false
 	c.mu.Unlock()
+	if !resume {
+		c.restoreState()
+	}
 
 	// Create cancellable context
 	parentCtx := c.ctx
@@ -86,29 +112,164 @@ func (c *EmailNotificationChecker) Start() {
 	}
 	ctx, cancel := context.WithCancel(parentCtx)
 	c.cancelFunc = cancel
+	done := make(chan struct{})
+	c.done = done
 
 	interval := time.Duration(c.config.Notifications.CheckIntervalSeconds) * time.Second
 	c.running.Store(true)
 
 	go func() {
-		log.Printf("Email notification checker started (interval: %v)", interval)
-		ticker := time.NewTicker(interval)
-		defer ticker.Stop()
+		c.logf("Email notification checker started (poll interval: %v)", interval)
+		defer close(done)
 		defer c.running.Store(false)
 
-		for {
-			select {
-			case <-ctx.Done():
-				log.Println("Email notification checker stopped")
+		c.run(ctx, interval)
+		c.logf("Email notification checker stopped")
+	}()
+}
+
+// run watches the inbox until ctx is cancelled, preferring IMAP IDLE and
+// falling back to polling when IDLE is unsupported or its connection drops
+func (c *EmailNotificationChecker) run(ctx context.Context, interval time.Duration) {
+	useIdle := !c.config.Notifications.DisableIdle
+
+	for {
+		if useIdle {
+			err := c.watchWithIdle(ctx)
+			if ctx.Err() != nil {
 				return
-			case <-ticker.C:
-				c.checkForNewEmails()
+			}
+			if errors.Is(err, errIdleNotSupported) {
+				c.logf("IMAP server does not support IDLE, falling back to polling")
+				useIdle = false
+			} else {
+				c.logf("IDLE session ended (%v), polling for %v before reconnecting", err, idleRetryDelay)
 			}
 		}
-	}()
+
+		// Poll forever without IDLE, otherwise only until the next IDLE attempt
+		pollFor := time.Duration(0)
+		if useIdle {
+			pollFor = idleRetryDelay
+		}
+		if !c.poll(ctx, interval, pollFor) {
+			return
+		}
+	}
+}
+
+// poll checks for new emails every interval. It returns false when ctx is
+// cancelled, or true once duration has elapsed (a zero duration polls until cancelled).
+func (c *EmailNotificationChecker) poll(ctx context.Context, interval, duration time.Duration) bool {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var deadline <-chan time.Time
+	if duration > 0 {
+		timer := time.NewTimer(duration)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-deadline:
+			return true
+		case <-ticker.C:
+			c.checkForNewEmails()
+		}
+	}
+}
+
+// watchWithIdle keeps a dedicated connection in IDLE on the inbox and checks
+// for new emails whenever the server reports a change in the message count.
+// It returns errIdleNotSupported if the server does not advertise IDLE, and
+// otherwise only returns when ctx is cancelled or the connection fails.
+func (c *EmailNotificationChecker) watchWithIdle(ctx context.Context) error {
+	mailboxChanged := make(chan struct{}, 1)
+	handler := &imapclient.UnilateralDataHandler{
+		Mailbox: func(data *imapclient.UnilateralDataMailbox) {
+			if data.NumMessages == nil {
+				return
+			}
+			select {
+			case mailboxChanged <- struct{}{}:
+			default:
+			}
+		},
+	}
+
+	client, err := c.imapClient.connect(handler)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	caps := client.Caps()
+	if !caps.Has(imap.CapIdle) && !caps.Has(imap.CapIMAP4rev2) {
+		return errIdleNotSupported
+	}
+
+	if _, err := selectFolder(client, DefaultFolder); err != nil {
+		return err
+	}
+
+	c.logf("Watching inbox with IMAP IDLE")
+
+	// Catch up on anything that arrived before IDLE started
+	c.checkForNewEmails()
+
+	for {
+		idleCmd, err := client.Idle()
+		if err != nil {
+			return fmt.Errorf("failed to start IDLE: %w", err)
+		}
+
+		idleDone := make(chan error, 1)
+		go func() {
+			idleDone <- idleCmd.Wait()
+		}()
+
+		// Servers may drop IDLE after 30 minutes, so re-issue it well before that
+		reissue := time.NewTimer(idleReissueInterval)
+		changed := false
+
+		select {
+		case <-ctx.Done():
+		case <-mailboxChanged:
+			changed = true
+		case <-reissue.C:
+		case err := <-idleDone:
+			reissue.Stop()
+			if err == nil {
+				err = fmt.Errorf("connection closed")
+			}
+			return fmt.Errorf("IDLE interrupted: %w", err)
+		}
+		reissue.Stop()
+
+		if err := idleCmd.Close(); err != nil {
+			return fmt.Errorf("failed to stop IDLE: %w", err)
+		}
+		if err := <-idleDone; err != nil {
+			return fmt.Errorf("IDLE failed: %w", err)
+		}
+
+		if ctx.Err() != nil {
+			client.Logout().Wait()
+			return ctx.Err()
+		}
+
+		if changed {
+			c.checkForNewEmails()
+		}
+	}
 }
 
-// Stop stops the background email checking goroutine
+// Stop stops the background email checking goroutine and waits for it to
+// exit, so that a check in progress has finished announcing its emails
 func (c *EmailNotificationChecker) Stop() {
 	c.cancelMu.Lock()
 	defer c.cancelMu.Unlock()
@@ -117,6 +278,102 @@ func (c *EmailNotificationChecker) Stop() {
 		c.cancelFunc()
 		c.cancelFunc = nil
 	}
+	if c.done != nil {
+		<-c.done
+		c.done = nil
+	}
+}
+
+// resumeFrom makes the next Start continue after the last email seen by a
+// checker of the same mailbox, so replacing a checker on config reload does
+// not skip emails that arrive in between. previous must already be stopped,
+// otherwise emails it is still announcing would be announced again.
+func (c *EmailNotificationChecker) resumeFrom(previous *EmailNotificationChecker) {
+	previous.mu.Lock()
+	lastUID, uidValidity := previous.lastUID, previous.uidValidity
+	catchUp, catchUpUID := previous.catchUp, previous.catchUpUID
+	previous.mu.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastUID, c.uidValidity = lastUID, uidValidity
+	c.catchUp, c.catchUpUID = catchUp, catchUpUID
+	c.resume = lastUID != 0
+}
+
+// stateKey identifies the checker's inbox in the notification state file
+func (c *EmailNotificationChecker) stateKey() string {
+	return mailboxKey(c.config) + "/" + DefaultFolder
+}
+
+// restoreState sets where the checker starts: after the last email announced
+// before the server stopped, when the state file has it and the inbox's
+// UIDVALIDITY is unchanged, and otherwise after the newest email in the inbox
+func (c *EmailNotificationChecker) restoreState() {
+	var saved mailboxState
+	hasSaved := false
+	if c.state != nil {
+		saved, hasSaved = c.state.get(c.stateKey())
+	}
+
+	uidValidity, latestUID, err := c.imapClient.GetFolderUIDs(DefaultFolder)
+
+	c.mu.Lock()
+	switch {
+	case err != nil && hasSaved:
+		c.logf("Warning: Could not get initial email UID, continuing from the saved state: %v", err)
+		c.lastUID, c.uidValidity = saved.LastUID, saved.UIDValidity
+		c.catchUp, c.catchUpUID = true, 0
+	case err != nil:
+		c.logf("Warning: Could not get initial email UID: %v", err)
+		c.lastUID, c.uidValidity, c.catchUp = 0, 0, false
+	case hasSaved && saved.UIDValidity == uidValidity:
+		// Only emails up to the newest one now arrived while not running
+		c.lastUID, c.uidValidity = saved.LastUID, uidValidity
+		c.catchUp, c.catchUpUID = latestUID > saved.LastUID, latestUID
+	default:
+		if hasSaved {
+			c.logf("Inbox UIDVALIDITY changed from %d to %d, emails missed while stopped cannot be identified", saved.UIDValidity, uidValidity)
+		}
+		c.lastUID, c.uidValidity, c.catchUp = latestUID, uidValidity, false
+	}
+	c.mu.Unlock()
+
+	c.saveState()
+}
+
+// resetState starts over after the newest email when the inbox's UIDVALIDITY changed
+func (c *EmailNotificationChecker) resetState() {
+	uidValidity, latestUID, err := c.imapClient.GetFolderUIDs(DefaultFolder)
+	if err != nil {
+		c.logf("Error getting the latest email UID: %v", err)
+		return
+	}
+
+	c.mu.Lock()
+	c.lastUID, c.uidValidity, c.catchUp = latestUID, uidValidity, false
+	c.mu.Unlock()
+
+	c.saveState()
+}
+
+// saveState writes the last announced email to the state file
+func (c *EmailNotificationChecker) saveState() {
+	if c.state == nil {
+		return
+	}
+
+	c.mu.Lock()
+	state := mailboxState{Account: c.config.AccountName(), UIDValidity: c.uidValidity, LastUID: c.lastUID}
+	c.mu.Unlock()
+
+	// Without a UIDVALIDITY the UID cannot be trusted after a restart
+	if state.UIDValidity == 0 {
+		return
+	}
+	if err := c.state.set(c.stateKey(), state); err != nil {
+		c.logf("Warning: Could not save notification state: %v", err)
+	}
 }
 
 // IsRunning returns whether the checker is currently running
@@ -124,26 +381,61 @@ func (c *EmailNotificationChecker) IsRunning() bool {
 	return c.running.Load()
 }
 
+// logf logs a message tagged with the checker's account
+func (c *EmailNotificationChecker) logf(format string, args ...any) {
+	log.Printf("[%s] "+format, append([]any{c.config.AccountName()}, args...)...)
+}
+
 // checkForNewEmails checks for new emails and sends notifications
 func (c *EmailNotificationChecker) checkForNewEmails() {
 	c.mu.Lock()
-	currentLastUID := c.lastUID
+	currentLastUID, uidValidity := c.lastUID, c.uidValidity
+	catchUp, catchUpUID := c.catchUp, c.catchUpUID
 	c.mu.Unlock()
 
-	log.Printf("Checking for new emails (since UID: %d)...", currentLastUID)
+	if catchUp && catchUpUID == 0 {
+		// The inbox could not be read on start, so catching up starts with this check
+		_, latestUID, err := c.imapClient.GetFolderUIDs(DefaultFolder)
+		if err != nil {
+			c.logf("Error checking for new emails: %v", err)
+			return
+		}
+		catchUpUID = latestUID
+	}
+
+	c.logf("Checking for new emails (since UID: %d)...", currentLastUID)
 
-	newEmails, err := c.imapClient.GetEmailsSinceUID(currentLastUID)
+	newEmails, err := c.imapClient.emailsSinceUID(DefaultFolder, uidValidity, currentLastUID)
+	var validityErr *UIDValidityError
+	if errors.As(err, &validityErr) {
+		c.logf("%v, continuing after the newest email", validityErr)
+		c.resetState()
+		return
+	}
 	if err != nil {
-		log.Printf("Error checking for new emails: %v", err)
+		c.logf("Error checking for new emails: %v", err)
 		return
 	}
 
-	log.Printf("Check complete: found %d new email(s)", len(newEmails))
+	c.logf("Check complete: found %d new email(s)", len(newEmails))
+
+	if catchUp {
+		c.mu.Lock()
+		c.catchUp, c.catchUpUID = false, 0
+		c.mu.Unlock()
+		missed, arrived := splitAtUID(newEmails, catchUpUID)
+		newEmails = append(c.limitReplay(missed), arrived...)
+	}
+	defer c.saveState()
 
 	for _, email := range newEmails {
 		// Update lastUID
-		var emailUID uint32
-		fmt.Sscanf(email.ID, "%d", &emailUID)
+		_, uid, err := ParseEmailID(email.ID, email.Folder)
+		if err != nil {
+			c.logf("Skipping email with unexpected ID %s: %v", email.ID, err)
+			continue
+		}
+		emailUID := uint32(uid)
 
 		c.mu.Lock()
 		if emailUID > c.lastUID {
@@ -151,46 +443,90 @@ func (c *EmailNotificationChecker) checkForNewEmails() {
 		}
 		c.mu.Unlock()
 
-		// Get email preview
-		detail, err := c.imapClient.GetEmailContents(email.ID)
+		// Get email preview from a partial peek so the email stays unread
+		text, err := c.imapClient.FetchPreviewText(email.ID, email.Folder, previewFetchBytes)
 		preview := ""
 		if err == nil {
-			preview = GetEmailPreview(detail.Body, 100)
+			preview = GetEmailPreview(text, 100)
 		}
 
-		log.Printf("New email received: ID=%s From=%s Subject=%s", email.ID, email.From, email.Subject)
+		c.logf("New email received: ID=%s From=%s Subject=%s", email.ID, email.From, email.Subject)
+
+		title := "New Email Received"
+		if c.config.AccountName() != DefaultAccountName {
+			title += " in " + c.config.AccountName()
+		}
 
 		// Broadcast notification to all connected clients
 		c.broadcaster.SendNotificationToAllClients("new_email", map[string]any{
-			"title":       "New Email Received. From: " + email.From + ", Subject: " + email.Subject,
+			"title":       title + ". From: " + email.From + ", Subject: " + email.Subject,
+			"account":     c.config.AccountName(),
 			"email_id":    email.ID,
+			"folder":      email.Folder,
 			"from":        email.From,
 			"subject":     email.Subject,
 			"received_at": email.Date.Format(time.RFC3339),
 			"preview":     preview,
+			"replayed":    catchUp && emailUID <= catchUpUID,
 		})
 	}
 }
 
-// ClientTracker tracks connected clients and manages the notification checker
+// splitAtUID splits emails into those with a UID up to uid and the newer ones
+func splitAtUID(emails []*Email, uid uint32) (upTo, after []*Email) {
+	for _, email := range emails {
+		if _, emailUID, err := ParseEmailID(email.ID, email.Folder); err == nil && uint32(emailUID) > uid {
+			after = append(after, email)
+		} else {
+			upTo = append(upTo, email)
+		}
+	}
+	return upTo, after
+}
+
+// limitReplay keeps the newest notifications.max_replay of the emails that
+// arrived while the checker was not running, and skips the older ones
+func (c *EmailNotificationChecker) limitReplay(missed []*Email) []*Email {
+	if len(missed) == 0 {
+		return missed
+	}
+	limit := max(c.config.Notifications.MaxReplay, 0)
+	if len(missed) <= limit {
+		c.logf("Announcing %d email(s) that arrived while not running", len(missed))
+		return missed
+	}
+
+	skipped := missed[:len(missed)-limit]
+	for _, email := range skipped {
+		if _, uid, err := ParseEmailID(email.ID, email.Folder); err == nil {
+			c.mu.Lock()
+			c.lastUID = max(c.lastUID, uint32(uid))
+			c.mu.Unlock()
+		}
+	}
+	c.logf("Announcing %d of %d email(s) that arrived while not running (notifications.max_replay)", limit, len(missed))
+	return missed[len(missed)-limit:]
+}
+
+// ClientTracker tracks connected clients and manages the notification checkers
 type ClientTracker struct {
 	clientCount atomic.Int32
-	checker     *EmailNotificationChecker
+	checkers    []*EmailNotificationChecker
 	mu          sync.Mutex
 }
 
 // NewClientTracker creates a new client tracker
-func NewClientTracker(checker *EmailNotificationChecker) *ClientTracker {
+func NewClientTracker(checkers ...*EmailNotificationChecker) *ClientTracker {
 	return &ClientTracker{
-		checker: checker,
+		checkers: checkers,
 	}
 }
 
-// SetChecker sets the notification checker (allows deferred initialization)
-func (t *ClientTracker) SetChecker(checker *EmailNotificationChecker) {
+// SetCheckers sets the notification checkers (allows deferred initialization)
+func (t *ClientTracker) SetCheckers(checkers ...*EmailNotificationChecker) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	t.checker = checker
+	t.checkers = checkers
 }
 
 // OnClientConnected is called when a client connects
@@ -201,9 +537,11 @@ func (t *ClientTracker) OnClientConnected() {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	// Start checker when first client connects
-	if count == 1 && t.checker != nil {
-		t.checker.Start()
+	// Start checkers when first client connects
+	if count == 1 {
+		for _, checker := range t.checkers {
+			checker.Start()
+		}
 	}
 }
 
@@ -215,9 +553,11 @@ func (t *ClientTracker) OnClientDisconnected() {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	// Stop checker when last client disconnects
-	if count == 0 && t.checker != nil {
-		t.checker.Stop()
+	// Stop checkers when last client disconnects
+	if count == 0 {
+		for _, checker := range t.checkers {
+			checker.Stop()
+		}
 	}
 }
 
@@ -241,20 +581,31 @@ func CreateSessionHooks(tracker *ClientTracker) *server.Hooks {
 	return hooks
 }
 
-// StartEmailNotificationChecker creates and starts the notification checker
+// newAccountCheckers creates one notification checker per configured account
+func newAccountCheckers(ctx context.Context, mcpServer *server.MCPServer, config *Config) []*EmailNotificationChecker {
+	var checkers []*EmailNotificationChecker
+	for _, account := range config.AccountConfigs() {
+		checker := NewEmailNotificationChecker(account, mcpServer)
+		checker.SetContext(ctx)
+		checkers = append(checkers, checker)
+	}
+	return checkers
+}
+
+// StartEmailNotificationCheckers creates and starts a notification checker for every account
 // For STDIO mode - starts immediately (single client always connected)
-func StartEmailNotificationChecker(ctx context.Context, mcpServer *server.MCPServer, config *Config) *EmailNotificationChecker {
-	checker := NewEmailNotificationChecker(config, mcpServer)
-	checker.SetContext(ctx)
-	checker.Start() // Start immediately for STDIO
-	return checker
+func StartEmailNotificationCheckers(ctx context.Context, mcpServer *server.MCPServer, config *Config) []*EmailNotificationChecker {
+	checkers := newAccountCheckers(ctx, mcpServer, config)
+	for _, checker := range checkers {
+		checker.Start() // Start immediately for STDIO
+	}
+	return checkers
 }
 
-// SetupHTTPNotificationChecker sets up the notification checker for HTTP mode
-// Returns the checker and tracker - checker is NOT started, it starts when first client connects
-func SetupHTTPNotificationChecker(ctx context.Context, mcpServer *server.MCPServer, config *Config) (*EmailNotificationChecker, *ClientTracker) {
-	checker := NewEmailNotificationChecker(config, mcpServer)
-	checker.SetContext(ctx)
-	tracker := NewClientTracker(checker)
-	return checker, tracker
+// SetupHTTPNotificationCheckers sets up the notification checkers for HTTP mode
+// Returns the checkers and tracker - checkers are NOT started, they start when first client connects
+func SetupHTTPNotificationCheckers(ctx context.Context, mcpServer *server.MCPServer, config *Config) ([]*EmailNotificationChecker, *ClientTracker) {
+	checkers := newAccountCheckers(ctx, mcpServer, config)
+	tracker := NewClientTracker(checkers...)
+	return checkers, tracker
 }
@@ -0,0 +1,314 @@
+package shared
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+func TestSplitAtUID(t *testing.T) {
+	emails := []*Email{
+		{ID: "INBOX:5", Folder: "INBOX"},
+		{ID: "INBOX:9", Folder: "INBOX"},
+		{ID: "INBOX:10", Folder: "INBOX"},
+		{ID: "INBOX:12", Folder: "INBOX"},
+	}
+
+	tests := []struct {
+		uid       uint32
+		wantUpTo  []string
+		wantAfter []string
+	}{
+		{0, nil, []string{"INBOX:5", "INBOX:9", "INBOX:10", "INBOX:12"}},
+		{9, []string{"INBOX:5", "INBOX:9"}, []string{"INBOX:10", "INBOX:12"}},
+		{12, []string{"INBOX:5", "INBOX:9", "INBOX:10", "INBOX:12"}, nil},
+	}
+
+	ids := func(emails []*Email) []string {
+		var ids []string
+		for _, email := range emails {
+			ids = append(ids, email.ID)
+		}
+		return ids
+	}
+
+	for _, tt := range tests {
+		upTo, after := splitAtUID(emails, tt.uid)
+		if !reflect.DeepEqual(ids(upTo), tt.wantUpTo) || !reflect.DeepEqual(ids(after), tt.wantAfter) {
+			t.Errorf("splitAtUID(%d) = %v, %v, want %v, %v", tt.uid, ids(upTo), ids(after), tt.wantUpTo, tt.wantAfter)
+		}
+	}
+}
+
+// recordingBroadcaster keeps the notifications a checker sends
+type recordingBroadcaster struct {
+	mu            sync.Mutex
+	notifications []map[string]any
+}
+
+func (b *recordingBroadcaster) SendNotificationToAllClients(method string, params map[string]any) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if method == "new_email" {
+		b.notifications = append(b.notifications, params)
+	}
+}
+
+// announced lists the subjects of the notified emails, marking replayed ones with "(replayed)"
+func (b *recordingBroadcaster) announced() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var subjects []string
+	for _, params := range b.notifications {
+		subject := params["subject"].(string)
+		if params["replayed"].(bool) {
+			subject += " (replayed)"
+		}
+		subjects = append(subjects, subject)
+	}
+	return subjects
+}
+
+func TestLimitReplay(t *testing.T) {
+	missed := []*Email{
+		{ID: "INBOX:3", Folder: "INBOX"},
+		{ID: "INBOX:4", Folder: "INBOX"},
+		{ID: "INBOX:7", Folder: "INBOX"},
+	}
+
+	tests := []struct {
+		name        string
+		maxReplay   int
+		missed      []*Email
+		want        []string
+		wantLastUID uint32
+	}{
+		{name: "all within the limit", maxReplay: 20, missed: missed, want: []string{"INBOX:3", "INBOX:4", "INBOX:7"}, wantLastUID: 2},
+		{name: "exactly the limit", maxReplay: 3, missed: missed, want: []string{"INBOX:3", "INBOX:4", "INBOX:7"}, wantLastUID: 2},
+		{name: "newest kept", maxReplay: 1, missed: missed, want: []string{"INBOX:7"}, wantLastUID: 4},
+		{name: "zero announces none", maxReplay: 0, missed: missed, wantLastUID: 7},
+		{name: "negative announces none", maxReplay: -1, missed: missed, wantLastUID: 7},
+		{name: "nothing missed", maxReplay: 20, wantLastUID: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{}
+			config.Notifications.MaxReplay = tt.maxReplay
+			checker := &EmailNotificationChecker{config: config, lastUID: 2}
+
+			var got []string
+			for _, email := range checker.limitReplay(tt.missed) {
+				got = append(got, email.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("limitReplay() = %v, want %v", got, tt.want)
+			}
+			// Skipped emails are never announced, so the checker moves past them
+			if checker.lastUID != tt.wantLastUID {
+				t.Errorf("lastUID = %d, want %d", checker.lastUID, tt.wantLastUID)
+			}
+		})
+	}
+}
+
+func TestNotificationStateRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notification_state.json")
+	state, err := openNotificationState(path)
+	if err != nil {
+		t.Fatalf("openNotificationState() error: %v", err)
+	}
+	if _, ok := state.get("imap.example.com:993/me/INBOX"); ok {
+		t.Fatal("a new state file has saved mailboxes")
+	}
+
+	// Two checkers of different accounts share the file
+	work := mailboxState{Account: "work", UIDValidity: 7, LastUID: 42}
+	home := mailboxState{Account: "home", UIDValidity: 3, LastUID: 5}
+	if err := state.set("imap.example.com:993/me/INBOX", work); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.set("imap.example.org:993/me/INBOX", home); err != nil {
+		t.Fatal(err)
+	}
+	if again, err := openNotificationState(path); err != nil || again != state {
+		t.Errorf("openNotificationState() of the same file = %p, %v, want the shared store %p", again, err, state)
+	}
+
+	// A restart reads the file again
+	forgetNotificationState(t, path)
+	restored, err := openNotificationState(path)
+	if err != nil {
+		t.Fatalf("openNotificationState() after restart error: %v", err)
+	}
+	for key, want := range map[string]mailboxState{
+		"imap.example.com:993/me/INBOX": work,
+		"imap.example.org:993/me/INBOX": home,
+	} {
+		got, ok := restored.get(key)
+		if !ok || got.Account != want.Account || got.UIDValidity != want.UIDValidity || got.LastUID != want.LastUID || got.UpdatedAt.IsZero() {
+			t.Errorf("restored %s = %+v, %v, want %+v", key, got, ok, want)
+		}
+	}
+}
+
+func TestNotificationStateInvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notification_state.json")
+	if err := os.WriteFile(path, []byte("{not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openNotificationState(path); err == nil {
+		t.Error("openNotificationState() error = nil, want a parse error")
+	}
+}
+
+// forgetNotificationState drops the shared store of a state file, as if the process restarted
+func forgetNotificationState(t *testing.T, path string) {
+	t.Helper()
+
+	key, err := filepath.Abs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notificationStatesMu.Lock()
+	delete(notificationStates, key)
+	notificationStatesMu.Unlock()
+}
+
+func TestCheckerReplaysMissedEmails(t *testing.T) {
+	tests := []struct {
+		name string
+		// saved is the state file's UIDVALIDITY and last UID, nil for no state file
+		saved     *[2]uint32
+		maxReplay int
+		// before arrive before the checker starts, after between the start and the first check
+		before []string
+		after  []string
+		want   []string
+	}{
+		{
+			name:      "first start announces only new emails",
+			maxReplay: 20,
+			before:    []string{"old 1", "old 2"},
+			after:     []string{"new"},
+			want:      []string{"new"},
+		},
+		{
+			name:      "restart replays emails missed while stopped",
+			saved:     &[2]uint32{1, 1},
+			maxReplay: 20,
+			before:    []string{"seen", "missed 1", "missed 2"},
+			after:     []string{"new"},
+			want:      []string{"missed 1 (replayed)", "missed 2 (replayed)", "new"},
+		},
+		{
+			name:      "max_replay keeps the newest missed emails",
+			saved:     &[2]uint32{1, 1},
+			maxReplay: 2,
+			before:    []string{"seen", "missed 1", "missed 2", "missed 3"},
+			after:     []string{"new"},
+			want:      []string{"missed 2 (replayed)", "missed 3 (replayed)", "new"},
+		},
+		{
+			name:      "negative max_replay replays nothing",
+			saved:     &[2]uint32{1, 1},
+			maxReplay: -1,
+			before:    []string{"seen", "missed"},
+			after:     []string{"new"},
+			want:      []string{"new"},
+		},
+		{
+			name:      "changed UIDVALIDITY discards the saved state",
+			saved:     &[2]uint32{99, 1},
+			maxReplay: 20,
+			before:    []string{"seen", "unknown"},
+			after:     []string{"new"},
+			want:      []string{"new"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestIMAPServer(t)
+			config := server.config
+			config.Notifications.MaxReplay = tt.maxReplay
+			config.Notifications.StateFile = filepath.Join(t.TempDir(), "notification_state.json")
+
+			key := mailboxKey(config) + "/" + DefaultFolder
+			if tt.saved != nil {
+				state, err := openNotificationState(config.Notifications.StateFile)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := state.set(key, mailboxState{UIDValidity: tt.saved[0], LastUID: tt.saved[1]}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, subject := range tt.before {
+				server.appendMessage(t, DefaultFolder, subject)
+			}
+
+			checker := NewEmailNotificationChecker(config, nil)
+			broadcaster := &recordingBroadcaster{}
+			checker.broadcaster = broadcaster
+			checker.restoreState()
+
+			var lastUID imap.UID
+			for _, subject := range tt.after {
+				lastUID = server.appendMessage(t, DefaultFolder, subject)
+			}
+			checker.checkForNewEmails()
+
+			if got := broadcaster.announced(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("announced %q, want %q", got, tt.want)
+			}
+
+			// The state file now continues after the newest email
+			forgetNotificationState(t, config.Notifications.StateFile)
+			state, err := openNotificationState(config.Notifications.StateFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			saved, ok := state.get(key)
+			if !ok || saved.LastUID != uint32(lastUID) || saved.UIDValidity != 1 {
+				t.Errorf("saved state = %+v, %v, want UIDVALIDITY 1 and last UID %d", saved, ok, lastUID)
+			}
+
+			// Nothing is announced twice
+			checker.checkForNewEmails()
+			if got := broadcaster.announced(); len(got) != len(tt.want) {
+				t.Errorf("second check announced %q", got[len(tt.want):])
+			}
+		})
+	}
+}
+
+func TestCheckerResetsOnUIDValidityChange(t *testing.T) {
+	server := newTestIMAPServer(t)
+	config := server.config
+	config.Notifications.StateFile = filepath.Join(t.TempDir(), "notification_state.json")
+	server.appendMessage(t, DefaultFolder, "before")
+
+	checker := NewEmailNotificationChecker(config, nil)
+	broadcaster := &recordingBroadcaster{}
+	checker.broadcaster = broadcaster
+	checker.restoreState()
+
+	// The inbox is re-created while the checker runs: its UIDs start over
+	server.recreateInbox(t)
+	server.appendMessage(t, DefaultFolder, "after 1")
+	checker.checkForNewEmails()
+	if got := broadcaster.announced(); len(got) != 0 {
+		t.Errorf("check after the UIDVALIDITY change announced %q, want nothing", got)
+	}
+
+	server.appendMessage(t, DefaultFolder, "after 2")
+	checker.checkForNewEmails()
+	if got := broadcaster.announced(); !reflect.DeepEqual(got, []string{"after 2"}) {
+		t.Errorf("announced %q, want after 2", got)
+	}
+}
@@ -0,0 +1,99 @@
+package shared
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// notificationStates holds one store per state file, shared by the checkers of
+// all accounts and by the checkers that replace them on config reload
+var (
+	notificationStates   = make(map[string]*notificationState)
+	notificationStatesMu sync.Mutex
+)
+
+// mailboxState is how far the notification checker of a folder has got
+type mailboxState struct {
+	Account     string    `json:"account"`
+	UIDValidity uint32    `json:"uid_validity"`
+	LastUID     uint32    `json:"last_uid"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// notificationStateFile is the format of the notification state file
+type notificationStateFile struct {
+	// Mailboxes are keyed by server, port, user and folder, see mailboxKey
+	Mailboxes map[string]mailboxState `json:"mailboxes"`
+}
+
+// notificationState persists the last notified email of every account and
+// folder, so that emails arriving while the server is down are announced later
+type notificationState struct {
+	mu        sync.Mutex
+	path      string
+	mailboxes map[string]mailboxState
+}
+
+// openNotificationState returns the store of the state file at path, reading it on first use
+func openNotificationState(path string) (*notificationState, error) {
+	key, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	notificationStatesMu.Lock()
+	defer notificationStatesMu.Unlock()
+
+	if state, ok := notificationStates[key]; ok {
+		return state, nil
+	}
+
+	var file notificationStateFile
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	}
+	if file.Mailboxes == nil {
+		file.Mailboxes = make(map[string]mailboxState)
+	}
+
+	state := &notificationState{path: path, mailboxes: file.Mailboxes}
+	notificationStates[key] = state
+	return state, nil
+}
+
+// get returns the saved state of a folder
+func (s *notificationState) get(key string) (mailboxState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.mailboxes[key]
+	return state, ok
+}
+
+// set saves the state of a folder, writing the state file right away
+func (s *notificationState) set(key string, state mailboxState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if previous, ok := s.mailboxes[key]; ok && previous.UIDValidity == state.UIDValidity &&
+		previous.LastUID == state.LastUID && previous.Account == state.Account {
+		return nil
+	}
+	state.UpdatedAt = time.Now()
+	s.mailboxes[key] = state
+
+	data, err := json.MarshalIndent(notificationStateFile{Mailboxes: s.mailboxes}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, append(data, '\n'))
+}
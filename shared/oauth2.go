@@ -0,0 +1,275 @@
+package shared
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-sasl"
+)
+
+// OAuth2 SASL mechanisms, usable as imap.auth_mechanism and smtp.auth_mechanism
+const (
+	AuthXOAuth2     = "xoauth2"
+	AuthOAuthBearer = "oauthbearer"
+)
+
+const (
+	// oauth2RefreshSkew refreshes access tokens this long before they expire
+	oauth2RefreshSkew = 2 * time.Minute
+	// oauth2RequestTimeout bounds a token endpoint request
+	oauth2RequestTimeout = 30 * time.Second
+)
+
+// OAuth2Settings configures the OAuth2 refresh-token flow shared by IMAP and SMTP
+type OAuth2Settings struct {
+	// TokenURL is the token endpoint, e.g. https://oauth2.googleapis.com/token
+	// or https://login.microsoftonline.com/<tenant>/oauth2/v2.0/token
+	TokenURL     string   `json:"token_url"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	RefreshToken string   `json:"refresh_token"`
+	Scopes       []string `json:"scopes"`
+	// TokenCache is the file the current access token (and a rotated refresh token) is kept in
+	TokenCache string `json:"token_cache"`
+}
+
+// oauth2Token is an access token as kept in the token cache
+type oauth2Token struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Expiry       time.Time `json:"expiry"`
+}
+
+// valid reports whether the token can still be used without refreshing
+func (t *oauth2Token) valid() bool {
+	return t != nil && t.AccessToken != "" && time.Now().Add(oauth2RefreshSkew).Before(t.Expiry)
+}
+
+// tokenSource hands out access tokens, refreshing them shortly before they expire
+type tokenSource struct {
+	mu       sync.Mutex
+	settings OAuth2Settings
+	token    *oauth2Token
+	loaded   bool
+	client   *http.Client
+}
+
+var (
+	tokenSourcesMu sync.Mutex
+	// tokenSources are shared by all clients of the same OAuth2 settings
+	tokenSources = make(map[string]*tokenSource)
+)
+
+// getTokenSource returns the token source for the config's OAuth2 settings
+func getTokenSource(config *Config) *tokenSource {
+	settings := config.OAuth2
+	key := strings.Join([]string{settings.TokenURL, settings.ClientID, settings.RefreshToken, settings.TokenCache}, "\x00")
+
+	tokenSourcesMu.Lock()
+	defer tokenSourcesMu.Unlock()
+
+	if source, ok := tokenSources[key]; ok {
+		return source
+	}
+	source := &tokenSource{
+		settings: settings,
+		client:   &http.Client{Timeout: oauth2RequestTimeout},
+	}
+	tokenSources[key] = source
+	return source
+}
+
+// AccessToken returns a valid access token, from memory, the token cache or the token endpoint
+func (s *tokenSource) AccessToken() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.loaded {
+		s.loaded = true
+		if token, err := s.readCache(); err == nil {
+			s.token = token
+		}
+	}
+
+	if !s.token.valid() {
+		token, err := s.refresh()
+		if err != nil {
+			return "", err
+		}
+		s.token = token
+		// The token is valid either way; without the cache it is only refreshed again after a restart
+		if err := s.writeCache(token); err != nil {
+			log.Printf("Failed to write OAuth2 token cache: %v", err)
+		}
+	}
+
+	return s.token.AccessToken, nil
+}
+
+// invalidate drops the current access token, e.g. after the server rejected it,
+// so the next AccessToken call refreshes it
+func (s *tokenSource) invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != nil {
+		s.token.AccessToken = ""
+	}
+}
+
+// refresh exchanges the refresh token for a new access token
+func (s *tokenSource) refresh() (*oauth2Token, error) {
+	refreshToken := s.settings.RefreshToken
+	// Providers that rotate refresh tokens invalidate the configured one after first use
+	if s.token != nil && s.token.RefreshToken != "" {
+		refreshToken = s.token.RefreshToken
+	}
+
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+		"client_id":     {s.settings.ClientID},
+	}
+	if s.settings.ClientSecret != "" {
+		form.Set("client_secret", s.settings.ClientSecret)
+	}
+	if len(s.settings.Scopes) > 0 {
+		form.Set("scope", strings.Join(s.settings.Scopes, " "))
+	}
+
+	resp, err := s.client.PostForm(s.settings.TokenURL, form)
+	if err != nil {
+		return nil, fmt.Errorf("failed to refresh OAuth2 token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to refresh OAuth2 token: %w", err)
+	}
+
+	var result struct {
+		AccessToken      string `json:"access_token"`
+		RefreshToken     string `json:"refresh_token"`
+		ExpiresIn        int64  `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to refresh OAuth2 token: unexpected response (HTTP %d)", resp.StatusCode)
+	}
+	if result.Error != "" {
+		return nil, fmt.Errorf("failed to refresh OAuth2 token: %s %s", result.Error, result.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK || result.AccessToken == "" {
+		return nil, fmt.Errorf("failed to refresh OAuth2 token: no access token in response (HTTP %d)", resp.StatusCode)
+	}
+
+	token := &oauth2Token{
+		AccessToken:  result.AccessToken,
+		RefreshToken: result.RefreshToken,
+		Expiry:       time.Now().Add(time.Hour),
+	}
+	if result.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+	}
+	if token.RefreshToken == "" && s.token != nil {
+		token.RefreshToken = s.token.RefreshToken
+	}
+	return token, nil
+}
+
+// readCache loads the token cache file
+func (s *tokenSource) readCache() (*oauth2Token, error) {
+	if s.settings.TokenCache == "" {
+		return nil, os.ErrNotExist
+	}
+	data, err := os.ReadFile(s.settings.TokenCache)
+	if err != nil {
+		return nil, err
+	}
+	var token oauth2Token
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// writeCache stores the token in the cache file, readable only by the owner
+func (s *tokenSource) writeCache(token *oauth2Token) error {
+	if s.settings.TokenCache == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(token, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated cache
+	return writeFileAtomic(s.settings.TokenCache, data)
+}
+
+// oauth2SASLClient returns the SASL client for an OAuth2 mechanism with a fresh access token
+func oauth2SASLClient(config *Config, mechanism, username, host string, port int) (sasl.Client, error) {
+	token, err := getTokenSource(config).AccessToken()
+	if err != nil {
+		return nil, err
+	}
+
+	if mechanism == AuthOAuthBearer {
+		return sasl.NewOAuthBearerClient(&sasl.OAuthBearerOptions{
+			Username: username,
+			Token:    token,
+			Host:     host,
+			Port:     port,
+		}), nil
+	}
+	return &xoauth2Client{username: username, token: token}, nil
+}
+
+// xoauth2Client implements Google's and Microsoft's XOAUTH2 SASL mechanism
+type xoauth2Client struct {
+	username string
+	token    string
+}
+
+func (a *xoauth2Client) Start() (string, []byte, error) {
+	return "XOAUTH2", []byte("user=" + a.username + "\x01auth=Bearer " + a.token + "\x01\x01"), nil
+}
+
+// Next receives the server's JSON error details when the token was rejected
+func (a *xoauth2Client) Next(challenge []byte) ([]byte, error) {
+	return nil, fmt.Errorf("XOAUTH2 authentication failed: %s", challenge)
+}
+
+// saslSMTPAuth adapts a SASL client to net/smtp authentication
+type saslSMTPAuth struct {
+	client sasl.Client
+	host   string
+}
+
+func (a *saslSMTPAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, fmt.Errorf("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, fmt.Errorf("wrong host name")
+	}
+	return a.client.Start()
+}
+
+func (a *saslSMTPAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	return a.client.Next(fromServer)
+}
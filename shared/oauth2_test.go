@@ -0,0 +1,308 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testTokenServer is a stand-in OAuth2 token endpoint that records refresh requests
+type testTokenServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []url.Values
+	// rotate makes the server hand out a new refresh token with every access token
+	rotate bool
+}
+
+func newTestTokenServer(t *testing.T) *testTokenServer {
+	ts := &testTokenServer{}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ts.mu.Lock()
+		ts.requests = append(ts.requests, r.PostForm)
+		n := len(ts.requests)
+		ts.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if r.PostForm.Get("refresh_token") == "revoked" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_grant","error_description":"Token has been revoked"}`)
+			return
+		}
+
+		response := map[string]any{
+			"access_token": fmt.Sprintf("access-%d", n),
+			"expires_in":   3600,
+			"token_type":   "Bearer",
+		}
+		if ts.rotate {
+			response["refresh_token"] = fmt.Sprintf("refresh-%d", n)
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// requestCount returns the number of refresh requests received so far
+func (ts *testTokenServer) requestCount() int {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return len(ts.requests)
+}
+
+// lastRequest returns the form of the latest refresh request
+func (ts *testTokenServer) lastRequest() url.Values {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.requests[len(ts.requests)-1]
+}
+
+func newTestTokenSource(ts *testTokenServer, cache string) *tokenSource {
+	return &tokenSource{
+		settings: OAuth2Settings{
+			TokenURL:     ts.URL,
+			ClientID:     "client",
+			ClientSecret: "secret",
+			RefreshToken: "refresh-0",
+			Scopes:       []string{"https://mail.google.com/"},
+			TokenCache:   cache,
+		},
+		client: ts.Client(),
+	}
+}
+
+func writeTestTokenCache(t *testing.T, path string, token oauth2Token) {
+	data, err := json.Marshal(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestTokenCache(t *testing.T, path string) oauth2Token {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read token cache: %v", err)
+	}
+	var token oauth2Token
+	if err := json.Unmarshal(data, &token); err != nil {
+		t.Fatalf("invalid token cache: %v", err)
+	}
+	return token
+}
+
+func TestTokenSourceRefreshRequest(t *testing.T) {
+	ts := newTestTokenServer(t)
+	source := newTestTokenSource(ts, "")
+
+	token, err := source.AccessToken()
+	if err != nil {
+		t.Fatalf("AccessToken() error: %v", err)
+	}
+	if token != "access-1" {
+		t.Errorf("AccessToken() = %q, want access-1", token)
+	}
+
+	form := ts.lastRequest()
+	want := map[string]string{
+		"grant_type":    "refresh_token",
+		"refresh_token": "refresh-0",
+		"client_id":     "client",
+		"client_secret": "secret",
+		"scope":         "https://mail.google.com/",
+	}
+	for key, value := range want {
+		if got := form.Get(key); got != value {
+			t.Errorf("request %s = %q, want %q", key, got, value)
+		}
+	}
+
+	// The token stays valid for an hour, so it is not refreshed again
+	if _, err := source.AccessToken(); err != nil {
+		t.Fatalf("AccessToken() error: %v", err)
+	}
+	if n := ts.requestCount(); n != 1 {
+		t.Errorf("token endpoint called %d times, want 1", n)
+	}
+}
+
+func TestTokenSourceRefreshError(t *testing.T) {
+	ts := newTestTokenServer(t)
+	source := newTestTokenSource(ts, "")
+	source.settings.RefreshToken = "revoked"
+
+	_, err := source.AccessToken()
+	if err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Errorf("AccessToken() error = %v, want invalid_grant", err)
+	}
+}
+
+func TestTokenSourcePersistsRotatedRefreshToken(t *testing.T) {
+	ts := newTestTokenServer(t)
+	ts.rotate = true
+	cache := filepath.Join(t.TempDir(), "token.json")
+	source := newTestTokenSource(ts, cache)
+
+	if _, err := source.AccessToken(); err != nil {
+		t.Fatalf("AccessToken() error: %v", err)
+	}
+	cached := readTestTokenCache(t, cache)
+	if cached.AccessToken != "access-1" || cached.RefreshToken != "refresh-1" {
+		t.Errorf("cache = %+v, want access-1/refresh-1", cached)
+	}
+	if info, err := os.Stat(cache); err == nil && info.Mode().Perm()&0077 != 0 {
+		t.Errorf("token cache mode = %v, want no group/other access", info.Mode().Perm())
+	}
+
+	// A new process starts from the cache and refreshes with the rotated token
+	restarted := newTestTokenSource(ts, cache)
+	if token, err := restarted.AccessToken(); err != nil || token != "access-1" {
+		t.Fatalf("AccessToken() = %q, %v, want the cached access-1", token, err)
+	}
+	restarted.invalidate()
+	if _, err := restarted.AccessToken(); err != nil {
+		t.Fatalf("AccessToken() error: %v", err)
+	}
+	if got := ts.lastRequest().Get("refresh_token"); got != "refresh-1" {
+		t.Errorf("refresh used refresh_token %q, want the rotated refresh-1", got)
+	}
+	if cached := readTestTokenCache(t, cache); cached.RefreshToken != "refresh-2" {
+		t.Errorf("cached refresh token = %q, want refresh-2", cached.RefreshToken)
+	}
+}
+
+func TestTokenSourceUsesCache(t *testing.T) {
+	tests := []struct {
+		name        string
+		expiresIn   time.Duration
+		wantToken   string
+		wantRequest bool
+	}{
+		{"valid", time.Hour, "cached", false},
+		{"within refresh skew", oauth2RefreshSkew / 2, "access-1", true},
+		{"expired", -time.Minute, "access-1", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestTokenServer(t)
+			cache := filepath.Join(t.TempDir(), "token.json")
+			writeTestTokenCache(t, cache, oauth2Token{
+				AccessToken:  "cached",
+				RefreshToken: "refresh-cached",
+				Expiry:       time.Now().Add(tt.expiresIn),
+			})
+			source := newTestTokenSource(ts, cache)
+
+			token, err := source.AccessToken()
+			if err != nil {
+				t.Fatalf("AccessToken() error: %v", err)
+			}
+			if token != tt.wantToken {
+				t.Errorf("AccessToken() = %q, want %q", token, tt.wantToken)
+			}
+			if got := ts.requestCount() > 0; got != tt.wantRequest {
+				t.Errorf("token endpoint called = %v, want %v", got, tt.wantRequest)
+			}
+			if tt.wantRequest {
+				if got := ts.lastRequest().Get("refresh_token"); got != "refresh-cached" {
+					t.Errorf("refresh used refresh_token %q, want the cached refresh-cached", got)
+				}
+				if cached := readTestTokenCache(t, cache); cached.RefreshToken != "refresh-cached" {
+					t.Errorf("cached refresh token = %q, want it kept when the server does not rotate it", cached.RefreshToken)
+				}
+			}
+		})
+	}
+}
+
+func TestTokenSourceCacheWriteFailure(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	ts := newTestTokenServer(t)
+	cache := filepath.Join(t.TempDir(), "missing", "token.json")
+	source := newTestTokenSource(ts, cache)
+
+	token, err := source.AccessToken()
+	if err != nil || token != "access-1" {
+		t.Fatalf("AccessToken() = %q, %v, want access-1 despite the cache failure", token, err)
+	}
+	if !strings.Contains(logged.String(), "Failed to write OAuth2 token cache") {
+		t.Errorf("log = %q, want the cache write failure", logged.String())
+	}
+
+	// The token is kept in memory, so it is not refreshed again
+	if token, err := source.AccessToken(); err != nil || token != "access-1" || ts.requestCount() != 1 {
+		t.Errorf("second AccessToken() = %q, %v after %d refreshes, want access-1 after 1", token, err, ts.requestCount())
+	}
+}
+
+func TestTokenSourceInvalidate(t *testing.T) {
+	ts := newTestTokenServer(t)
+	source := newTestTokenSource(ts, "")
+
+	first, err := source.AccessToken()
+	if err != nil {
+		t.Fatalf("AccessToken() error: %v", err)
+	}
+	source.invalidate()
+	second, err := source.AccessToken()
+	if err != nil {
+		t.Fatalf("AccessToken() error: %v", err)
+	}
+
+	if first == second {
+		t.Errorf("AccessToken() after invalidate returned the same token %q", second)
+	}
+	if n := ts.requestCount(); n != 2 {
+		t.Errorf("token endpoint called %d times, want 2", n)
+	}
+}
+
+func TestXOAuth2InitialResponse(t *testing.T) {
+	ts := newTestTokenServer(t)
+	config := &Config{OAuth2: OAuth2Settings{
+		TokenURL:     ts.URL,
+		ClientID:     "xoauth2-test",
+		RefreshToken: "refresh-0",
+	}}
+
+	client, err := oauth2SASLClient(config, AuthXOAuth2, "user@example.com", "imap.example.com", 993)
+	if err != nil {
+		t.Fatalf("oauth2SASLClient() error: %v", err)
+	}
+	mechanism, ir, err := client.Start()
+	if err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+	if mechanism != "XOAUTH2" {
+		t.Errorf("mechanism = %q, want XOAUTH2", mechanism)
+	}
+	if want := "user=user@example.com\x01auth=Bearer access-1\x01\x01"; string(ir) != want {
+		t.Errorf("initial response = %q, want %q", ir, want)
+	}
+
+	if _, err := client.Next([]byte(`{"status":"400"}`)); err == nil {
+		t.Error("Next() after a rejected token should fail")
+	}
+}
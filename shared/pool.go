@@ -0,0 +1,203 @@
+package shared
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// noopCheckAfter is how long a session may sit idle before it is verified with NOOP on reuse
+const noopCheckAfter = 30 * time.Second
+
+// acquireTimeout is how long acquire waits for a connection when all of them are in use
+const acquireTimeout = 2 * time.Minute
+
+// imapPools holds one connection pool per IMAP account, shared by all IMAPClient instances
+var (
+	imapPools   = make(map[string]*imapPool)
+	imapPoolsMu sync.Mutex
+)
+
+// imapPool keeps a bounded set of authenticated IMAP sessions for reuse.
+// At most maxSize sessions are open at once (in use plus idle), so callers
+// wait in acquire rather than exceed the provider's connection limit.
+type imapPool struct {
+	settings imapPoolSettings
+	dial     func() (*imapclient.Client, error)
+	slots    chan struct{}
+	wait     time.Duration // how long acquire waits for a free slot
+
+	mu     sync.Mutex
+	idle   []*pooledSession
+	closed bool
+}
+
+// pooledSession is an idle authenticated session waiting to be reused
+type pooledSession struct {
+	client   *imapclient.Client
+	lastUsed time.Time
+}
+
+// imapPoolSettings are the settings a pool's sessions are opened with
+type imapPoolSettings struct {
+	IMAP   IMAPSettings
+	TLS    TLSSettings
+	OAuth2 OAuth2Settings
+}
+
+// mailboxKey identifies the IMAP mailbox of a config by server, port and user
+func mailboxKey(config *Config) string {
+	return fmt.Sprintf("%s:%d/%s", config.IMAP.Server, config.IMAP.Port, config.IMAP.Username)
+}
+
+// getIMAPPool returns the shared pool for the account described by config,
+// creating it if needed. A pool opened with other settings for the same
+// mailbox, e.g. an old password, is replaced so no session uses them again.
+func getIMAPPool(c *IMAPClient) *imapPool {
+	key := mailboxKey(c.config)
+	settings := imapPoolSettings{IMAP: c.config.IMAP, TLS: c.config.TLS, OAuth2: c.config.OAuth2}
+
+	imapPoolsMu.Lock()
+	stale, ok := imapPools[key]
+	if ok && reflect.DeepEqual(stale.settings, settings) {
+		imapPoolsMu.Unlock()
+		return stale
+	}
+
+	maxSize := c.config.IMAP.MaxConnections
+	if maxSize <= 0 {
+		maxSize = 1
+	}
+	pool := &imapPool{
+		settings: settings,
+		dial:     c.Connect,
+		slots:    make(chan struct{}, maxSize),
+		wait:     acquireTimeout,
+	}
+	imapPools[key] = pool
+	imapPoolsMu.Unlock()
+
+	if ok {
+		stale.close()
+	}
+	return pool
+}
+
+// acquire returns an authenticated session, reusing an idle one when it is still alive.
+// It fails when no connection frees up within the pool's wait time.
+// The caller must hand the session back with release.
+func (p *imapPool) acquire() (*imapclient.Client, error) {
+	timer := time.NewTimer(p.wait)
+	defer timer.Stop()
+	select {
+	case p.slots <- struct{}{}:
+	case <-timer.C:
+		return nil, fmt.Errorf("all %d IMAP connections are busy, try again later", cap(p.slots))
+	}
+
+	for {
+		p.mu.Lock()
+		n := len(p.idle)
+		if n == 0 {
+			p.mu.Unlock()
+			break
+		}
+		session := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+
+		if p.isAlive(session) {
+			return session.client, nil
+		}
+		session.client.Close()
+	}
+
+	client, err := p.dial()
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	return client, nil
+}
+
+// release returns a session to the pool; sessions whose connection has dropped are discarded
+func (p *imapPool) release(client *imapclient.Client) {
+	defer func() { <-p.slots }()
+
+	if client.State() == imap.ConnStateLogout {
+		client.Close()
+		return
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		// The pool was dropped by a config reload while the session was in use
+		p.mu.Unlock()
+		client.Logout().Wait()
+		client.Close()
+		return
+	}
+	p.idle = append(p.idle, &pooledSession{client: client, lastUsed: time.Now()})
+	p.mu.Unlock()
+}
+
+// isAlive checks a pooled session before reuse, issuing NOOP if it has been idle for a while
+func (p *imapPool) isAlive(session *pooledSession) bool {
+	if session.client.State() == imap.ConnStateLogout {
+		return false
+	}
+	if time.Since(session.lastUsed) < noopCheckAfter {
+		return true
+	}
+	if err := session.client.Noop().Wait(); err != nil {
+		log.Printf("Dropping dead IMAP session: %v", err)
+		return false
+	}
+	return true
+}
+
+// close logs out and closes all idle sessions; sessions in use are closed when released
+func (p *imapPool) close() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	p.mu.Unlock()
+
+	for _, session := range idle {
+		session.client.Logout().Wait()
+		session.client.Close()
+	}
+}
+
+// CloseIMAPConnections closes all pooled IMAP sessions, for use on shutdown
+func CloseIMAPConnections() {
+	imapPoolsMu.Lock()
+	pools := make([]*imapPool, 0, len(imapPools))
+	for _, pool := range imapPools {
+		pools = append(pools, pool)
+	}
+	imapPoolsMu.Unlock()
+
+	for _, pool := range pools {
+		pool.close()
+	}
+}
+
+// resetIMAPPools drops all pools, so that clients created afterwards connect
+// with the current settings and credentials
+func resetIMAPPools() {
+	imapPoolsMu.Lock()
+	pools := imapPools
+	imapPools = make(map[string]*imapPool)
+	imapPoolsMu.Unlock()
+
+	for _, pool := range pools {
+		pool.close()
+	}
+}
@@ -0,0 +1,174 @@
+package shared
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// fakeIMAPSession returns a client connected in memory to a server that
+// greets it and answers every command with status ("OK" or "NO").
+// An empty status closes the connection right after the greeting.
+func fakeIMAPSession(t *testing.T, status string) *imapclient.Client {
+	t.Helper()
+
+	clientConn, serverConn := net.Pipe()
+	go func() {
+		defer serverConn.Close()
+		fmt.Fprint(serverConn, "* OK ready\r\n")
+		if status == "" {
+			return
+		}
+		scanner := bufio.NewScanner(serverConn)
+		for scanner.Scan() {
+			tag, _, _ := strings.Cut(scanner.Text(), " ")
+			fmt.Fprintf(serverConn, "%s %s done\r\n", tag, status)
+		}
+	}()
+
+	client := imapclient.New(clientConn, nil)
+	t.Cleanup(func() { client.Close() })
+	if err := client.WaitGreeting(); err != nil {
+		t.Fatalf("no greeting: %v", err)
+	}
+	if status == "" {
+		for deadline := time.Now().Add(time.Second); client.State() != imap.ConnStateLogout; {
+			if time.Now().After(deadline) {
+				t.Fatal("session did not notice the closed connection")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	return client
+}
+
+// newTestPool returns a pool of size sessions that dials with dial
+func newTestPool(size int, dial func() (*imapclient.Client, error)) *imapPool {
+	return &imapPool{
+		dial:  dial,
+		slots: make(chan struct{}, size),
+		wait:  50 * time.Millisecond,
+	}
+}
+
+func TestIMAPPoolReleasesSlotOnDialError(t *testing.T) {
+	dialErr := errors.New("connection refused")
+	pool := newTestPool(1, func() (*imapclient.Client, error) { return nil, dialErr })
+
+	// Every attempt reaches the dialer instead of waiting for the slot of a failed one
+	for i := 0; i < 3; i++ {
+		if _, err := pool.acquire(); !errors.Is(err, dialErr) {
+			t.Fatalf("acquire() attempt %d error = %v, want the dial error", i+1, err)
+		}
+	}
+}
+
+func TestIMAPPoolBusy(t *testing.T) {
+	dials := 0
+	pool := newTestPool(1, func() (*imapclient.Client, error) {
+		dials++
+		return fakeIMAPSession(t, "OK"), nil
+	})
+
+	client, err := pool.acquire()
+	if err != nil {
+		t.Fatalf("acquire() error: %v", err)
+	}
+	if _, err := pool.acquire(); err == nil || !strings.Contains(err.Error(), "all 1 IMAP connections are busy") {
+		t.Fatalf("acquire() with all connections in use error = %v, want busy", err)
+	}
+
+	pool.release(client)
+	reused, err := pool.acquire()
+	if err != nil {
+		t.Fatalf("acquire() after release error: %v", err)
+	}
+	if reused != client || dials != 1 {
+		t.Errorf("acquire() after release dialed %d time(s), want the released session reused", dials)
+	}
+	pool.release(reused)
+}
+
+func TestIMAPPoolDiscardsDeadSessions(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     string
+		idleFor    time.Duration
+		wantReused bool
+	}{
+		{name: "recently used", status: "OK", wantReused: true},
+		{name: "answers NOOP", status: "OK", idleFor: time.Hour, wantReused: true},
+		{name: "fails NOOP", status: "NO", idleFor: time.Hour},
+		{name: "connection closed", status: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fresh := fakeIMAPSession(t, "OK")
+			pool := newTestPool(2, func() (*imapclient.Client, error) { return fresh, nil })
+			pooled := fakeIMAPSession(t, tt.status)
+			pool.idle = []*pooledSession{{client: pooled, lastUsed: time.Now().Add(-tt.idleFor)}}
+
+			client, err := pool.acquire()
+			if err != nil {
+				t.Fatalf("acquire() error: %v", err)
+			}
+			if reused := client == pooled; reused != tt.wantReused {
+				t.Errorf("pooled session reused = %v, want %v", reused, tt.wantReused)
+			}
+			if len(pool.idle) != 0 {
+				t.Errorf("%d session(s) left idle, want none", len(pool.idle))
+			}
+		})
+	}
+}
+
+func TestIMAPPoolReleaseDiscardsLoggedOutSession(t *testing.T) {
+	pool := newTestPool(1, nil)
+	pool.slots <- struct{}{}
+
+	pool.release(fakeIMAPSession(t, ""))
+	if len(pool.idle) != 0 || len(pool.slots) != 0 {
+		t.Errorf("after release: %d idle session(s), %d slot(s) taken, want none", len(pool.idle), len(pool.slots))
+	}
+}
+
+func TestGetIMAPPoolRebuildsOnSettingsChange(t *testing.T) {
+	t.Cleanup(resetIMAPPools)
+
+	config := func(password string) *Config {
+		config := &Config{}
+		config.IMAP.Server = "pool-test.example.com"
+		config.IMAP.Port = 993
+		config.IMAP.Username = "me"
+		config.IMAP.Password = password
+		config.IMAP.MaxConnections = 2
+		return config
+	}
+
+	first := NewIMAPClient(config("old"))
+	if same := NewIMAPClient(config("old")); same.pool != first.pool {
+		t.Error("clients with the same settings got different pools")
+	}
+
+	changed := NewIMAPClient(config("new"))
+	if changed.pool == first.pool {
+		t.Fatal("a client with a new password reused the pool of the old one")
+	}
+	first.pool.mu.Lock()
+	closed := first.pool.closed
+	first.pool.mu.Unlock()
+	if !closed {
+		t.Error("the pool of the old settings was not closed")
+	}
+	if again := NewIMAPClient(config("new")); again.pool != changed.pool {
+		t.Error("clients with the new settings got different pools")
+	}
+}
@@ -0,0 +1,149 @@
+package shared
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// configWatchInterval is how often the config file is checked for changes
+const configWatchInterval = 2 * time.Second
+
+// ConfigReloader swaps in a new configuration when the config file changes or
+// the process receives SIGHUP, without dropping client sessions
+type ConfigReloader struct {
+	ctx       context.Context
+	path      string // as given to LoadConfig
+	mcpServer *server.MCPServer
+
+	mu       sync.Mutex // serializes reloads
+	config   atomic.Pointer[Config]
+	checkers []*EmailNotificationChecker
+}
+
+// NewConfigReloader creates a reloader for the config loaded from path and the
+// notification checkers started for it. Checkers of reloaded configs use ctx.
+func NewConfigReloader(ctx context.Context, path string, config *Config, mcpServer *server.MCPServer, checkers []*EmailNotificationChecker) *ConfigReloader {
+	r := &ConfigReloader{
+		ctx:       ctx,
+		path:      path,
+		mcpServer: mcpServer,
+		checkers:  checkers,
+	}
+	r.config.Store(config)
+	return r
+}
+
+// Config returns the current configuration
+func (r *ConfigReloader) Config() *Config {
+	return r.config.Load()
+}
+
+// Reload loads and checks the configuration again and swaps it in: tools are
+// registered again with fresh descriptions, pooled IMAP sessions are dropped so
+// changed servers and credentials take effect, and notification checkers are
+// replaced. An invalid configuration is rejected and the current one kept.
+func (r *ConfigReloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	config, err := LoadConfig(r.path)
+	if err != nil {
+		return err
+	}
+	if err := ValidateConnections(config); err != nil {
+		return err
+	}
+
+	if r.config.Load().HTTP != config.HTTP {
+		log.Println("Note: http.host and http.port changes take effect after a restart")
+	}
+
+	resetIMAPPools()
+	RegisterTools(r.mcpServer, config)
+	r.config.Store(config)
+	r.replaceCheckers(config)
+
+	log.Printf("Configuration reloaded (%d account(s))", len(config.AccountConfigs()))
+	return nil
+}
+
+// replaceCheckers stops the current notification checkers and starts one per
+// account of config, continuing after the last email seen in unchanged mailboxes
+func (r *ConfigReloader) replaceCheckers(config *Config) {
+	previous := make(map[string]*EmailNotificationChecker)
+	running := false
+	for _, checker := range r.checkers {
+		running = running || checker.IsRunning()
+		checker.Stop()
+		previous[mailboxKey(checker.config)] = checker
+	}
+
+	r.checkers = newAccountCheckers(r.ctx, r.mcpServer, config)
+	for _, checker := range r.checkers {
+		if old, ok := previous[mailboxKey(checker.config)]; ok {
+			checker.resumeFrom(old)
+		}
+		if running {
+			checker.Start()
+		}
+	}
+}
+
+// Watch reloads the configuration whenever the config file changes or the
+// process receives SIGHUP, until the reloader's context is cancelled
+func (r *ConfigReloader) Watch() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(configWatchInterval)
+	defer ticker.Stop()
+
+	path := r.Config().path
+	lastState := configFileState(path)
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-hup:
+			log.Println("Received SIGHUP, reloading configuration")
+			r.reloadAndLog()
+		case <-ticker.C:
+			if path == "" {
+				continue
+			}
+			state := configFileState(path)
+			if state == lastState {
+				continue
+			}
+			lastState = state
+			log.Printf("%s changed, reloading configuration", path)
+			r.reloadAndLog()
+		}
+	}
+}
+
+// reloadAndLog reloads the configuration, logging why a reload was rejected
+func (r *ConfigReloader) reloadAndLog() {
+	if err := r.Reload(); err != nil {
+		log.Printf("Configuration reload rejected, keeping the current configuration: %v", err)
+	}
+}
+
+// configFileState identifies a version of the config file by modification time and size
+func configFileState(path string) [2]int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return [2]int64{}
+	}
+	return [2]int64{info.ModTime().UnixNano(), info.Size()}
+}
@@ -0,0 +1,105 @@
+package shared
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// testCheckerConfig returns a config for notification checkers that never
+// reach an IMAP server: IDLE is off and the poll interval is long
+func testCheckerConfig(t *testing.T, username string) *Config {
+	t.Helper()
+
+	config := &Config{}
+	config.IMAP.Server = "imap.example.com"
+	config.IMAP.Port = 993
+	config.IMAP.Username = username
+	config.Notifications.DisableIdle = true
+	config.Notifications.CheckIntervalSeconds = 3600
+	config.Notifications.StateFile = filepath.Join(t.TempDir(), "notification_state.json")
+	return config
+}
+
+func TestReplaceCheckersResumesMailbox(t *testing.T) {
+	tests := []struct {
+		name         string
+		username     string
+		running      bool
+		wantResume   bool
+		wantLastUID  uint32
+		wantCatchUID uint32
+	}{
+		{name: "same mailbox, stopped", username: "me", wantResume: true, wantLastUID: 42, wantCatchUID: 50},
+		{name: "same mailbox, running", username: "me", running: true, wantResume: true, wantLastUID: 42, wantCatchUID: 50},
+		{name: "other mailbox", username: "someone-else"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := server.NewMCPServer("test", "1.0")
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			old := NewEmailNotificationChecker(testCheckerConfig(t, "me"), s)
+			old.SetContext(ctx)
+			old.lastUID, old.uidValidity = 42, 7
+			old.catchUp, old.catchUpUID = true, 50
+			if tt.running {
+				old.resume = true
+				old.Start()
+				if !old.IsRunning() {
+					t.Fatal("checker did not start")
+				}
+			}
+
+			r := NewConfigReloader(ctx, "", testCheckerConfig(t, "me"), s, []*EmailNotificationChecker{old})
+			r.replaceCheckers(testCheckerConfig(t, tt.username))
+			defer func() {
+				for _, checker := range r.checkers {
+					checker.Stop()
+				}
+			}()
+
+			if old.IsRunning() {
+				t.Error("previous checker still running after the replacement")
+			}
+			if len(r.checkers) != 1 {
+				t.Fatalf("got %d checkers, want 1", len(r.checkers))
+			}
+			checker := r.checkers[0]
+			if checker.IsRunning() != tt.running {
+				t.Errorf("new checker running = %v, want %v", checker.IsRunning(), tt.running)
+			}
+
+			checker.mu.Lock()
+			defer checker.mu.Unlock()
+			// A started checker has already consumed the resume flag
+			if !tt.running && checker.resume != tt.wantResume {
+				t.Errorf("new checker resume = %v, want %v", checker.resume, tt.wantResume)
+			}
+			if checker.lastUID != tt.wantLastUID || checker.catchUpUID != tt.wantCatchUID {
+				t.Errorf("new checker at UID %d (catch-up to %d), want %d (catch-up to %d)",
+					checker.lastUID, checker.catchUpUID, tt.wantLastUID, tt.wantCatchUID)
+			}
+		})
+	}
+}
+
+func TestCheckerStopWaitsForExit(t *testing.T) {
+	checker := NewEmailNotificationChecker(testCheckerConfig(t, "me"), server.NewMCPServer("test", "1.0"))
+	checker.resume = true
+	checker.Start()
+	if !checker.IsRunning() {
+		t.Fatal("checker did not start")
+	}
+
+	checker.Stop()
+	if checker.IsRunning() {
+		t.Error("checker still running when Stop returned")
+	}
+	// Stopping again is a no-op
+	checker.Stop()
+}
@@ -0,0 +1,228 @@
+package shared
+
+import (
+	"fmt"
+	"html"
+	"net/mail"
+	"strings"
+)
+
+// maxReferences caps the References header of replies, keeping the thread root and the most recent IDs
+const maxReferences = 20
+
+// BuildReply composes a reply to the original email.
+// The reply goes to the original Reply-To (or From) address; with replyAll the
+// original To and Cc recipients are added, minus our own addresses. The reply is
+// sent from the identity the original was addressed to. An empty bodyFormat selects
+// that identity's body format. The identity's signature follows the new text; when
+// quote is set, the original body is quoted below them.
+func BuildReply(config *Config, original *EmailDetail, body, bodyFormat string, replyAll, quote bool) *OutgoingEmail {
+	var to []string
+	if config.IsOwnAddress(original.From) {
+		// Replying to our own sent message continues the conversation with its recipients
+		to = original.To
+	} else if len(original.ReplyTo) > 0 {
+		to = original.ReplyTo
+	} else {
+		to = []string{original.From}
+	}
+
+	reply := &OutgoingEmail{
+		Subject:         replySubject(original.Subject),
+		InReplyTo:       original.MessageID,
+		References:      replyReferences(original),
+		AppendSignature: true,
+	}
+
+	seen := make(map[string]bool)
+	for _, identity := range config.SenderIdentities() {
+		seen[addressKey(identity.Address)] = true
+	}
+	for _, address := range append(append([]string{}, original.To...), original.CC...) {
+		if reply.From == "" && config.IsOwnAddress(address) {
+			reply.From = addressKey(address)
+		}
+	}
+
+	if bodyFormat == "" {
+		bodyFormat = config.defaultBodyFormat(reply.From)
+	}
+	reply.BodyFormat = bodyFormat
+
+	reply.To = appendUniqueAddresses(nil, to, seen)
+	if replyAll {
+		reply.Cc = appendUniqueAddresses(nil, original.To, seen)
+		reply.Cc = appendUniqueAddresses(reply.Cc, original.CC, seen)
+	}
+
+	// A reply to our own message with no other recipients goes back to ourselves
+	if len(reply.To) == 0 {
+		reply.To = []string{original.From}
+	}
+
+	reply.Body = body
+	if quote {
+		reply.Quoted = quoteOriginal(original, bodyFormat)
+	}
+
+	return reply
+}
+
+// BuildForward composes a forward of the original email to new recipients,
+// with the original headers and body below the new text and the default identity's
+// signature. An empty bodyFormat selects the default identity's body format.
+// Attachments are added by the caller.
+func BuildForward(config *Config, original *EmailDetail, to []string, body, bodyFormat string) *OutgoingEmail {
+	if bodyFormat == "" {
+		bodyFormat = config.defaultBodyFormat("")
+	}
+	return &OutgoingEmail{
+		To:              to,
+		Subject:         forwardSubject(original.Subject),
+		Body:            body,
+		Quoted:          forwardedOriginal(original, bodyFormat),
+		BodyFormat:      bodyFormat,
+		References:      replyReferences(original),
+		AppendSignature: true,
+	}
+}
+
+// defaultBodyFormat returns the body format of the identity sending as from, or "text"
+func (c *Config) defaultBodyFormat(from string) string {
+	if identity, err := c.Identity(from); err == nil && identity.BodyFormat != "" {
+		return identity.BodyFormat
+	}
+	return "text"
+}
+
+// replySubject adds "Re: " unless the subject already starts with a reply prefix
+func replySubject(subject string) string {
+	if hasSubjectPrefix(subject, "re", "aw", "sv", "antw") {
+		return subject
+	}
+	return "Re: " + subject
+}
+
+// forwardSubject adds "Fwd: " unless the subject already starts with a forward prefix
+func forwardSubject(subject string) string {
+	if hasSubjectPrefix(subject, "fwd", "fw", "wg") {
+		return subject
+	}
+	return "Fwd: " + subject
+}
+
+// hasSubjectPrefix reports whether subject starts with one of the prefixes followed by a colon
+func hasSubjectPrefix(subject string, prefixes ...string) bool {
+	lower := strings.ToLower(strings.TrimSpace(subject))
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(lower, prefix+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// replyReferences returns the References for a reply: the original References plus its Message-ID
+func replyReferences(original *EmailDetail) []string {
+	references := append([]string{}, original.References...)
+	if len(references) == 0 && len(original.InReplyTo) > 0 {
+		references = append(references, original.InReplyTo...)
+	}
+	if original.MessageID != "" {
+		references = append(references, original.MessageID)
+	}
+
+	if len(references) > maxReferences {
+		references = append(references[:1], references[len(references)-maxReferences+1:]...)
+	}
+	return references
+}
+
+// appendUniqueAddresses appends addresses not yet in seen, recording them in seen
+func appendUniqueAddresses(list, addresses []string, seen map[string]bool) []string {
+	for _, address := range addresses {
+		key := addressKey(address)
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		list = append(list, address)
+	}
+	return list
+}
+
+// addressKey returns the lower-cased bare address of "Name <addr>" or "addr"
+func addressKey(address string) string {
+	if parsed, err := mail.ParseAddress(address); err == nil {
+		return strings.ToLower(parsed.Address)
+	}
+	return strings.ToLower(strings.TrimSpace(address))
+}
+
+// sameAddress compares two addresses, ignoring display names and case
+func sameAddress(a, b string) bool {
+	return addressKey(a) != "" && addressKey(a) == addressKey(b)
+}
+
+// originalText returns the original body as plain text
+func originalText(original *EmailDetail) string {
+	text := original.Body
+	if strings.HasPrefix(original.ContentType, "text/html") {
+		text = strings.TrimSpace(htmlToText(text))
+	}
+	return strings.ReplaceAll(text, "\r\n", "\n")
+}
+
+// quoteOriginal returns the original body as a quotation in the given body format
+func quoteOriginal(original *EmailDetail, bodyFormat string) string {
+	attribution := fmt.Sprintf("On %s, %s wrote:", original.Date.Format("Mon, 2 Jan 2006 at 15:04"), original.From)
+
+	if strings.ToLower(bodyFormat) == "html" {
+		quoted := original.Body
+		if !strings.HasPrefix(original.ContentType, "text/html") {
+			quoted = strings.ReplaceAll(html.EscapeString(originalText(original)), "\n", "<br>\n")
+		}
+		return fmt.Sprintf("<div>%s</div>\n<blockquote style=\"margin:0 0 0 .8ex;border-left:1px solid #ccc;padding-left:1ex\">\n%s\n</blockquote>",
+			html.EscapeString(attribution), quoted)
+	}
+
+	// "> " quoting reads naturally in plain text and renders as a blockquote in markdown
+	lines := strings.Split(strings.TrimRight(originalText(original), "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight("> "+line, " ")
+	}
+	return attribution + "\n" + strings.Join(lines, "\n")
+}
+
+// forwardedOriginal returns the original headers and body in the given body format
+func forwardedOriginal(original *EmailDetail, bodyFormat string) string {
+	headers := []string{
+		"From: " + original.From,
+		"Date: " + original.Date.Format("Mon, 2 Jan 2006 at 15:04"),
+		"Subject: " + original.Subject,
+		"To: " + strings.Join(original.To, ", "),
+	}
+	if len(original.CC) > 0 {
+		headers = append(headers, "Cc: "+strings.Join(original.CC, ", "))
+	}
+
+	switch strings.ToLower(bodyFormat) {
+	case "html":
+		forwarded := original.Body
+		if !strings.HasPrefix(original.ContentType, "text/html") {
+			forwarded = strings.ReplaceAll(html.EscapeString(originalText(original)), "\n", "<br>\n")
+		}
+		for i, header := range headers {
+			headers[i] = html.EscapeString(header)
+		}
+		return fmt.Sprintf("<div>---------- Forwarded message ---------<br>\n%s</div>\n<br>\n%s",
+			strings.Join(headers, "<br>\n"), forwarded)
+	case "markdown":
+		// Hard line breaks keep the header block on separate lines
+		return fmt.Sprintf("---------- Forwarded message ---------  \n%s\n\n%s",
+			strings.Join(headers, "  \n"), originalText(original))
+	default:
+		return fmt.Sprintf("---------- Forwarded message ---------\n%s\n\n%s",
+			strings.Join(headers, "\n"), originalText(original))
+	}
+}
@@ -0,0 +1,203 @@
+package shared
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+func TestFormatAddress(t *testing.T) {
+	tests := []struct {
+		name string
+		addr imap.Address
+		want string
+	}{
+		{"bare", imap.Address{Mailbox: "john", Host: "x.com"}, "john@x.com"},
+		{"plain name", imap.Address{Name: "John Doe", Mailbox: "john", Host: "x.com"}, "John Doe <john@x.com>"},
+		{"comma", imap.Address{Name: "Doe, John", Mailbox: "john", Host: "x.com"}, `"Doe, John" <john@x.com>`},
+		{"quote", imap.Address{Name: `John "JD" Doe`, Mailbox: "john", Host: "x.com"}, `"John \"JD\" Doe" <john@x.com>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatAddress(tt.addr); got != tt.want {
+				t.Errorf("formatAddress() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildReplyToQuotedSender(t *testing.T) {
+	config := &Config{MyEmail: "me@example.com"}
+	original := &EmailDetail{
+		MessageID: "orig@x.com",
+		From:      formatAddress(imap.Address{Name: "Doe, John", Mailbox: "john", Host: "x.com"}),
+		To:        []string{"me@example.com", "jane@x.com"},
+		CC:        []string{"bob@x.com"},
+		Subject:   "Plans",
+	}
+
+	reply := BuildReply(config, original, "Sounds good", "", true, false)
+
+	to, err := config.ResolveRecipients(reply.To)
+	if err != nil {
+		t.Fatalf("ResolveRecipients(To) error: %v", err)
+	}
+	if len(to) != 1 || to[0].Address != "john@x.com" || to[0].Name != "Doe, John" {
+		t.Errorf("To = %v, want Doe, John <john@x.com>", to)
+	}
+
+	cc, err := config.ResolveRecipients(reply.Cc)
+	if err != nil {
+		t.Fatalf("ResolveRecipients(Cc) error: %v", err)
+	}
+	if len(cc) != 2 || cc[0].Address != "jane@x.com" || cc[1].Address != "bob@x.com" {
+		t.Errorf("Cc = %v, want jane@x.com, bob@x.com", cc)
+	}
+
+	if reply.From != "me@example.com" {
+		t.Errorf("From = %q, want me@example.com", reply.From)
+	}
+	if reply.BodyFormat != "text" {
+		t.Errorf("BodyFormat = %q, want text", reply.BodyFormat)
+	}
+}
+
+func TestBuildReplyUsesIdentityBodyFormat(t *testing.T) {
+	config := &Config{
+		MyEmail: "me@example.com",
+		Identities: []Identity{
+			{Address: "support@example.com", BodyFormat: "markdown"},
+		},
+	}
+	original := &EmailDetail{
+		From: "customer@x.com",
+		To:   []string{"support@example.com"},
+	}
+
+	reply := BuildReply(config, original, "Hello", "", false, true)
+	if reply.From != "support@example.com" {
+		t.Errorf("From = %q, want support@example.com", reply.From)
+	}
+	if reply.BodyFormat != "markdown" {
+		t.Errorf("BodyFormat = %q, want markdown", reply.BodyFormat)
+	}
+
+	if reply := BuildReply(config, original, "Hello", "html", false, true); reply.BodyFormat != "html" {
+		t.Errorf("explicit BodyFormat = %q, want html", reply.BodyFormat)
+	}
+
+	if forward := BuildForward(config, original, []string{"a@x.com"}, "", ""); forward.BodyFormat != "text" {
+		t.Errorf("forward BodyFormat = %q, want text", forward.BodyFormat)
+	}
+}
+
+func TestReplyAndForwardSignature(t *testing.T) {
+	config := &Config{
+		MyEmail: "jane@example.com",
+		Identities: []Identity{
+			{Address: "jane@example.com", Signature: "Jane"},
+		},
+	}
+	original := &EmailDetail{
+		MessageID:   "orig@x.com",
+		From:        "john@example.org",
+		To:          []string{"jane@example.com"},
+		Subject:     "Plans",
+		Date:        time.Date(2024, 5, 6, 7, 8, 0, 0, time.UTC),
+		Body:        "Shall we meet?",
+		ContentType: "text/plain",
+	}
+
+	tests := []struct {
+		name  string
+		email *OutgoingEmail
+		// wantText and wantHTML list fragments that must appear in this order
+		wantText []string
+		wantHTML []string
+	}{
+		{
+			name:     "quoted text reply",
+			email:    BuildReply(config, original, "Yes", "", false, true),
+			wantText: []string{"Yes\n\n-- \nJane\n\nOn Mon, 6 May 2024 at 07:08, john@example.org wrote:\n> Shall we meet?"},
+		},
+		{
+			name:     "reply without quote",
+			email:    BuildReply(config, original, "Yes", "", false, false),
+			wantText: []string{"Yes\n\n-- \nJane\n"},
+		},
+		{
+			name:     "quoted html reply",
+			email:    BuildReply(config, original, "<p>Yes</p>", "html", false, true),
+			wantText: []string{"Yes", "-- \nJane", "wrote:", "Shall we meet?"},
+			wantHTML: []string{"<p>Yes</p>", `<div class="signature">`, "<blockquote", "Shall we meet?"},
+		},
+		{
+			name:     "quoted markdown reply",
+			email:    BuildReply(config, original, "**Yes**", "markdown", false, true),
+			wantText: []string{"**Yes**", "-- \nJane", "> Shall we meet?"},
+			wantHTML: []string{"<strong>Yes</strong>", `<div class="signature">`, "<blockquote>"},
+		},
+		{
+			name:     "forward with a note",
+			email:    BuildForward(config, original, []string{"bob@example.org"}, "FYI", ""),
+			wantText: []string{"FYI\n\n-- \nJane\n\n---------- Forwarded message ---------\nFrom: john@example.org", "Shall we meet?"},
+		},
+		{
+			name:     "forward without a note",
+			email:    BuildForward(config, original, []string{"bob@example.org"}, "", ""),
+			wantText: []string{"-- \nJane\n\n---------- Forwarded message ---------"},
+		},
+		{
+			name:     "html forward without a note",
+			email:    BuildForward(config, original, []string{"bob@example.org"}, "", "html"),
+			wantText: []string{"-- \nJane", "Forwarded message", "Shall we meet?"},
+			wantHTML: []string{`<div class="signature">`, "Forwarded message", "Shall we meet?"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !tt.email.AppendSignature {
+				t.Fatal("AppendSignature is not set")
+			}
+			identity, err := config.Identity(tt.email.From)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, parts := parseComposed(t, composeTestMessage(t, identity, tt.email))
+
+			var text, html string
+			for _, part := range parts {
+				switch part.contentType {
+				case "text/plain":
+					text = strings.ReplaceAll(part.body, "\r\n", "\n")
+				case "text/html":
+					html = strings.ReplaceAll(part.body, "\r\n", "\n")
+				}
+			}
+			checkInOrder(t, "text", text, tt.wantText)
+			checkInOrder(t, "html", html, tt.wantHTML)
+			if tt.wantHTML == nil && html != "" {
+				t.Errorf("unexpected html part %q", html)
+			}
+		})
+	}
+}
+
+// checkInOrder reports an error unless body contains the fragments in order
+func checkInOrder(t *testing.T, name, body string, fragments []string) {
+	t.Helper()
+
+	rest := body
+	for _, fragment := range fragments {
+		i := strings.Index(rest, fragment)
+		if i < 0 {
+			t.Errorf("%s part %q does not contain %q after the previous fragments", name, body, fragment)
+			return
+		}
+		rest = rest[i+len(fragment):]
+	}
+}
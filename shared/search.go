@@ -0,0 +1,223 @@
+package shared
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// SearchQuery holds structured search criteria for SearchEmails.
+// Empty/zero fields are ignored; nil boolean pointers mean "don't care".
+type SearchQuery struct {
+	Folder        string
+	From          string
+	To            string
+	Cc            string
+	Subject       string
+	Body          string
+	Text          string
+	Since         time.Time
+	Before        time.Time
+	Seen          *bool
+	Flagged       *bool
+	Answered      *bool
+	Larger        int64
+	Smaller       int64
+	HasAttachment *bool
+	Limit         int
+	Offset        int
+}
+
+// SearchResult is a page of search results
+type SearchResult struct {
+	Emails  []*Email `json:"emails"`
+	Count   int      `json:"count"`
+	Total   int      `json:"total"`
+	Offset  int      `json:"offset"`
+	HasMore bool     `json:"has_more"`
+}
+
+// searchCriteria translates a SearchQuery into IMAP SEARCH criteria
+func (q *SearchQuery) searchCriteria() *imap.SearchCriteria {
+	criteria := &imap.SearchCriteria{}
+
+	addHeader := func(key, value string) {
+		if value != "" {
+			criteria.Header = append(criteria.Header, imap.SearchCriteriaHeaderField{Key: key, Value: value})
+		}
+	}
+	addHeader("From", q.From)
+	addHeader("To", q.To)
+	addHeader("Cc", q.Cc)
+	addHeader("Subject", q.Subject)
+
+	if q.Body != "" {
+		criteria.Body = append(criteria.Body, q.Body)
+	}
+	if q.Text != "" {
+		criteria.Text = append(criteria.Text, q.Text)
+	}
+
+	criteria.Since = q.Since
+	criteria.Before = q.Before
+
+	addFlag := func(flag imap.Flag, want *bool) {
+		if want == nil {
+			return
+		}
+		if *want {
+			criteria.Flag = append(criteria.Flag, flag)
+		} else {
+			criteria.NotFlag = append(criteria.NotFlag, flag)
+		}
+	}
+	addFlag(imap.FlagSeen, q.Seen)
+	addFlag(imap.FlagFlagged, q.Flagged)
+	addFlag(imap.FlagAnswered, q.Answered)
+
+	criteria.Larger = q.Larger
+	criteria.Smaller = q.Smaller
+
+	return criteria
+}
+
+// SearchEmails finds emails in a folder matching the query using IMAP SEARCH.
+// Results are ordered newest first and paged with Limit/Offset.
+func (c *IMAPClient) SearchEmails(query *SearchQuery) (*SearchResult, error) {
+	folder := query.Folder
+	if folder == "" {
+		folder = DefaultFolder
+	}
+	limit := query.Limit
+	if limit <= 0 {
+		limit = 20
+	}
+	offset := query.Offset
+	if offset < 0 {
+		offset = 0
+	}
+
+	client, err := c.pool.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer c.pool.release(client)
+
+	if _, err := selectFolder(client, folder); err != nil {
+		return nil, err
+	}
+
+	searchData, err := client.UIDSearch(query.searchCriteria(), nil).Wait()
+	if err != nil {
+		return nil, fmt.Errorf("failed to search messages: %w", err)
+	}
+
+	uids := searchData.AllUIDs()
+
+	// IMAP SEARCH has no attachment criterion, so filter on BODYSTRUCTURE
+	if query.HasAttachment != nil && len(uids) > 0 {
+		uids, err = filterByAttachment(client, uids, *query.HasAttachment)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Newest first: higher UIDs were added to the mailbox later
+	sort.Slice(uids, func(i, j int) bool { return uids[i] > uids[j] })
+
+	result := &SearchResult{
+		Emails: []*Email{},
+		Total:  len(uids),
+		Offset: offset,
+	}
+	if offset >= len(uids) {
+		return result, nil
+	}
+
+	end := offset + limit
+	if end > len(uids) {
+		end = len(uids)
+	}
+	page := uids[offset:end]
+	result.HasMore = end < len(uids)
+
+	var uidSet imap.UIDSet
+	for _, uid := range page {
+		uidSet.AddNum(uid)
+	}
+
+	fetchOptions := &imap.FetchOptions{
+		Envelope: true,
+		Flags:    true,
+		UID:      true,
+	}
+
+	messages, err := client.Fetch(uidSet, fetchOptions).Collect()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch messages: %w", err)
+	}
+
+	// FETCH returns messages in mailbox order, restore the page order
+	byUID := make(map[imap.UID]*imapclient.FetchMessageBuffer, len(messages))
+	for _, msg := range messages {
+		byUID[msg.UID] = msg
+	}
+	for _, uid := range page {
+		if msg, ok := byUID[uid]; ok {
+			result.Emails = append(result.Emails, newEmailFromMessage(folder, msg))
+			c.config.harvestEnvelope(msg.Envelope)
+		}
+	}
+	result.Count = len(result.Emails)
+
+	return result, nil
+}
+
+// filterByAttachment keeps only the UIDs whose body structure does (or does not) contain an attachment
+func filterByAttachment(client *imapclient.Client, uids []imap.UID, want bool) ([]imap.UID, error) {
+	var uidSet imap.UIDSet
+	for _, uid := range uids {
+		uidSet.AddNum(uid)
+	}
+
+	fetchOptions := &imap.FetchOptions{
+		UID:           true,
+		BodyStructure: &imap.FetchItemBodyStructure{Extended: true},
+	}
+
+	messages, err := client.Fetch(uidSet, fetchOptions).Collect()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch body structure: %w", err)
+	}
+
+	var filtered []imap.UID
+	for _, msg := range messages {
+		if msg.BodyStructure != nil && hasAttachment(msg.BodyStructure) == want {
+			filtered = append(filtered, msg.UID)
+		}
+	}
+
+	return filtered, nil
+}
+
+// hasAttachment reports whether any part of the body structure is an attachment
+func hasAttachment(bs imap.BodyStructure) bool {
+	found := false
+	bs.Walk(func(path []int, part imap.BodyStructure) bool {
+		single, ok := part.(*imap.BodyStructureSinglePart)
+		if !ok {
+			return true
+		}
+		if disp := single.Disposition(); disp != nil && strings.EqualFold(disp.Value, "attachment") {
+			found = true
+		} else if single.Filename() != "" {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
@@ -0,0 +1,296 @@
+package shared
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+func TestSearchCriteria(t *testing.T) {
+	yes, no := true, false
+	since := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	before := time.Date(2024, 2, 3, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		query SearchQuery
+		want  imap.SearchCriteria
+	}{
+		{name: "empty query matches everything", query: SearchQuery{}, want: imap.SearchCriteria{}},
+		{
+			name:  "from",
+			query: SearchQuery{From: "alice@example.com"},
+			want:  imap.SearchCriteria{Header: []imap.SearchCriteriaHeaderField{{Key: "From", Value: "alice@example.com"}}},
+		},
+		{
+			name:  "to",
+			query: SearchQuery{To: "bob"},
+			want:  imap.SearchCriteria{Header: []imap.SearchCriteriaHeaderField{{Key: "To", Value: "bob"}}},
+		},
+		{
+			name:  "cc",
+			query: SearchQuery{Cc: "carol"},
+			want:  imap.SearchCriteria{Header: []imap.SearchCriteriaHeaderField{{Key: "Cc", Value: "carol"}}},
+		},
+		{
+			name:  "subject",
+			query: SearchQuery{Subject: "invoice"},
+			want:  imap.SearchCriteria{Header: []imap.SearchCriteriaHeaderField{{Key: "Subject", Value: "invoice"}}},
+		},
+		{
+			name:  "headers combine in order",
+			query: SearchQuery{Subject: "invoice", From: "alice"},
+			want: imap.SearchCriteria{Header: []imap.SearchCriteriaHeaderField{
+				{Key: "From", Value: "alice"},
+				{Key: "Subject", Value: "invoice"},
+			}},
+		},
+		{name: "body", query: SearchQuery{Body: "total"}, want: imap.SearchCriteria{Body: []string{"total"}}},
+		{name: "text", query: SearchQuery{Text: "meeting"}, want: imap.SearchCriteria{Text: []string{"meeting"}}},
+		{name: "since", query: SearchQuery{Since: since}, want: imap.SearchCriteria{Since: since}},
+		{name: "before", query: SearchQuery{Before: before}, want: imap.SearchCriteria{Before: before}},
+		{name: "seen", query: SearchQuery{Seen: &yes}, want: imap.SearchCriteria{Flag: []imap.Flag{imap.FlagSeen}}},
+		{name: "unseen", query: SearchQuery{Seen: &no}, want: imap.SearchCriteria{NotFlag: []imap.Flag{imap.FlagSeen}}},
+		{name: "flagged", query: SearchQuery{Flagged: &yes}, want: imap.SearchCriteria{Flag: []imap.Flag{imap.FlagFlagged}}},
+		{name: "not flagged", query: SearchQuery{Flagged: &no}, want: imap.SearchCriteria{NotFlag: []imap.Flag{imap.FlagFlagged}}},
+		{name: "answered", query: SearchQuery{Answered: &yes}, want: imap.SearchCriteria{Flag: []imap.Flag{imap.FlagAnswered}}},
+		{name: "not answered", query: SearchQuery{Answered: &no}, want: imap.SearchCriteria{NotFlag: []imap.Flag{imap.FlagAnswered}}},
+		{
+			name:  "mixed flags",
+			query: SearchQuery{Seen: &no, Flagged: &yes, Answered: &no},
+			want: imap.SearchCriteria{
+				Flag:    []imap.Flag{imap.FlagFlagged},
+				NotFlag: []imap.Flag{imap.FlagSeen, imap.FlagAnswered},
+			},
+		},
+		{name: "larger", query: SearchQuery{Larger: 1024}, want: imap.SearchCriteria{Larger: 1024}},
+		{name: "smaller", query: SearchQuery{Smaller: 4096}, want: imap.SearchCriteria{Smaller: 4096}},
+		{
+			name:  "folder, attachment and paging are not search keys",
+			query: SearchQuery{Folder: "Archive", HasAttachment: &yes, Limit: 5, Offset: 10},
+			want:  imap.SearchCriteria{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.query.searchCriteria(); !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("searchCriteria() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDateArg(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{value: "", want: time.Time{}},
+		{value: "2024-03-15", want: time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{value: "2024-02-29", want: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{value: "2023-02-29", wantErr: true},
+		{value: "2024-13-01", wantErr: true},
+		{value: "2024-3-5", wantErr: true},
+		{value: "15/03/2024", wantErr: true},
+		{value: "2024-03-15T10:00:00Z", wantErr: true},
+		{value: "yesterday", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseDateArg(tt.value)
+		if tt.wantErr {
+			if err == nil || !strings.Contains(err.Error(), "YYYY-MM-DD") {
+				t.Errorf("parseDateArg(%q) = %v, %v, want a YYYY-MM-DD error", tt.value, got, err)
+			}
+			continue
+		}
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("parseDateArg(%q) = %v, %v, want %v", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestHasAttachment(t *testing.T) {
+	text := &imap.BodyStructureSinglePart{Type: "text", Subtype: "plain"}
+	tests := []struct {
+		name string
+		bs   imap.BodyStructure
+		want bool
+	}{
+		{name: "plain text", bs: text, want: false},
+		{
+			name: "inline image without a name",
+			bs: &imap.BodyStructureSinglePart{Type: "image", Subtype: "png", Extended: &imap.BodyStructureSinglePartExt{
+				Disposition: &imap.BodyStructureDisposition{Value: "inline"},
+			}},
+			want: false,
+		},
+		{
+			name: "attachment disposition",
+			bs: &imap.BodyStructureMultiPart{Subtype: "mixed", Children: []imap.BodyStructure{
+				text,
+				&imap.BodyStructureSinglePart{Type: "application", Subtype: "pdf", Extended: &imap.BodyStructureSinglePartExt{
+					Disposition: &imap.BodyStructureDisposition{Value: "ATTACHMENT"},
+				}},
+			}},
+			want: true,
+		},
+		{
+			name: "named part without a disposition",
+			bs: &imap.BodyStructureMultiPart{Subtype: "mixed", Children: []imap.BodyStructure{
+				text,
+				&imap.BodyStructureSinglePart{Type: "application", Subtype: "pdf", Params: map[string]string{"name": "report.pdf"}},
+			}},
+			want: true,
+		},
+		{
+			name: "nested alternative",
+			bs: &imap.BodyStructureMultiPart{Subtype: "mixed", Children: []imap.BodyStructure{
+				&imap.BodyStructureMultiPart{Subtype: "alternative", Children: []imap.BodyStructure{
+					text,
+					&imap.BodyStructureSinglePart{Type: "text", Subtype: "html"},
+				}},
+			}},
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasAttachment(tt.bs); got != tt.want {
+				t.Errorf("hasAttachment() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// appendWithAttachment adds a message with a PDF attachment to a folder
+func (s *testIMAPServer) appendWithAttachment(t *testing.T, folder, subject string) {
+	t.Helper()
+
+	msg := "From: Sender <sender@example.org>\r\n" +
+		"To: me@example.com\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Date: Mon, 02 Jan 2006 15:04:05 +0000\r\n" +
+		"Content-Type: multipart/mixed; boundary=b\r\n" +
+		"\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"See attached\r\n" +
+		"--b\r\n" +
+		"Content-Type: application/pdf; name=report.pdf\r\n" +
+		"Content-Disposition: attachment; filename=report.pdf\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		"JVBERi0xLjQ=\r\n" +
+		"--b--\r\n"
+	if _, err := s.user.Append(folder, testLiteral{bytes.NewReader([]byte(msg))}, &imap.AppendOptions{}); err != nil {
+		t.Fatalf("failed to append %q: %v", subject, err)
+	}
+}
+
+func TestSearchEmails(t *testing.T) {
+	yes, no := true, false
+	server := newTestIMAPServer(t)
+	for _, subject := range []string{"one", "two", "three", "four", "five"} {
+		server.appendMessage(t, DefaultFolder, subject)
+	}
+	server.appendWithAttachment(t, DefaultFolder, "six")
+
+	tests := []struct {
+		name        string
+		query       SearchQuery
+		wantSubject []string
+		wantTotal   int
+		wantHasMore bool
+	}{
+		{
+			name:        "newest first with the default limit",
+			query:       SearchQuery{},
+			wantSubject: []string{"six", "five", "four", "three", "two", "one"},
+			wantTotal:   6,
+		},
+		{
+			name:        "first page",
+			query:       SearchQuery{Limit: 2},
+			wantSubject: []string{"six", "five"},
+			wantTotal:   6,
+			wantHasMore: true,
+		},
+		{
+			name:        "middle page",
+			query:       SearchQuery{Limit: 2, Offset: 2},
+			wantSubject: []string{"four", "three"},
+			wantTotal:   6,
+			wantHasMore: true,
+		},
+		{
+			name:        "last page",
+			query:       SearchQuery{Limit: 4, Offset: 4},
+			wantSubject: []string{"two", "one"},
+			wantTotal:   6,
+		},
+		{
+			name:      "offset past the end",
+			query:     SearchQuery{Offset: 10},
+			wantTotal: 6,
+		},
+		{
+			name:        "negative offset starts at the newest",
+			query:       SearchQuery{Limit: 1, Offset: -3},
+			wantSubject: []string{"six"},
+			wantTotal:   6,
+			wantHasMore: true,
+		},
+		{
+			name:        "subject",
+			query:       SearchQuery{Subject: "three"},
+			wantSubject: []string{"three"},
+			wantTotal:   1,
+		},
+		{
+			name:        "with attachment",
+			query:       SearchQuery{HasAttachment: &yes},
+			wantSubject: []string{"six"},
+			wantTotal:   1,
+		},
+		{
+			name:        "without attachment, paged",
+			query:       SearchQuery{HasAttachment: &no, Limit: 2},
+			wantSubject: []string{"five", "four"},
+			wantTotal:   5,
+			wantHasMore: true,
+		},
+		{
+			name:      "no match",
+			query:     SearchQuery{From: "nobody@example.net"},
+			wantTotal: 0,
+		},
+	}
+
+	client := NewIMAPClient(server.config)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := client.SearchEmails(&tt.query)
+			if err != nil {
+				t.Fatalf("SearchEmails() error: %v", err)
+			}
+			var subjects []string
+			for _, email := range result.Emails {
+				subjects = append(subjects, email.Subject)
+			}
+			if !reflect.DeepEqual(subjects, tt.wantSubject) || result.Count != len(tt.wantSubject) ||
+				result.Total != tt.wantTotal || result.HasMore != tt.wantHasMore {
+				t.Errorf("SearchEmails() = %v (count %d, total %d, has_more %v), want %v (total %d, has_more %v)",
+					subjects, result.Count, result.Total, result.HasMore, tt.wantSubject, tt.wantTotal, tt.wantHasMore)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// passwordCommandTimeout bounds a password_command run, e.g. a password manager prompt
+const passwordCommandTimeout = 30 * time.Second
+
+// resolvePassword returns the password of IMAP or SMTP settings. A password given
+// directly (in the file or through an environment override) is used as is;
+// otherwise it is read from password_env, password_file or password_command,
+// of which at most one may be set. Errors name the offending field.
+func resolvePassword(password, env, file, command string) (string, error) {
+	var sources []string
+	for _, source := range []struct{ field, value string }{
+		{"password_env", env},
+		{"password_file", file},
+		{"password_command", command},
+	} {
+		if source.value != "" {
+			sources = append(sources, source.field)
+		}
+	}
+	if len(sources) > 1 {
+		return "", fmt.Errorf("%s: conflicts with %s, only one password source may be set",
+			sources[0], strings.Join(sources[1:], " and "))
+	}
+	if password != "" || len(sources) == 0 {
+		return password, nil
+	}
+
+	switch {
+	case env != "":
+		value, ok := os.LookupEnv(env)
+		if !ok || value == "" {
+			return "", fmt.Errorf("password_env: environment variable %s is not set", env)
+		}
+		return value, nil
+
+	case file != "":
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("password_file: %w", err)
+		}
+		value := strings.TrimRight(string(data), "\r\n")
+		if value == "" {
+			return "", fmt.Errorf("password_file: %s is empty", file)
+		}
+		return value, nil
+
+	default:
+		value, err := runPasswordCommand(command)
+		if err != nil {
+			return "", fmt.Errorf("password_command: %w", err)
+		}
+		return value, nil
+	}
+}
+
+// runPasswordCommand runs command through the shell and returns the first line of its output
+func runPasswordCommand(command string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), passwordCommandTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return "", fmt.Errorf("%w: %s", err, message)
+		}
+		return "", err
+	}
+
+	// Tools like `pass` print the password on the first line and metadata below
+	value, _, _ := strings.Cut(string(output), "\n")
+	value = strings.TrimRight(value, "\r")
+	if value == "" {
+		return "", fmt.Errorf("command printed no password")
+	}
+	return value, nil
+}
@@ -0,0 +1,49 @@
+package shared
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolvePassword(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(file, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_RESOLVE_PASSWORD", "from-env")
+
+	tests := []struct {
+		name     string
+		password string
+		env      string
+		file     string
+		command  string
+		want     string
+		wantErr  string
+	}{
+		{name: "direct", password: "secret", want: "secret"},
+		{name: "direct wins over a source", password: "secret", env: "TEST_RESOLVE_PASSWORD", want: "secret"},
+		{name: "env", env: "TEST_RESOLVE_PASSWORD", want: "from-env"},
+		{name: "file", file: file, want: "from-file"},
+		{name: "unset env", env: "TEST_RESOLVE_PASSWORD_UNSET", wantErr: "password_env: environment variable TEST_RESOLVE_PASSWORD_UNSET is not set"},
+		{name: "file and command", file: file, command: "echo x", wantErr: "password_file: conflicts with password_command, only one password source may be set"},
+		{name: "env and command", env: "TEST_RESOLVE_PASSWORD", command: "echo x", wantErr: "password_env: conflicts with password_command, only one password source may be set"},
+		{name: "all three", env: "TEST_RESOLVE_PASSWORD", file: file, command: "echo x", wantErr: "password_env: conflicts with password_file and password_command, only one password source may be set"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolvePassword(tt.password, tt.env, tt.file, tt.command)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("resolvePassword() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("resolvePassword() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
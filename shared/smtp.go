@@ -2,10 +2,15 @@ package shared
 
 import (
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"net"
 	"net/smtp"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/emersion/go-message/mail"
 	"github.com/gomarkdown/markdown"
 	"github.com/gomarkdown/markdown/html"
 	"github.com/gomarkdown/markdown/parser"
@@ -21,121 +26,298 @@ func NewSMTPClient(config *Config) *SMTPClient {
 	return &SMTPClient{config: config}
 }
 
+// SMTP security modes
+const (
+	SMTPSecurityNone     = "none"
+	SMTPSecurityStartTLS = "starttls"
+	SMTPSecurityTLS      = "tls"
+)
+
+// SMTP authentication mechanisms
+const (
+	SMTPAuthPlain   = "plain"
+	SMTPAuthLogin   = "login"
+	SMTPAuthCRAMMD5 = "cram-md5"
+	SMTPAuthNone    = "none"
+)
+
+// smtpDialTimeout bounds establishing the TCP (and implicit TLS) connection
+const smtpDialTimeout = 30 * time.Second
+
 // ValidateConnection tests the SMTP connection and credentials
 func (c *SMTPClient) ValidateConnection() error {
-	addr := fmt.Sprintf("%s:%d", c.config.SMTP.Server, c.config.SMTP.Port)
-
-	conn, err := smtp.Dial(addr)
+	conn, err := c.dial()
 	if err != nil {
-		return fmt.Errorf("failed to connect to SMTP server: %w", err)
+		return err
 	}
 	defer conn.Close()
 
-	if c.config.SMTP.RequireTLS {
-		tlsConfig := &tls.Config{
-			ServerName: c.config.SMTP.Server,
+	return conn.Quit()
+}
+
+// dial connects to the SMTP server using the configured security mode and
+// authenticates with the configured mechanism
+func (c *SMTPClient) dial() (*smtp.Client, error) {
+	server := c.config.SMTP.Server
+	addr := net.JoinHostPort(server, strconv.Itoa(c.config.SMTP.Port))
+	tlsConfig, err := c.config.TLSConfig(server)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: smtpDialTimeout}
+	var netConn net.Conn
+	if c.config.SMTP.Security == SMTPSecurityTLS {
+		netConn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		netConn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SMTP server: %w", err)
+	}
+
+	conn, err := smtp.NewClient(netConn, server)
+	if err != nil {
+		netConn.Close()
+		return nil, fmt.Errorf("failed to connect to SMTP server: %w", err)
+	}
+
+	if c.config.SMTP.Security == SMTPSecurityStartTLS {
+		if ok, _ := conn.Extension("STARTTLS"); !ok {
+			conn.Close()
+			return nil, fmt.Errorf("server does not support STARTTLS (set smtp.security to \"tls\" for port 465 or \"none\" for a plaintext relay)")
 		}
 		if err := conn.StartTLS(tlsConfig); err != nil {
-			return fmt.Errorf("failed to start TLS: %w", err)
+			conn.Close()
+			return nil, fmt.Errorf("failed to start TLS: %w", err)
 		}
 	}
 
-	auth := smtp.PlainAuth("", c.config.SMTP.Username, c.config.SMTP.Password, c.config.SMTP.Server)
-	if err := conn.Auth(auth); err != nil {
-		return fmt.Errorf("failed to authenticate: %w", err)
+	auth, err := c.auth()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if auth != nil {
+		if err := conn.Auth(auth); err != nil {
+			if _, ok := auth.(*saslSMTPAuth); ok {
+				getTokenSource(c.config).invalidate()
+			}
+			conn.Close()
+			return nil, fmt.Errorf("failed to authenticate: %w", err)
+		}
 	}
 
-	return conn.Quit()
+	return conn, nil
+}
+
+// auth returns the configured SMTP authentication, or nil for unauthenticated relay.
+// PLAIN, LOGIN and the OAuth2 mechanisms refuse to send credentials over an
+// unencrypted connection except to localhost.
+func (c *SMTPClient) auth() (smtp.Auth, error) {
+	username, password, server := c.config.SMTP.Username, c.config.SMTP.Password, c.config.SMTP.Server
+
+	switch c.config.SMTP.AuthMechanism {
+	case SMTPAuthNone:
+		return nil, nil
+	case SMTPAuthLogin:
+		return &loginAuth{username: username, password: password, host: server}, nil
+	case SMTPAuthCRAMMD5:
+		return smtp.CRAMMD5Auth(username, password), nil
+	case AuthXOAuth2, AuthOAuthBearer:
+		client, err := oauth2SASLClient(c.config, c.config.SMTP.AuthMechanism, username, server, c.config.SMTP.Port)
+		if err != nil {
+			return nil, err
+		}
+		return &saslSMTPAuth{client: client, host: server}, nil
+	default:
+		return smtp.PlainAuth("", username, password, server), nil
+	}
+}
+
+// loginAuth implements the AUTH LOGIN mechanism, which net/smtp does not provide
+type loginAuth struct {
+	username string
+	password string
+	host     string
+	step     int
 }
 
-// SendEmail sends an email via SMTP
-func (c *SMTPClient) SendEmail(to, subject, body, bodyFormat, cc, bcc string) error {
-	// Resolve contact names to email addresses
-	to = c.config.ResolveEmail(to)
-	if cc != "" {
-		cc = c.config.ResolveEmail(cc)
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, fmt.Errorf("unencrypted connection")
 	}
-	if bcc != "" {
-		bcc = c.config.ResolveEmail(bcc)
+	if server.Name != a.host {
+		return "", nil, fmt.Errorf("wrong host name")
 	}
+	a.step = 0
+	return "LOGIN", nil, nil
+}
 
-	// Convert body based on format
-	contentType := "text/plain; charset=UTF-8"
-	switch strings.ToLower(bodyFormat) {
-	case "html":
-		contentType = "text/html; charset=UTF-8"
-	case "markdown":
-		body = markdownToHTML(body)
-		contentType = "text/html; charset=UTF-8"
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
 	}
 
-	// Build recipients list
-	recipients := []string{to}
-	if cc != "" {
-		recipients = append(recipients, cc)
+	// Servers prompt with "Username:" and "Password:", but not all use these exact words
+	prompt := strings.ToLower(strings.TrimSpace(string(fromServer)))
+	a.step++
+	switch {
+	case strings.HasPrefix(prompt, "user"), a.step == 1 && !strings.HasPrefix(prompt, "pass"):
+		return []byte(a.username), nil
+	case strings.HasPrefix(prompt, "pass"), a.step == 2:
+		return []byte(a.password), nil
 	}
-	if bcc != "" {
-		recipients = append(recipients, bcc)
+	return nil, fmt.Errorf("unexpected server challenge %q", fromServer)
+}
+
+// isLocalhost reports whether the SMTP server is on the local machine
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
+
+// OutgoingAttachment is a file attached to an outgoing email
+type OutgoingAttachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// OutgoingEmail describes an email to send.
+// Recipients may be contact names; they are resolved when sending.
+type OutgoingEmail struct {
+	// From is the address of the identity to send as (default: my_email)
+	From        string
+	To          []string
+	Cc          []string
+	Bcc         []string
+	Subject     string
+	Body        string
+	BodyFormat  string
+	InReplyTo   string
+	References  []string
+	Attachments []OutgoingAttachment
+	// Quoted is the quoted or forwarded original in BodyFormat, placed below the body and signature
+	Quoted string
+	// AppendSignature adds the identity's signature below the body
+	AppendSignature bool
+}
+
+// Send composes and sends an email via SMTP
+func (c *SMTPClient) Send(email *OutgoingEmail) error {
+	// Resolve contact names and validate addresses
+	to, err := c.config.ResolveRecipients(email.To)
+	if err != nil {
+		return err
+	}
+	cc, err := c.config.ResolveRecipients(email.Cc)
+	if err != nil {
+		return err
+	}
+	bcc, err := c.config.ResolveRecipients(email.Bcc)
+	if err != nil {
+		return err
 	}
 
-	// Build email headers and body
-	var msg strings.Builder
-	msg.WriteString(fmt.Sprintf("From: %s\r\n", c.config.MyEmail))
-	msg.WriteString(fmt.Sprintf("To: %s\r\n", to))
-	if cc != "" {
-		msg.WriteString(fmt.Sprintf("Cc: %s\r\n", cc))
+	if len(to) == 0 {
+		return fmt.Errorf("no recipients")
 	}
-	msg.WriteString(fmt.Sprintf("Subject: %s\r\n", subject))
-	msg.WriteString(fmt.Sprintf("Content-Type: %s\r\n", contentType))
-	msg.WriteString("MIME-Version: 1.0\r\n")
-	msg.WriteString("\r\n")
-	msg.WriteString(body)
 
-	// Connect and send
-	addr := fmt.Sprintf("%s:%d", c.config.SMTP.Server, c.config.SMTP.Port)
+	identity, err := c.config.Identity(email.From)
+	if err != nil {
+		return err
+	}
+	from, err := mail.ParseAddress(identity.Address)
+	if err != nil {
+		return fmt.Errorf("invalid sender address %q: %w", identity.Address, err)
+	}
+	from.Name = identity.Name
 
-	auth := smtp.PlainAuth("", c.config.SMTP.Username, c.config.SMTP.Password, c.config.SMTP.Server)
+	msg, err := composeMessage(identity, from, to, cc, email, c.config.Markdown.InlineCSS)
+	if err != nil {
+		return err
+	}
 
-	if c.config.SMTP.RequireTLS {
-		return c.sendWithTLS(addr, auth, c.config.MyEmail, recipients, []byte(msg.String()))
+	// Build the envelope recipients list
+	all := append(append(append([]*mail.Address{}, to...), cc...), bcc...)
+	var recipients []string
+	for _, addr := range all {
+		recipients = append(recipients, addr.Address)
 	}
 
-	return smtp.SendMail(addr, auth, c.config.MyEmail, recipients, []byte(msg.String()))
+	err = c.deliver(from.Address, recipients, msg)
+	var partial *PartialDeliveryError
+	if err == nil || errors.As(err, &partial) {
+		c.config.harvestSent(msg, deliveredRecipients(all, partial))
+	}
+	return err
 }
 
-// sendWithTLS sends email using STARTTLS
-func (c *SMTPClient) sendWithTLS(addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
-	// Connect to the server
-	conn, err := smtp.Dial(addr)
-	if err != nil {
-		return fmt.Errorf("failed to connect: %w", err)
+// deliveredRecipients leaves out the recipients the server refused
+func deliveredRecipients(recipients []*mail.Address, partial *PartialDeliveryError) []*mail.Address {
+	if partial == nil {
+		return recipients
 	}
-	defer conn.Close()
-
-	// Start TLS
-	tlsConfig := &tls.Config{
-		ServerName: c.config.SMTP.Server,
+	refused := make(map[string]bool)
+	for _, failure := range partial.Failures {
+		refused[failure.Address] = true
 	}
-	if err := conn.StartTLS(tlsConfig); err != nil {
-		return fmt.Errorf("failed to start TLS: %w", err)
+	var delivered []*mail.Address
+	for _, addr := range recipients {
+		if !refused[addr.Address] {
+			delivered = append(delivered, addr)
+		}
 	}
+	return delivered
+}
 
-	// Authenticate
-	if err := conn.Auth(auth); err != nil {
-		return fmt.Errorf("failed to authenticate: %w", err)
+// RecipientFailure is a recipient the SMTP server refused
+type RecipientFailure struct {
+	Address string
+	Err     error
+}
+
+// PartialDeliveryError is returned when the email was sent but the server refused some recipients
+type PartialDeliveryError struct {
+	Failures []RecipientFailure
+}
+
+func (e *PartialDeliveryError) Error() string {
+	return fmt.Sprintf("the server refused %d recipient(s): %s", len(e.Failures), formatRecipientFailures(e.Failures))
+}
+
+// formatRecipientFailures lists refused recipients with the server's reasons
+func formatRecipientFailures(failures []RecipientFailure) string {
+	var parts []string
+	for _, failure := range failures {
+		parts = append(parts, fmt.Sprintf("%s (%v)", failure.Address, failure.Err))
 	}
+	return strings.Join(parts, "; ")
+}
+
+// deliver sends a composed message to the envelope recipients
+func (c *SMTPClient) deliver(from string, to []string, msg []byte) error {
+	conn, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
 
 	// Set sender
 	if err := conn.Mail(from); err != nil {
 		return fmt.Errorf("failed to set sender: %w", err)
 	}
 
-	// Set recipients
+	// Set recipients, collecting refusals instead of giving up on the first one
+	var failures []RecipientFailure
 	for _, recipient := range to {
 		if err := conn.Rcpt(recipient); err != nil {
-			return fmt.Errorf("failed to set recipient %s: %w", recipient, err)
+			failures = append(failures, RecipientFailure{Address: recipient, Err: err})
 		}
 	}
+	if len(failures) == len(to) {
+		return fmt.Errorf("the server refused all recipients: %s", formatRecipientFailures(failures))
+	}
 
 	// Send the email body
 	w, err := conn.Data()
@@ -153,7 +335,13 @@ func (c *SMTPClient) sendWithTLS(addr string, auth smtp.Auth, from string, to []
 		return fmt.Errorf("failed to close data writer: %w", err)
 	}
 
-	return conn.Quit()
+	if err := conn.Quit(); err != nil {
+		return err
+	}
+	if len(failures) > 0 {
+		return &PartialDeliveryError{Failures: failures}
+	}
+	return nil
 }
 
 // markdownToHTML converts markdown text to HTML
@@ -0,0 +1,175 @@
+package shared
+
+import (
+	"net/smtp"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSMTPSecurityDefaults(t *testing.T) {
+	yes, no := true, false
+
+	tests := []struct {
+		name       string
+		port       int
+		security   string
+		requireTLS *bool
+		want       string
+	}{
+		{name: "default", want: SMTPSecurityStartTLS},
+		{name: "port 465", port: 465, want: SMTPSecurityTLS},
+		{name: "explicit", port: 25, security: "NONE", want: SMTPSecurityNone},
+		{name: "require_tls true", port: 587, requireTLS: &yes, want: SMTPSecurityStartTLS},
+		{name: "require_tls false", port: 25, requireTLS: &no, want: SMTPSecurityNone},
+		{name: "require_tls false on 465", port: 465, requireTLS: &no, want: SMTPSecurityTLS},
+		{name: "security wins over require_tls", port: 25, security: "starttls", requireTLS: &no, want: SMTPSecurityStartTLS},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{}
+			config.SMTP.Server = "smtp.example.com"
+			config.SMTP.Port = tt.port
+			config.SMTP.Security = tt.security
+			config.SMTP.RequireTLS = tt.requireTLS
+			config.applyAccountDefaults("", &configProblems{})
+
+			if config.SMTP.Security != tt.want {
+				t.Errorf("smtp.security = %q, want %q", config.SMTP.Security, tt.want)
+			}
+		})
+	}
+}
+
+func TestSMTPAuthSelection(t *testing.T) {
+	tests := []struct {
+		name          string
+		username      string
+		mechanism     string
+		wantMechanism string
+		wantStart     string
+		wantProblem   bool
+	}{
+		{name: "plain by default", username: "me", wantMechanism: SMTPAuthPlain, wantStart: "PLAIN"},
+		{name: "none without username", wantMechanism: SMTPAuthNone},
+		{name: "login", username: "me", mechanism: "LOGIN", wantMechanism: SMTPAuthLogin, wantStart: "LOGIN"},
+		{name: "cram-md5", username: "me", mechanism: "cram-md5", wantMechanism: SMTPAuthCRAMMD5, wantStart: "CRAM-MD5"},
+		{name: "login needs a username", mechanism: "login", wantMechanism: SMTPAuthLogin, wantStart: "LOGIN", wantProblem: true},
+		{name: "unknown", username: "me", mechanism: "digest-md5", wantMechanism: "digest-md5", wantStart: "PLAIN", wantProblem: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{}
+			config.SMTP.Server = "smtp.example.com"
+			config.SMTP.Username = tt.username
+			config.SMTP.Password = "secret"
+			config.SMTP.AuthMechanism = tt.mechanism
+			var problems configProblems
+			config.applyAccountDefaults("", &problems)
+
+			if config.SMTP.AuthMechanism != tt.wantMechanism {
+				t.Errorf("smtp.auth_mechanism = %q, want %q", config.SMTP.AuthMechanism, tt.wantMechanism)
+			}
+			if got := hasProblem(problems, "smtp."); got != tt.wantProblem {
+				t.Errorf("smtp problem reported = %v, want %v: %q", got, tt.wantProblem, []string(problems))
+			}
+
+			auth, err := NewSMTPClient(config).auth()
+			if err != nil {
+				t.Fatalf("auth() error: %v", err)
+			}
+			if tt.wantStart == "" {
+				if auth != nil {
+					t.Errorf("auth() = %T, want no authentication", auth)
+				}
+				return
+			}
+			mechanism, _, err := auth.Start(&smtp.ServerInfo{Name: "smtp.example.com", TLS: true, Auth: []string{tt.wantStart}})
+			if err != nil || mechanism != tt.wantStart {
+				t.Errorf("auth().Start() = %q, %v, want %q", mechanism, err, tt.wantStart)
+			}
+		})
+	}
+}
+
+// hasProblem reports whether any problem starts with prefix
+func hasProblem(problems configProblems, prefix string) bool {
+	for _, problem := range problems {
+		if strings.HasPrefix(problem, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestLoginAuthStart(t *testing.T) {
+	tests := []struct {
+		name    string
+		host    string
+		server  smtp.ServerInfo
+		wantErr bool
+	}{
+		{name: "TLS", host: "smtp.example.com", server: smtp.ServerInfo{Name: "smtp.example.com", TLS: true}},
+		{name: "plaintext to localhost", host: "localhost", server: smtp.ServerInfo{Name: "localhost"}},
+		{name: "plaintext", host: "smtp.example.com", server: smtp.ServerInfo{Name: "smtp.example.com"}, wantErr: true},
+		{name: "wrong host", host: "smtp.example.com", server: smtp.ServerInfo{Name: "other.example.com", TLS: true}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth := &loginAuth{username: "me", password: "secret", host: tt.host}
+			mechanism, initial, err := auth.Start(&tt.server)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Start() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (mechanism != "LOGIN" || initial != nil) {
+				t.Errorf("Start() = %q, %q, want LOGIN without an initial response", mechanism, initial)
+			}
+		})
+	}
+}
+
+func TestLoginAuthNext(t *testing.T) {
+	tests := []struct {
+		name       string
+		challenges []string
+		want       []string
+		wantErr    bool
+	}{
+		{name: "standard prompts", challenges: []string{"Username:", "Password:"}, want: []string{"me", "secret"}},
+		{name: "lower-case prompts", challenges: []string{"username", "password"}, want: []string{"me", "secret"}},
+		{name: "unusual prompts by order", challenges: []string{"Login name?", "Secret?"}, want: []string{"me", "secret"}},
+		{name: "third challenge", challenges: []string{"Username:", "Password:", "Again?"}, want: []string{"me", "secret"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth := &loginAuth{username: "me", password: "secret", host: "smtp.example.com"}
+			if _, _, err := auth.Start(&smtp.ServerInfo{Name: "smtp.example.com", TLS: true}); err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			var err error
+			for _, challenge := range tt.challenges {
+				var response []byte
+				if response, err = auth.Next([]byte(challenge), true); err != nil {
+					break
+				}
+				got = append(got, string(response))
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Next() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("responses = %q, want %q", got, tt.want)
+			}
+
+			if response, err := auth.Next(nil, false); response != nil || err != nil {
+				t.Errorf("Next(more=false) = %q, %v, want nothing", response, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,465 @@
+package shared
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+const (
+	// threadSearchRounds bounds how many times the set of known message IDs is expanded
+	threadSearchRounds = 3
+	// threadMaxSearchIDs caps the number of message IDs put into a single SEARCH
+	threadMaxSearchIDs = 20
+	// threadDateWindow limits server-side threading to messages received this long before or after the seed
+	threadDateWindow = 365 * 24 * time.Hour
+)
+
+// capGmail is advertised by Gmail's IMAP server
+const capGmail imap.Cap = "X-GM-EXT-1"
+
+// replyPrefixPattern matches reply/forward prefixes such as "Re:", "Fwd:", "AW:" or "Re[2]:"
+var replyPrefixPattern = regexp.MustCompile(`(?i)^\s*((re|fwd?|aw|sv|antw|wg)(\[\d+\])?\s*:\s*)+`)
+
+// NormalizeSubject strips reply/forward prefixes and surrounding whitespace from a subject
+func NormalizeSubject(subject string) string {
+	return strings.TrimSpace(replyPrefixPattern.ReplaceAllString(subject, ""))
+}
+
+// ThreadMessage is one message of a conversation
+type ThreadMessage struct {
+	Email
+	To        []string `json:"to,omitempty"`
+	MessageID string   `json:"message_id,omitempty"`
+	InReplyTo []string `json:"in_reply_to,omitempty"`
+}
+
+// Thread is a conversation reconstructed around one email
+type Thread struct {
+	Subject string `json:"subject"`
+	// Method tells how the thread was found: "gmail" (Gmail's X-GM-THRID),
+	// "thread" (IMAP THREAD extension), "references" (References/In-Reply-To
+	// headers) or "subject" (normalized subject)
+	Method string `json:"method"`
+	// GmailThreadID is Gmail's conversation ID (X-GM-THRID) when method is "gmail"
+	GmailThreadID string           `json:"gmail_thread_id,omitempty"`
+	Folders       []string         `json:"folders"`
+	Messages      []*ThreadMessage `json:"messages"`
+	Count         int              `json:"count"`
+}
+
+// threadCandidate is a message found while searching for the thread
+type threadCandidate struct {
+	folder     string
+	msg        *imapclient.FetchMessageBuffer
+	references []string
+}
+
+// GetThread returns the conversation the given email belongs to, ordered by date.
+// The email's folder and the Sent folder are searched (on Gmail, All Mail instead of Sent).
+// On Gmail the thread is the set of messages sharing the email's X-GM-THRID.
+// Elsewhere, or if that lookup fails, the IMAP THREAD extension is used when the
+// server advertises REFERENCES threading; otherwise messages are linked through
+// Message-ID, In-Reply-To and References headers, and as a last resort through
+// the normalized subject.
+func (c *IMAPClient) GetThread(emailID, folder string) (*Thread, error) {
+	folder, uid, err := ParseEmailID(emailID, folder)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := c.pool.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer c.pool.release(client)
+
+	folders, err := threadFolders(client, folder)
+	if err != nil {
+		return nil, err
+	}
+
+	// Load the seed message
+	if _, err := selectFolder(client, folder); err != nil {
+		return nil, err
+	}
+	var seedSet imap.UIDSet
+	seedSet.AddNum(uid)
+	seeds, err := fetchThreadCandidates(client, folder, seedSet)
+	if err != nil {
+		return nil, err
+	}
+	if len(seeds) == 0 {
+		return nil, fmt.Errorf("email not found")
+	}
+	seed := seeds[0]
+
+	thread := &Thread{
+		Method:  "references",
+		Folders: folders,
+	}
+	if seed.msg.Envelope != nil {
+		thread.Subject = seed.msg.Envelope.Subject
+	}
+
+	found := map[string]*threadCandidate{candidateKey(seed): seed}
+
+	gmail := false
+	if client.Caps().Has(capGmail) {
+		threadID, folderUIDs, err := c.gmailThreadUIDs(folder, uid, folders)
+		if err != nil {
+			log.Printf("Gmail thread lookup failed, matching headers instead: %v", err)
+		} else {
+			for _, f := range folders {
+				candidates, err := fetchFolderCandidates(client, f, folderUIDs[f])
+				if err != nil {
+					return nil, err
+				}
+				addThreadCandidates(found, candidates)
+			}
+			thread.Method, thread.GmailThreadID = "gmail", threadID
+			gmail = true
+		}
+	}
+	if !gmail {
+		if err := expandThread(client, thread, folders, seed, found); err != nil {
+			return nil, err
+		}
+	}
+
+	thread.Messages = make([]*ThreadMessage, 0, len(found))
+	for _, candidate := range found {
+		thread.Messages = append(thread.Messages, newThreadMessage(candidate))
+		c.config.harvestEnvelope(candidate.msg.Envelope)
+	}
+	sort.SliceStable(thread.Messages, func(i, j int) bool {
+		return thread.Messages[i].Date.Before(thread.Messages[j].Date)
+	})
+	thread.Count = len(thread.Messages)
+
+	return thread, nil
+}
+
+// expandThread adds the messages of the seed's thread to found using IMAP THREAD
+// in the seed's folder, message ID searches across folders and, when nothing else
+// matched, the normalized subject. It records the method used in thread.
+func expandThread(client *imapclient.Client, thread *Thread, folders []string, seed *threadCandidate, found map[string]*threadCandidate) error {
+	// Use server-side threading in the seed folder when available
+	if hasThreadReferences(client) {
+		threadUIDs, err := serverThreadUIDs(client, seed)
+		if err != nil {
+			return err
+		}
+		if len(threadUIDs) > 1 {
+			var uidSet imap.UIDSet
+			for _, u := range threadUIDs {
+				uidSet.AddNum(u)
+			}
+			candidates, err := fetchThreadCandidates(client, seed.folder, uidSet)
+			if err != nil {
+				return err
+			}
+			addThreadCandidates(found, candidates)
+			thread.Method = "thread"
+		}
+	}
+
+	// Expand through message IDs across all folders
+	searched := make(map[string]bool)
+	for round := 0; round < threadSearchRounds; round++ {
+		ids := pendingMessageIDs(found, searched)
+		if len(ids) == 0 {
+			break
+		}
+		for _, id := range ids {
+			searched[id] = true
+		}
+
+		for _, f := range folders {
+			candidates, err := searchThreadFolder(client, f, messageIDCriteria(ids))
+			if err != nil {
+				return err
+			}
+			addThreadCandidates(found, candidates)
+		}
+	}
+
+	// Fall back to matching the normalized subject
+	subject := NormalizeSubject(thread.Subject)
+	if len(found) == 1 && subject != "" {
+		criteria := &imap.SearchCriteria{
+			Header: []imap.SearchCriteriaHeaderField{{Key: "Subject", Value: subject}},
+		}
+		for _, f := range folders {
+			candidates, err := searchThreadFolder(client, f, criteria)
+			if err != nil {
+				return err
+			}
+			var matching []*threadCandidate
+			for _, candidate := range candidates {
+				if candidate.msg.Envelope != nil && strings.EqualFold(NormalizeSubject(candidate.msg.Envelope.Subject), subject) {
+					matching = append(matching, candidate)
+				}
+			}
+			addThreadCandidates(found, matching)
+		}
+		if len(found) > 1 {
+			thread.Method = "subject"
+		}
+	}
+	return nil
+}
+
+// threadFolders returns the folders to search for a thread around an email in folder
+func threadFolders(client *imapclient.Client, folder string) ([]string, error) {
+	folders := []string{folder}
+
+	attr, names := imap.MailboxAttrSent, sentFolderNames
+	if client.Caps().Has(capGmail) {
+		attr, names = imap.MailboxAttrAll, allMailFolderNames
+	}
+
+	other, err := specialUseFolder(client, attr, names)
+	if err != nil {
+		return nil, err
+	}
+	if other != "" && other != folder {
+		folders = append(folders, other)
+	}
+
+	return folders, nil
+}
+
+// hasThreadReferences reports whether the server supports THREAD=REFERENCES
+func hasThreadReferences(client *imapclient.Client) bool {
+	for _, algorithm := range client.Caps().ThreadAlgorithms() {
+		if algorithm == imap.ThreadReferences {
+			return true
+		}
+	}
+	return false
+}
+
+// serverThreadUIDs runs UID THREAD REFERENCES on the selected folder and
+// returns the UIDs of the thread that contains the seed message. Only messages
+// matched by threadSearchCriteria are threaded, so large folders stay cheap.
+func serverThreadUIDs(client *imapclient.Client, seed *threadCandidate) ([]imap.UID, error) {
+	criteria := threadSearchCriteria(seed)
+	if criteria == nil {
+		return nil, nil
+	}
+
+	threads, err := client.UIDThread(&imapclient.ThreadOptions{
+		Algorithm:      imap.ThreadReferences,
+		SearchCriteria: criteria,
+	}).Wait()
+	if err != nil {
+		return nil, fmt.Errorf("failed to thread messages: %w", err)
+	}
+
+	for _, t := range threads {
+		uids := flattenThread(t)
+		for _, u := range uids {
+			if u == seed.msg.UID {
+				return uids, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// threadSearchCriteria matches the messages that may share a thread with the seed:
+// those having, replying to or referencing one of its message IDs, or carrying its
+// normalized subject, received within threadDateWindow of it. It returns nil when
+// the seed has neither message IDs nor a subject.
+func threadSearchCriteria(seed *threadCandidate) *imap.SearchCriteria {
+	var alternatives []imap.SearchCriteria
+	if ids := pendingMessageIDs(map[string]*threadCandidate{"": seed}, nil); len(ids) > 0 {
+		alternatives = append(alternatives, *messageIDCriteria(ids))
+	}
+	if seed.msg.Envelope != nil {
+		if subject := NormalizeSubject(seed.msg.Envelope.Subject); subject != "" {
+			alternatives = append(alternatives, imap.SearchCriteria{
+				Header: []imap.SearchCriteriaHeaderField{{Key: "Subject", Value: subject}},
+			})
+		}
+	}
+	if len(alternatives) == 0 {
+		return nil
+	}
+
+	criteria := anyOfCriteria(alternatives)
+	if seed.msg.Envelope != nil && !seed.msg.Envelope.Date.IsZero() {
+		criteria.Since = seed.msg.Envelope.Date.Add(-threadDateWindow)
+		criteria.Before = seed.msg.Envelope.Date.Add(threadDateWindow)
+	}
+	return criteria
+}
+
+// flattenThread collects all UIDs of a thread tree
+func flattenThread(t imapclient.ThreadData) []imap.UID {
+	var uids []imap.UID
+	for _, n := range t.Chain {
+		uids = append(uids, imap.UID(n))
+	}
+	for _, sub := range t.SubThreads {
+		uids = append(uids, flattenThread(sub)...)
+	}
+	return uids
+}
+
+// pendingMessageIDs returns message IDs referenced by found messages that have not been searched yet
+func pendingMessageIDs(found map[string]*threadCandidate, searched map[string]bool) []string {
+	seen := make(map[string]bool)
+	var ids []string
+	add := func(id string) {
+		if id != "" && !searched[id] && !seen[id] && len(ids) < threadMaxSearchIDs {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	for _, candidate := range found {
+		if candidate.msg.Envelope != nil {
+			add(candidate.msg.Envelope.MessageID)
+			for _, id := range candidate.msg.Envelope.InReplyTo {
+				add(id)
+			}
+		}
+		for _, id := range candidate.references {
+			add(id)
+		}
+	}
+	return ids
+}
+
+// messageIDCriteria matches messages that have, reply to or reference any of the message IDs
+func messageIDCriteria(ids []string) *imap.SearchCriteria {
+	var alternatives []imap.SearchCriteria
+	for _, id := range ids {
+		value := "<" + id + ">"
+		for _, key := range []string{"Message-ID", "In-Reply-To", "References"} {
+			alternatives = append(alternatives, imap.SearchCriteria{
+				Header: []imap.SearchCriteriaHeaderField{{Key: key, Value: value}},
+			})
+		}
+	}
+
+	return anyOfCriteria(alternatives)
+}
+
+// anyOfCriteria combines non-empty alternatives with OR
+func anyOfCriteria(alternatives []imap.SearchCriteria) *imap.SearchCriteria {
+	criteria := alternatives[0]
+	for _, next := range alternatives[1:] {
+		criteria = imap.SearchCriteria{Or: [][2]imap.SearchCriteria{{criteria, next}}}
+	}
+	return &criteria
+}
+
+// searchThreadFolder searches a folder and fetches the matching messages
+func searchThreadFolder(client *imapclient.Client, folder string, criteria *imap.SearchCriteria) ([]*threadCandidate, error) {
+	if _, err := selectFolder(client, folder); err != nil {
+		return nil, err
+	}
+
+	data, err := client.UIDSearch(criteria, nil).Wait()
+	if err != nil {
+		return nil, fmt.Errorf("failed to search %s: %w", folder, err)
+	}
+	uids := data.AllUIDs()
+	if len(uids) == 0 {
+		return nil, nil
+	}
+
+	var uidSet imap.UIDSet
+	for _, uid := range uids {
+		uidSet.AddNum(uid)
+	}
+	return fetchThreadCandidates(client, folder, uidSet)
+}
+
+// fetchFolderCandidates selects folder and fetches the messages with the given UIDs
+func fetchFolderCandidates(client *imapclient.Client, folder string, uids []imap.UID) ([]*threadCandidate, error) {
+	if len(uids) == 0 {
+		return nil, nil
+	}
+	if _, err := selectFolder(client, folder); err != nil {
+		return nil, err
+	}
+
+	var uidSet imap.UIDSet
+	for _, uid := range uids {
+		uidSet.AddNum(uid)
+	}
+	return fetchThreadCandidates(client, folder, uidSet)
+}
+
+// fetchThreadCandidates fetches envelopes, flags and References headers from the selected folder
+func fetchThreadCandidates(client *imapclient.Client, folder string, uidSet imap.UIDSet) ([]*threadCandidate, error) {
+	referencesSection := &imap.FetchItemBodySection{
+		Specifier:    imap.PartSpecifierHeader,
+		HeaderFields: []string{"References"},
+		Peek:         true,
+	}
+
+	messages, err := client.Fetch(uidSet, &imap.FetchOptions{
+		Envelope:    true,
+		Flags:       true,
+		UID:         true,
+		BodySection: []*imap.FetchItemBodySection{referencesSection},
+	}).Collect()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch messages: %w", err)
+	}
+
+	candidates := make([]*threadCandidate, 0, len(messages))
+	for _, msg := range messages {
+		candidates = append(candidates, &threadCandidate{
+			folder:     folder,
+			msg:        msg,
+			references: parseReferences(msg.FindBodySection(referencesSection)),
+		})
+	}
+	return candidates, nil
+}
+
+// addThreadCandidates adds candidates to found, keeping the first copy of a message
+// seen in several folders (e.g. Inbox and Gmail's All Mail)
+func addThreadCandidates(found map[string]*threadCandidate, candidates []*threadCandidate) {
+	for _, candidate := range candidates {
+		key := candidateKey(candidate)
+		if _, ok := found[key]; !ok {
+			found[key] = candidate
+		}
+	}
+}
+
+// candidateKey identifies a message across folders by Message-ID, falling back to folder and UID
+func candidateKey(candidate *threadCandidate) string {
+	if candidate.msg.Envelope != nil && candidate.msg.Envelope.MessageID != "" {
+		return candidate.msg.Envelope.MessageID
+	}
+	return FormatEmailID(candidate.folder, candidate.msg.UID)
+}
+
+// newThreadMessage builds the thread entry for a candidate
+func newThreadMessage(candidate *threadCandidate) *ThreadMessage {
+	message := &ThreadMessage{
+		Email: *newEmailFromMessage(candidate.folder, candidate.msg),
+	}
+	if candidate.msg.Envelope != nil {
+		message.MessageID = candidate.msg.Envelope.MessageID
+		message.InReplyTo = candidate.msg.Envelope.InReplyTo
+		for _, addr := range candidate.msg.Envelope.To {
+			message.To = append(message.To, addr.Addr())
+		}
+	}
+	return message
+}
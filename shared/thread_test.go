@@ -0,0 +1,82 @@
+package shared
+
+import (
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+func TestNormalizeSubject(t *testing.T) {
+	tests := []struct {
+		subject string
+		want    string
+	}{
+		{"Plans", "Plans"},
+		{"Re: Plans", "Plans"},
+		{"RE: Fwd: Plans ", "Plans"},
+		{"AW: Re[2]: Plans", "Plans"},
+		{"Fw: sv: Plans", "Plans"},
+		{"Regarding plans", "Regarding plans"},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeSubject(tt.subject); got != tt.want {
+			t.Errorf("NormalizeSubject(%q) = %q, want %q", tt.subject, got, tt.want)
+		}
+	}
+}
+
+func TestThreadSearchCriteria(t *testing.T) {
+	date := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	seed := &threadCandidate{
+		folder: "INBOX",
+		msg: &imapclient.FetchMessageBuffer{
+			UID: 7,
+			Envelope: &imap.Envelope{
+				Subject:   "Re: Plans",
+				Date:      date,
+				MessageID: "b@x.com",
+				InReplyTo: []string{"a@x.com"},
+			},
+		},
+	}
+
+	criteria := threadSearchCriteria(seed)
+	if criteria == nil {
+		t.Fatal("threadSearchCriteria() = nil")
+	}
+	if !criteria.Since.Equal(date.Add(-threadDateWindow)) || !criteria.Before.Equal(date.Add(threadDateWindow)) {
+		t.Errorf("date window = %v .. %v, want around %v", criteria.Since, criteria.Before, date)
+	}
+
+	headers := make(map[string]bool)
+	var collect func(c imap.SearchCriteria)
+	collect = func(c imap.SearchCriteria) {
+		for _, h := range c.Header {
+			headers[h.Key+" "+h.Value] = true
+		}
+		for _, or := range c.Or {
+			collect(or[0])
+			collect(or[1])
+		}
+	}
+	collect(*criteria)
+
+	for _, want := range []string{
+		"Message-ID <b@x.com>",
+		"References <a@x.com>",
+		"In-Reply-To <b@x.com>",
+		"Subject Plans",
+	} {
+		if !headers[want] {
+			t.Errorf("criteria missing HEADER %s (have %v)", want, headers)
+		}
+	}
+
+	empty := &threadCandidate{msg: &imapclient.FetchMessageBuffer{UID: 1, Envelope: &imap.Envelope{}}}
+	if criteria := threadSearchCriteria(empty); criteria != nil {
+		t.Errorf("threadSearchCriteria(no IDs, no subject) = %+v, want nil", criteria)
+	}
+}
@@ -0,0 +1,81 @@
+package shared
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// TLSSettings configures certificate verification and client certificates
+// for both the IMAP and SMTP connections
+type TLSSettings struct {
+	// CAFile is a PEM bundle of additional certificate authorities to trust
+	CAFile string `json:"ca_file"`
+	// CertFile and KeyFile hold a PEM client certificate and its private key
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+	// ServerName overrides the host name verified in server certificates
+	ServerName string `json:"server_name"`
+	// MinVersion is the minimum TLS version: "1.0", "1.1", "1.2" (default) or "1.3"
+	MinVersion string `json:"min_version"`
+	// InsecureSkipVerify disables certificate verification; only for lab setups
+	InsecureSkipVerify bool `json:"insecure_skip_verify"`
+}
+
+// tlsVersions maps configured minimum versions to crypto/tls constants
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLSConfig builds the TLS configuration for a connection to server
+func (c *Config) TLSConfig(server string) (*tls.Config, error) {
+	settings := c.TLS
+
+	tlsConfig := &tls.Config{
+		ServerName:         server,
+		InsecureSkipVerify: settings.InsecureSkipVerify,
+	}
+	if settings.ServerName != "" {
+		tlsConfig.ServerName = settings.ServerName
+	}
+
+	if settings.MinVersion != "" {
+		version, ok := tlsVersions[settings.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("tls.min_version: invalid value %q: expected 1.0, 1.1, 1.2 or 1.3", settings.MinVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	if settings.CAFile != "" {
+		pem, err := os.ReadFile(settings.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls.ca_file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls.ca_file: no certificates found in %s", settings.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if settings.CertFile != "" || settings.KeyFile != "" {
+		if settings.CertFile == "" || settings.KeyFile == "" {
+			return nil, fmt.Errorf("tls.key_file: tls.cert_file and tls.key_file must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(settings.CertFile, settings.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls.cert_file: failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,252 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/mail"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ConfigError reports every problem found in the configuration, each starting with its JSON path
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("invalid configuration (%d problem(s)):\n  - %s", len(e.Problems), strings.Join(e.Problems, "\n  - "))
+}
+
+// configProblems collects validation problems so that all of them are reported at once
+type configProblems []string
+
+// add records a problem of the field at path
+func (p *configProblems) add(path, format string, args ...any) {
+	*p = append(*p, path+": "+fmt.Sprintf(format, args...))
+}
+
+// addErr records an error whose message already names the field, relative to prefix
+func (p *configProblems) addErr(prefix string, err error) {
+	*p = append(*p, prefix+err.Error())
+}
+
+// err returns the collected problems as a *ConfigError, or nil if there are none
+func (p configProblems) err() error {
+	if len(p) == 0 {
+		return nil
+	}
+	return &ConfigError{Problems: p}
+}
+
+// decodeConfig parses the config file into config. Unknown keys and values of
+// the wrong type are all recorded as problems; only malformed JSON is returned as an error.
+func decodeConfig(data []byte, config *Config, problems *configProblems) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	strictErr := decoder.Decode(config)
+	if strictErr == nil {
+		return nil
+	}
+
+	var syntaxErr *json.SyntaxError
+	if errors.As(strictErr, &syntaxErr) {
+		line := bytes.Count(data[:syntaxErr.Offset], []byte("\n")) + 1
+		return fmt.Errorf("failed to parse config file: %w (line %d)", strictErr, line)
+	}
+
+	// The decoders stop at the first unknown key and report only the first
+	// value of the wrong type, so check the whole document against the config
+	// type and decode again leniently to fill in the valid fields
+	var raw any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("failed to parse config file: %w", err)
+	}
+	mismatches := checkFields(raw, reflect.TypeOf(*config), "", problems)
+
+	*config = Config{}
+	if err := json.Unmarshal(data, config); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if !errors.As(err, &typeErr) {
+			return fmt.Errorf("failed to parse config file: %w", err)
+		}
+		if mismatches == 0 {
+			problems.add(typeErr.Field, "expected %s, got %s", jsonTypeName(typeErr.Type), typeErr.Value)
+		}
+	}
+	return nil
+}
+
+// checkFields records a problem for every key in data that matches no field
+// of t and for every value that does not fit its field. It returns the
+// number of values of the wrong type.
+func checkFields(data any, t reflect.Type, path string, problems *configProblems) int {
+	if data == nil || t.Kind() == reflect.Interface {
+		return 0
+	}
+	if t.Kind() == reflect.Pointer {
+		return checkFields(data, t.Elem(), path, problems)
+	}
+	if !jsonValueFits(data, t) {
+		problems.add(path, "expected %s, got %s", jsonTypeName(t), jsonValueName(data))
+		return 1
+	}
+
+	mismatches := 0
+	switch t.Kind() {
+	case reflect.Slice:
+		for i, item := range data.([]any) {
+			mismatches += checkFields(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), problems)
+		}
+
+	case reflect.Map:
+		object := data.(map[string]any)
+		for _, key := range sortedKeys(object) {
+			mismatches += checkFields(object[key], t.Elem(), joinPath(path, key), problems)
+		}
+
+	case reflect.Struct:
+		// encoding/json matches keys to field tags case-insensitively
+		fields := make(map[string]reflect.Type)
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			tag, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if field.IsExported() && tag != "" && tag != "-" {
+				fields[strings.ToLower(tag)] = field.Type
+			}
+		}
+
+		object := data.(map[string]any)
+		for _, key := range sortedKeys(object) {
+			fieldType, ok := fields[strings.ToLower(key)]
+			if !ok {
+				problems.add(joinPath(path, key), "unknown field")
+				continue
+			}
+			mismatches += checkFields(object[key], fieldType, joinPath(path, key), problems)
+		}
+	}
+	return mismatches
+}
+
+// jsonValueFits reports whether encoding/json can decode the JSON value data into type t
+func jsonValueFits(data any, t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String:
+		_, ok := data.(string)
+		return ok
+	case reflect.Bool:
+		_, ok := data.(bool)
+		return ok
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := data.(float64)
+		return ok && n == math.Trunc(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, ok := data.(float64)
+		return ok && n == math.Trunc(n) && n >= 0
+	case reflect.Float32, reflect.Float64:
+		_, ok := data.(float64)
+		return ok
+	case reflect.Slice:
+		_, ok := data.([]any)
+		return ok
+	case reflect.Map, reflect.Struct:
+		_, ok := data.(map[string]any)
+		return ok
+	}
+	return true
+}
+
+// jsonValueName describes a decoded JSON value the way encoding/json does in type errors
+func jsonValueName(data any) string {
+	switch v := data.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case float64:
+		return "number " + strconv.FormatFloat(v, 'g', -1, 64)
+	case []any:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// sortedKeys returns the keys of a JSON object in a stable order
+func sortedKeys(object map[string]any) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// joinPath appends a key to a JSON path
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// jsonTypeName describes a Go type by its JSON kind for error messages
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Slice:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
+
+// checkPort records a problem unless port is a valid TCP port
+func checkPort(problems *configProblems, path string, port int) {
+	if port < 1 || port > 65535 {
+		problems.add(path, "port %d is out of range 1-65535", port)
+	}
+}
+
+// checkAddress records a problem unless value is a single valid email address
+func checkAddress(problems *configProblems, path, value string) {
+	if _, err := mail.ParseAddress(value); err != nil {
+		problems.add(path, "invalid email address %q", value)
+	}
+}
+
+// validateGlobal checks the settings shared by all accounts
+func (c *Config) validateGlobal(problems *configProblems) {
+	checkPort(problems, "http.port", c.HTTP.Port)
+	if c.Notifications.CheckIntervalSeconds < 1 {
+		problems.add("notifications.check_interval_seconds", "must be at least 1")
+	}
+	if c.Attachments.MaxSizeMB < 1 {
+		problems.add("attachments.max_size_mb", "must be at least 1")
+	}
+	for _, name := range sortedContactNames(c.Contacts) {
+		checkAddress(problems, joinPath("contacts", name), c.Contacts[name])
+	}
+}
+
+// sortedContactNames returns the contact names in a stable order
+func sortedContactNames(contacts map[string]string) []string {
+	names := make([]string, 0, len(contacts))
+	for name := range contacts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
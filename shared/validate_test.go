@@ -0,0 +1,74 @@
+package shared
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeConfigReportsAllProblems(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{
+			name: "valid",
+			data: `{"my_email": "me@example.com", "http": {"port": 8080}}`,
+		},
+		{
+			name: "every type mismatch",
+			data: `{"http": {"host": 1, "port": "8080"}, "notifications": {"check_interval_seconds": 2.5, "disable_idle": "yes"}, "contacts": {"Bob": true}}`,
+			want: []string{
+				"contacts.Bob: expected a string, got bool",
+				"http.host: expected a string, got number 1",
+				`http.port: expected a number, got string`,
+				"notifications.check_interval_seconds: expected a number, got number 2.5",
+				"notifications.disable_idle: expected true or false, got string",
+			},
+		},
+		{
+			name: "unknown fields and mismatches together",
+			data: `{"smtp_server": "x", "identities": [{"address": "me@example.com", "signatur": "Me"}, "me@example.org"], "my_email": null}`,
+			want: []string{
+				"identities[0].signatur: unknown field",
+				"identities[1]: expected an object, got string",
+				"smtp_server: unknown field",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config Config
+			var problems configProblems
+			if err := decodeConfig([]byte(tt.data), &config, &problems); err != nil {
+				t.Fatalf("decodeConfig() error: %v", err)
+			}
+			if !reflect.DeepEqual([]string(problems), tt.want) {
+				t.Errorf("problems =\n%q\nwant\n%q", []string(problems), tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeConfigKeepsValidFields(t *testing.T) {
+	var config Config
+	var problems configProblems
+	data := `{"my_email": "me@example.com", "http": {"host": 1, "port": 9000}}`
+	if err := decodeConfig([]byte(data), &config, &problems); err != nil {
+		t.Fatalf("decodeConfig() error: %v", err)
+	}
+	if config.MyEmail != "me@example.com" || config.HTTP.Port != 9000 {
+		t.Errorf("config = %q, %d, want the valid fields decoded", config.MyEmail, config.HTTP.Port)
+	}
+	if len(problems) != 1 {
+		t.Errorf("problems = %q, want only http.host", []string(problems))
+	}
+}
+
+func TestDecodeConfigSyntaxError(t *testing.T) {
+	var config Config
+	if err := decodeConfig([]byte("{\n\"my_email\": \"a\",\n}"), &config, &configProblems{}); err == nil {
+		t.Error("decodeConfig() error = nil, want a syntax error")
+	}
+}
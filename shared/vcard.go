@@ -0,0 +1,324 @@
+package shared
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// vcardLineLength is the maximum line length in octets before a vCard line is folded (RFC 6350 3.2)
+const vcardLineLength = 75
+
+// vcardProperty is one content line of a vCard, e.g. EMAIL;TYPE=work:jane@example.com
+type vcardProperty struct {
+	name   string
+	params map[string][]string
+	value  string
+}
+
+// parseVCards reads the contacts of a vCard 3.0 or 4.0 file. Properties other
+// than FN, N, EMAIL, NICKNAME, ORG, NOTE and CATEGORIES are ignored.
+func parseVCards(data []byte) ([]Contact, error) {
+	var contacts []Contact
+	var card []vcardProperty
+	inCard := false
+
+	for i, line := range unfoldVCardLines(data) {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		property, err := parseVCardLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("vCard line %d: %w", i+1, err)
+		}
+
+		switch {
+		case property.name == "BEGIN" && strings.EqualFold(property.value, "VCARD"):
+			if inCard {
+				return nil, fmt.Errorf("vCard line %d: BEGIN:VCARD inside another vCard", i+1)
+			}
+			inCard = true
+			card = nil
+		case property.name == "END" && strings.EqualFold(property.value, "VCARD"):
+			if !inCard {
+				return nil, fmt.Errorf("vCard line %d: END:VCARD without BEGIN:VCARD", i+1)
+			}
+			inCard = false
+			contacts = append(contacts, vcardContact(card))
+		case inCard:
+			card = append(card, property)
+		}
+	}
+	if inCard {
+		return nil, fmt.Errorf("vCard is missing END:VCARD")
+	}
+	if len(contacts) == 0 {
+		return nil, fmt.Errorf("no vCards found")
+	}
+	return contacts, nil
+}
+
+// unfoldVCardLines splits data into content lines, joining folded continuation lines
+func unfoldVCardLines(data []byte) []string {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// parseVCardLine splits a content line into its name, parameters and raw value
+func parseVCardLine(line string) (vcardProperty, error) {
+	// The value starts at the first colon outside a quoted parameter value
+	quoted := false
+	colon := -1
+	for i, r := range line {
+		if r == '"' {
+			quoted = !quoted
+		} else if r == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return vcardProperty{}, fmt.Errorf("missing ':' in %q", line)
+	}
+
+	fields := splitUnquoted(line[:colon], ';')
+	name := strings.ToUpper(strings.TrimSpace(fields[0]))
+	// Drop the property group, as in item1.EMAIL
+	if dot := strings.LastIndex(name, "."); dot >= 0 {
+		name = name[dot+1:]
+	}
+
+	params := make(map[string][]string)
+	for _, field := range fields[1:] {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			// vCard 2.1 style bare parameter, e.g. EMAIL;PREF;INTERNET
+			key, value = "TYPE", key
+		}
+		key = strings.ToUpper(strings.TrimSpace(key))
+		for _, v := range splitUnquoted(value, ',') {
+			params[key] = append(params[key], strings.Trim(strings.TrimSpace(v), `"`))
+		}
+	}
+
+	return vcardProperty{name: name, params: params, value: line[colon+1:]}, nil
+}
+
+// splitUnquoted splits s at sep, except inside double quotes
+func splitUnquoted(s string, sep rune) []string {
+	var parts []string
+	var current strings.Builder
+	quoted := false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == sep && !quoted:
+			parts = append(parts, current.String())
+			current.Reset()
+			continue
+		}
+		current.WriteRune(r)
+	}
+	return append(parts, current.String())
+}
+
+// vcardContact builds a contact from the properties of one vCard
+func vcardContact(card []vcardProperty) Contact {
+	var contact Contact
+	var structuredName []string
+
+	type email struct {
+		address string
+		pref    int
+	}
+	var emails []email
+
+	for _, property := range card {
+		switch property.name {
+		case "FN":
+			contact.Name = vcardText(property.value)
+		case "N":
+			structuredName = splitVCardValue(property.value, ';')
+		case "EMAIL":
+			address := strings.TrimPrefix(vcardText(property.value), "mailto:")
+			if address != "" {
+				emails = append(emails, email{address: address, pref: vcardPreference(property.params)})
+			}
+		case "NICKNAME":
+			contact.Nicknames = appendNonEmpty(contact.Nicknames, splitVCardValue(property.value, ',')...)
+		case "ORG":
+			// ORG is organization;unit;... and only the organization name is kept
+			if contact.Organization == "" {
+				contact.Organization = strings.TrimSpace(splitVCardValue(property.value, ';')[0])
+			}
+		case "NOTE":
+			contact.Notes = strings.TrimSpace(strings.Join([]string{contact.Notes, vcardText(property.value)}, "\n"))
+		case "CATEGORIES":
+			contact.Groups = appendNonEmpty(contact.Groups, splitVCardValue(property.value, ',')...)
+		}
+	}
+
+	// N is family;given;additional;prefix;suffix
+	if contact.Name == "" && len(structuredName) > 0 {
+		parts := []string{}
+		for _, i := range []int{3, 1, 2, 0, 4} {
+			if i < len(structuredName) {
+				parts = appendNonEmpty(parts, structuredName[i])
+			}
+		}
+		contact.Name = strings.Join(parts, " ")
+	}
+	if contact.Name == "" {
+		contact.Name = contact.Organization
+	}
+
+	sort.SliceStable(emails, func(i, j int) bool { return emails[i].pref < emails[j].pref })
+	for _, e := range emails {
+		contact.Emails = append(contact.Emails, e.address)
+	}
+	return contact
+}
+
+// vcardPreference ranks an EMAIL property: PREF=1 (vCard 4.0) or TYPE=pref (vCard 3.0)
+// come first, lower numbers being more preferred; emails without preference come last
+func vcardPreference(params map[string][]string) int {
+	for _, value := range params["PREF"] {
+		if pref, err := strconv.Atoi(value); err == nil {
+			return pref
+		}
+	}
+	if containsFold(params["TYPE"], "pref") {
+		return 1
+	}
+	return 101
+}
+
+// splitVCardValue splits a structured or list value at unescaped separators and unescapes the parts
+func splitVCardValue(value string, sep byte) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case sep:
+			parts = append(parts, vcardText(value[start:i]))
+			start = i + 1
+		}
+	}
+	return append(parts, vcardText(value[start:]))
+}
+
+// vcardText unescapes a text value
+func vcardText(value string) string {
+	var sb strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+1 < len(value) {
+			i++
+			switch value[i] {
+			case 'n', 'N':
+				sb.WriteByte('\n')
+			default:
+				sb.WriteByte(value[i])
+			}
+			continue
+		}
+		sb.WriteByte(value[i])
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// appendNonEmpty appends the trimmed values that are not empty
+func appendNonEmpty(list []string, values ...string) []string {
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			list = append(list, value)
+		}
+	}
+	return list
+}
+
+// formatVCards writes contacts as vCard 3.0, the version phones and mail clients import most reliably
+func formatVCards(contacts []ContactInfo) string {
+	var sb strings.Builder
+	for _, contact := range contacts {
+		writeVCardLine(&sb, "BEGIN:VCARD")
+		writeVCardLine(&sb, "VERSION:3.0")
+		writeVCardLine(&sb, "FN:"+escapeVCardText(contact.Name))
+
+		// Contacts have a single name, so N takes the last word as the family name
+		given, family := "", contact.Name
+		if i := strings.LastIndex(contact.Name, " "); i >= 0 {
+			given, family = contact.Name[:i], contact.Name[i+1:]
+		}
+		writeVCardLine(&sb, "N:"+escapeVCardText(family)+";"+escapeVCardText(given)+";;;")
+
+		if len(contact.Nicknames) > 0 {
+			writeVCardLine(&sb, "NICKNAME:"+joinVCardList(contact.Nicknames))
+		}
+		for i, email := range contact.Emails {
+			if i == 0 {
+				writeVCardLine(&sb, "EMAIL;TYPE=INTERNET,pref:"+escapeVCardText(email))
+			} else {
+				writeVCardLine(&sb, "EMAIL;TYPE=INTERNET:"+escapeVCardText(email))
+			}
+		}
+		if contact.Organization != "" {
+			writeVCardLine(&sb, "ORG:"+escapeVCardText(contact.Organization))
+		}
+		if contact.Notes != "" {
+			writeVCardLine(&sb, "NOTE:"+escapeVCardText(contact.Notes))
+		}
+		if len(contact.Groups) > 0 {
+			writeVCardLine(&sb, "CATEGORIES:"+joinVCardList(contact.Groups))
+		}
+		writeVCardLine(&sb, "END:VCARD")
+	}
+	return sb.String()
+}
+
+// writeVCardLine writes a content line, folding it at vcardLineLength octets without splitting UTF-8 sequences
+func writeVCardLine(sb *strings.Builder, line string) {
+	limit := vcardLineLength
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		sb.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		// Continuation lines start with a space that counts towards the limit
+		limit = vcardLineLength - 1
+	}
+	sb.WriteString(line + "\r\n")
+}
+
+// joinVCardList escapes and joins the values of a list property
+func joinVCardList(values []string) string {
+	escaped := make([]string, len(values))
+	for i, value := range values {
+		escaped[i] = escapeVCardText(value)
+	}
+	return strings.Join(escaped, ",")
+}
+
+// escapeVCardText escapes a text value
+func escapeVCardText(value string) string {
+	value = strings.ReplaceAll(value, "\r\n", "\n")
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, ",", `\,`, ";", `\;`).Replace(value)
+}
@@ -0,0 +1,194 @@
+package shared
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestParseVCards(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []Contact
+	}{
+		{
+			name: "vCard 4.0 with PREF ordering",
+			data: "BEGIN:VCARD\r\nVERSION:4.0\r\nFN:Jane Doe\r\n" +
+				"EMAIL;TYPE=home:jane@home.example\r\n" +
+				"EMAIL;PREF=2:jane@work.example\r\n" +
+				"EMAIL;PREF=1:jane@example.com\r\n" +
+				"END:VCARD\r\n",
+			want: []Contact{{
+				Name:   "Jane Doe",
+				Emails: []string{"jane@example.com", "jane@work.example", "jane@home.example"},
+			}},
+		},
+		{
+			name: "vCard 3.0 TYPE=pref and 2.1 bare parameters",
+			data: "BEGIN:VCARD\nVERSION:3.0\nFN:Bob\n" +
+				"EMAIL;TYPE=INTERNET:bob@other.example\n" +
+				"EMAIL;TYPE=INTERNET,pref:bob@example.com\n" +
+				"END:VCARD\n" +
+				"BEGIN:VCARD\nVERSION:2.1\nFN:Carol\n" +
+				"EMAIL;INTERNET:carol@other.example\n" +
+				"EMAIL;PREF;INTERNET:carol@example.com\n" +
+				"END:VCARD\n",
+			want: []Contact{
+				{Name: "Bob", Emails: []string{"bob@example.com", "bob@other.example"}},
+				{Name: "Carol", Emails: []string{"carol@example.com", "carol@other.example"}},
+			},
+		},
+		{
+			name: "folded lines, escapes and groups",
+			data: "\xef\xbb\xbfBEGIN:VCARD\r\nVERSION:3.0\r\nFN:Dan\r\n" +
+				"item1.EMAIL;TYPE=\"home,pref\":mailto:dan@exa\r\n mple.com\r\n" +
+				"NOTE:Met at the conference\\, Berlin\\nCall in\r\n\t spring\r\n" +
+				"NICKNAME:Danny,D\r\n" +
+				"CATEGORIES:Friends,Work\\,Team\r\n" +
+				"ORG:Acme Inc.;Sales\r\n" +
+				"END:VCARD\r\n",
+			want: []Contact{{
+				Name:         "Dan",
+				Emails:       []string{"dan@example.com"},
+				Nicknames:    []string{"Danny", "D"},
+				Organization: "Acme Inc.",
+				Notes:        "Met at the conference, Berlin\nCall in spring",
+				Groups:       []string{"Friends", "Work,Team"},
+			}},
+		},
+		{
+			name: "quoted parameter with colon",
+			data: "BEGIN:VCARD\nFN:Eve\nEMAIL;X-LABEL=\"a:b\":eve@example.com\nEND:VCARD\n",
+			want: []Contact{{Name: "Eve", Emails: []string{"eve@example.com"}}},
+		},
+		{
+			name: "N fallback without FN",
+			data: "BEGIN:VCARD\nVERSION:3.0\nN:Doe;John;Quincy;Dr.;Jr.\nEMAIL:john@example.com\nEND:VCARD\n",
+			want: []Contact{{Name: "Dr. John Quincy Doe Jr.", Emails: []string{"john@example.com"}}},
+		},
+		{
+			name: "organization fallback without FN or N",
+			data: "BEGIN:VCARD\nORG:Acme\nEMAIL:info@acme.example\nEND:VCARD\n",
+			want: []Contact{{Name: "Acme", Organization: "Acme", Emails: []string{"info@acme.example"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseVCards([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("parseVCards() error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseVCards() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseVCardsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"empty", ""},
+		{"missing END", "BEGIN:VCARD\nFN:Jane\n"},
+		{"END without BEGIN", "FN:Jane\nEND:VCARD\n"},
+		{"nested BEGIN", "BEGIN:VCARD\nBEGIN:VCARD\nEND:VCARD\n"},
+		{"missing colon", "BEGIN:VCARD\nFN Jane\nEND:VCARD\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseVCards([]byte(tt.data)); err == nil {
+				t.Error("parseVCards() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestWriteVCardLineFolding(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+	}{
+		{"short", "FN:Jane Doe"},
+		{"exactly 75", "NOTE:" + strings.Repeat("a", 70)},
+		{"long ASCII", "NOTE:" + strings.Repeat("abcdefghij", 20)},
+		{"long UTF-8", "NOTE:" + strings.Repeat("Zürich – Ελλάδα ", 12)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sb strings.Builder
+			writeVCardLine(&sb, tt.line)
+			out := sb.String()
+
+			if !strings.HasSuffix(out, "\r\n") {
+				t.Fatalf("output %q does not end with CRLF", out)
+			}
+			physical := strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n")
+			for i, line := range physical {
+				if len(line) > vcardLineLength {
+					t.Errorf("line %d is %d octets, want at most %d", i, len(line), vcardLineLength)
+				}
+				if i > 0 && !strings.HasPrefix(line, " ") {
+					t.Errorf("continuation line %d %q does not start with a space", i, line)
+				}
+				if !utf8.ValidString(line) {
+					t.Errorf("line %d %q splits a UTF-8 sequence", i, line)
+				}
+			}
+
+			if unfolded := unfoldVCardLines([]byte(out)); len(unfolded) != 1 || unfolded[0] != tt.line {
+				t.Errorf("unfolded = %q, want %q", unfolded, tt.line)
+			}
+		})
+	}
+}
+
+func TestVCardRoundTrip(t *testing.T) {
+	contacts := []Contact{
+		{
+			Name:         "Jane Doe",
+			Emails:       []string{"jane@example.com", "jane.doe@work.example"},
+			Nicknames:    []string{"JD", "Janie"},
+			Organization: "Acme; Inc.",
+			Notes:        "Prefers email, not calls\nTime zone: CET\\UTC+1" + strings.Repeat(" long note", 10),
+			Groups:       []string{"Friends", "Work, Team"},
+		},
+		{
+			Name:   "Łukasz Żółć",
+			Emails: []string{"lukasz@example.pl"},
+		},
+	}
+
+	source := loadContactBook(filepath.Join(t.TempDir(), "contacts.json"), nil, &configProblems{})
+	for _, contact := range contacts {
+		if err := source.Add(contact); err != nil {
+			t.Fatalf("Add(%s) error: %v", contact.Name, err)
+		}
+	}
+
+	exported := source.Export("", "")
+	parsed, err := ParseContacts([]byte(exported), ContactFormat("contacts.vcf", nil))
+	if err != nil {
+		t.Fatalf("ParseContacts() error: %v\n%s", err, exported)
+	}
+
+	target := loadContactBook(filepath.Join(t.TempDir(), "contacts.json"), nil, &configProblems{})
+	result, err := target.Import(parsed, false, false)
+	if err != nil {
+		t.Fatalf("Import() error: %v", err)
+	}
+	if len(result.Imported) != len(contacts) || len(result.Duplicates) > 0 || len(result.Skipped) > 0 {
+		t.Fatalf("Import() = %+v, want all %d contacts imported", result, len(contacts))
+	}
+
+	if got, want := target.List("", ""), source.List("", ""); !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip =\n%+v\nwant\n%+v", got, want)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
 	"os"
 	"os/signal"
@@ -12,8 +13,11 @@ import (
 )
 
 func main() {
+	configPath := flag.String("config", "", "path to config.json (default: $"+shared.ConfigPathEnv+", or config.json next to the binary)")
+	flag.Parse()
+
 	// Load configuration
-	config, err := shared.LoadConfig("")
+	config, err := shared.LoadConfig(*configPath)
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
@@ -39,8 +43,12 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Start notification checker
-	shared.StartEmailNotificationChecker(ctx, mcpServer, config)
+	// Start notification checkers
+	checkers := shared.StartEmailNotificationCheckers(ctx, mcpServer, config)
+
+	// Reload the configuration when config.json changes or on SIGHUP
+	reloader := shared.NewConfigReloader(ctx, *configPath, config, mcpServer, checkers)
+	go reloader.Watch()
 
 	// Handle shutdown signals
 	sigChan := make(chan os.Signal, 1)
@@ -50,6 +58,8 @@ func main() {
 		<-sigChan
 		log.Println("Shutting down...")
 		cancel()
+		shared.CloseIMAPConnections()
+		shared.FlushCorrespondents()
 		os.Exit(0)
 	}()
 
@@ -60,4 +70,6 @@ func main() {
 	if err := stdioServer.Listen(ctx, os.Stdin, os.Stdout); err != nil {
 		log.Fatalf("Server error: %v", err)
 	}
+	// The client closed stdin
+	shared.FlushCorrespondents()
 }